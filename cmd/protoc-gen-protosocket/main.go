@@ -0,0 +1,199 @@
+// Comando protoc-gen-protosocket gera, a partir dos services de um .proto, um
+// cliente tipado e a função de registro do servidor sobre o protosocket.
+//
+// Uso:
+//
+//	protoc --go_out=. --protosocket_out=. user.proto
+//
+// Para cada service UserService são gerados:
+//
+//   - UserServiceClient e NewUserServiceClient(conn), com um método por RPC;
+//   - UserServiceServer, a interface a implementar, e
+//     RegisterUserServiceServer(server, impl).
+//
+// Métodos unários usam EmitWithAck e os demais usam os streams do protosocket.
+// O evento de cada método é o nome completo, como "user.UserService/GetUser".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const version = "0.1.0"
+
+const (
+	contextPackage     = protogen.GoImportPath("context")
+	protosocketPackage = protogen.GoImportPath("github.com/mendes113/protosocket/protosocket")
+)
+
+func main() {
+	showVersion := flag.Bool("version", false, "mostra a versão e sai")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("protoc-gen-protosocket", version)
+		return
+	}
+
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, f := range gen.Files {
+			if f.Generate && len(f.Services) > 0 {
+				generateFile(gen, f)
+			}
+		}
+		return nil
+	})
+}
+
+func generateFile(gen *protogen.Plugin, file *protogen.File) {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_protosocket.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-protosocket. DO NOT EDIT.")
+	g.P("// versão: protoc-gen-protosocket ", version)
+	g.P("// fonte: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+}
+
+// methodEvent é o nome do evento usado por um método.
+func methodEvent(method *protogen.Method) string {
+	return fmt.Sprintf("%s/%s", method.Parent.Desc.FullName(), method.Desc.Name())
+}
+
+func eventConst(method *protogen.Method) string {
+	return fmt.Sprintf("%s_%s_Event", method.Parent.GoName, method.GoName)
+}
+
+func isUnary(method *protogen.Method) bool {
+	return !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer()
+}
+
+func isServerStream(method *protogen.Method) bool {
+	return !method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer()
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	ps := func(name string) string { return g.QualifiedGoIdent(protosocketPackage.Ident(name)) }
+
+	// Eventos
+	g.P("const (")
+	for _, method := range service.Methods {
+		g.P(eventConst(method), " = ", fmt.Sprintf("%q", methodEvent(method)))
+	}
+	g.P(")")
+	g.P()
+
+	// Cliente
+	clientName := service.GoName + "Client"
+	g.P("// ", clientName, " é o cliente tipado do service ", service.Desc.FullName(), ".")
+	g.P("type ", clientName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, clientSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+
+	implName := lowerFirst(clientName)
+	g.P("type ", implName, " struct {")
+	g.P("conn ", ps("RPCConn"))
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " cria o cliente sobre um *protosocket.Client ou *protosocket.Socket.")
+	g.P("func New", clientName, "(conn ", ps("RPCConn"), ") ", clientName, " {")
+	g.P("return &", implName, "{conn: conn}")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		g.P("func (c *", implName, ") ", clientSignature(g, method), " {")
+		switch {
+		case isUnary(method):
+			g.P("out := new(", method.Output.GoIdent, ")")
+			g.P("if err := ", ps("Invoke"), "(ctx, c.conn, ", eventConst(method), ", in, out, opts...); err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return out, nil")
+		case isServerStream(method):
+			g.P("return ", ps("InvokeStream"), "[*", method.Output.GoIdent, "](ctx, c.conn, ", eventConst(method), ", in, opts...)")
+		default:
+			g.P("return c.conn.OpenStream(ctx, ", eventConst(method), ", opts...)")
+		}
+		g.P("}")
+		g.P()
+	}
+
+	// Servidor
+	serverName := service.GoName + "Server"
+	g.P("// ", serverName, " é a implementação do service ", service.Desc.FullName(), ".")
+	g.P("// O Socket de quem chamou é obtido com protosocket.SocketFromContext.")
+	g.P("type ", serverName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// Register", serverName, " registra os métodos de impl em um *protosocket.Server")
+	g.P("// (para todos os sockets) ou em um *protosocket.Socket.")
+	g.P("func Register", serverName, "(s ", ps("RPCServer"), ", impl ", serverName, ") {")
+	for _, method := range service.Methods {
+		switch {
+		case isUnary(method):
+			g.P("s.OnWithAck(", eventConst(method), ", ", ps("UnaryHandler"), "(impl.", method.GoName, "))")
+		case isServerStream(method):
+			g.P("s.OnStream(", eventConst(method), ", ", ps("ServerStreamHandler"), "(impl.", method.GoName, "))")
+		default:
+			g.P("s.OnStream(", eventConst(method), ", ", ps("StreamHandler"), "(impl.", method.GoName, "))")
+		}
+	}
+	g.P("}")
+	g.P()
+}
+
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	ps := func(name string) string { return g.QualifiedGoIdent(protosocketPackage.Ident(name)) }
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+
+	switch {
+	case isUnary(method):
+		return fmt.Sprintf("%s(ctx %s, in *%s, opts ...%s) (*%s, error)", method.GoName, ctx, in, ps("EmitOption"), out)
+	case isServerStream(method):
+		return fmt.Sprintf("%s(ctx %s, in *%s, opts ...%s) (*%s[*%s], error)", method.GoName, ctx, in, ps("StreamOption"), ps("ClientStream"), out)
+	default:
+		return fmt.Sprintf("%s(ctx %s, opts ...%s) (*%s, error)", method.GoName, ctx, ps("StreamOption"), ps("Stream"))
+	}
+}
+
+func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	ps := func(name string) string { return g.QualifiedGoIdent(protosocketPackage.Ident(name)) }
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+
+	switch {
+	case isUnary(method):
+		return fmt.Sprintf("%s(ctx %s, in *%s) (*%s, error)", method.GoName, ctx, in, out)
+	case isServerStream(method):
+		return fmt.Sprintf("%s(in *%s, stream *%s[*%s]) error", method.GoName, in, ps("ServerStream"), out)
+	default:
+		return fmt.Sprintf("%s(stream *%s) error", method.GoName, ps("Stream"))
+	}
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// userProto descreve um service com os três tipos de método.
+func userProto() *descriptorpb.FileDescriptorProto {
+	message := func(name string) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	}
+	method := func(name string, clientStream, serverStream bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".user.GetUserRequest"),
+			OutputType:      proto.String(".user.User"),
+			ClientStreaming: proto.Bool(clientStream),
+			ServerStreaming: proto.Bool(serverStream),
+		}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("user.proto"),
+		Package:     proto.String("user"),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/user")},
+		MessageType: []*descriptorpb.DescriptorProto{message("GetUserRequest"), message("User")},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("UserService"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetUser", false, false),
+				method("ListUsers", false, true),
+				method("Chat", true, true),
+			},
+		}},
+	}
+}
+
+func TestGenerateFile(t *testing.T) {
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"user.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{userProto()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generateFile(gen, gen.Files[0])
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "example.com/user/user_protosocket.pb.go" {
+		t.Fatalf("arquivos gerados = %v", resp.File)
+	}
+
+	code := resp.File[0].GetContent()
+	if _, err := parser.ParseFile(token.NewFileSet(), "user_protosocket.pb.go", code, 0); err != nil {
+		t.Fatalf("código gerado inválido: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"evento pelo nome completo", `"user.UserService/GetUser"`},
+		{"construtor do cliente", "func NewUserServiceClient(conn protosocket.RPCConn) UserServiceClient"},
+		{"unário usa Invoke", "protosocket.Invoke(ctx, c.conn, UserService_GetUser_Event, in, out, opts...)"},
+		{"stream do servidor no cliente", "protosocket.InvokeStream[*User](ctx, c.conn, UserService_ListUsers_Event, in, opts...)"},
+		{"bidirecional abre um stream", "Chat(ctx context.Context, opts ...protosocket.StreamOption) (*protosocket.Stream, error)"},
+		{"stream do servidor na interface", "ListUsers(in *GetUserRequest, stream *protosocket.ServerStream[*User]) error"},
+		{"registro unário", "s.OnWithAck(UserService_GetUser_Event, protosocket.UnaryHandler(impl.GetUser))"},
+		{"registro bidirecional", "s.OnStream(UserService_Chat_Event, protosocket.StreamHandler(impl.Chat))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(code, tt.want) {
+				t.Errorf("código gerado não contém %q", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package protosocket
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrConnectionClosed = errors.New("conexão encerrada")
+	ErrNoHandler        = errors.New("nenhum handler registrado para o evento")
+)
+
+// RemoteError é o erro devolvido pelo handler do outro lado de um EmitWithAck.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "erro remoto: " + e.Message
+}
+
+// ackReply é a resposta recebida para um EmitWithAck pendente.
+type ackReply struct {
+	data     []byte
+	dataType string
+	err      string
+	local    error
+}
+
+// decode reconstrói a mensagem de resposta a partir do nome do tipo enviado.
+func (r ackReply) decode() (proto.Message, error) {
+	if r.local != nil {
+		return nil, r.local
+	}
+	if r.err != "" {
+		if r.dataType == dataTypeOf((*ValidationError)(nil)) {
+			var invalid ValidationError
+			if err := proto.Unmarshal(r.data, &invalid); err == nil {
+				return nil, &invalid
+			}
+		}
+		return nil, &RemoteError{Message: r.err}
+	}
+	if r.dataType == "" {
+		return nil, nil
+	}
+
+	return DefaultRegistry.Decode("", r.dataType, r.data)
+}
+
+// ackTracker guarda os EmitWithAck aguardando resposta.
+type ackTracker struct {
+	pending map[string]chan ackReply
+	closed  bool
+	lock    sync.Mutex
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{
+		pending: make(map[string]chan ackReply),
+	}
+}
+
+// register cria um novo ID de correlação e o canal onde a resposta será entregue.
+func (t *ackTracker) register() (string, chan ackReply, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return "", nil, ErrConnectionClosed
+	}
+
+	id := uuid.New().String()
+	ch := make(chan ackReply, 1)
+	t.pending[id] = ch
+	return id, ch, nil
+}
+
+// resolve entrega a resposta ao EmitWithAck correspondente, se ainda estiver aguardando.
+func (t *ackTracker) resolve(id string, reply ackReply) bool {
+	t.lock.Lock()
+	ch, ok := t.pending[id]
+	delete(t.pending, id)
+	t.lock.Unlock()
+
+	if ok {
+		ch <- reply
+	}
+	return ok
+}
+
+func (t *ackTracker) cancel(id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.pending, id)
+}
+
+// reopen volta a aceitar registros depois de uma reconexão.
+func (t *ackTracker) reopen() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = false
+}
+
+// failAll encerra todos os pendentes, usado quando a conexão cai.
+func (t *ackTracker) failAll(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	for id, ch := range t.pending {
+		ch <- ackReply{local: err}
+		delete(t.pending, id)
+	}
+}
+
+// dataTypeOf retorna o nome completo do tipo protobuf, ou vazio para nil.
+func dataTypeOf(msg proto.Message) string {
+	if msg == nil {
+		return ""
+	}
+	return string(msg.ProtoReflect().Descriptor().FullName())
+}
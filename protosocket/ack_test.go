@@ -0,0 +1,186 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// socketPair conecta um Socket cliente ao servidor e devolve as duas pontas.
+func socketPair(t *testing.T, server *Server) (client, remote *Socket) {
+	t.Helper()
+	connected := make(chan *Socket, 1)
+	// Preserva o OnConnection que o teste já tenha registrado
+	previous := server.onConnection
+	server.OnConnection(func(socket *Socket) {
+		if previous != nil {
+			previous(socket)
+		}
+		connected <- socket
+	})
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewSocket(conn, "cliente")
+	go client.Listen()
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case remote = <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("servidor não recebeu a conexão")
+	}
+	return client, remote
+}
+
+func TestAckReplyDecode(t *testing.T) {
+	data, err := proto.Marshal(&ChatMessage{Content: "oi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		reply   ackReply
+		content string
+		remote  bool
+		err     error
+	}{
+		{"resposta tipada", ackReply{data: data, dataType: dataTypeOf(&ChatMessage{})}, "oi", false, nil},
+		{"sem resposta", ackReply{}, "", false, nil},
+		{"erro remoto", ackReply{err: "falhou"}, "", true, nil},
+		{"erro local", ackReply{local: ErrConnectionClosed}, "", false, ErrConnectionClosed},
+		{"tipo desconhecido", ackReply{data: data, dataType: "nada.Existe"}, "", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.reply.decode()
+			var remoteErr *RemoteError
+			if got := errors.As(err, &remoteErr); got != tt.remote {
+				t.Fatalf("erro remoto = %v, esperado %v (%v)", got, tt.remote, err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if tt.reply.dataType == "nada.Existe" && err == nil {
+				t.Fatal("tipo desconhecido decodificado sem erro")
+			}
+			if tt.content == "" {
+				return
+			}
+			if chat, ok := msg.(*ChatMessage); !ok || chat.Content != tt.content {
+				t.Errorf("resposta = %v, esperado %q", msg, tt.content)
+			}
+		})
+	}
+}
+
+func TestAckTrackerFailAll(t *testing.T) {
+	acks := newAckTracker()
+	id, replyCh, err := acks.register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acks.failAll(ErrConnectionClosed)
+	if _, err := (<-replyCh).decode(); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("pendente = %v, esperado %v", err, ErrConnectionClosed)
+	}
+	if acks.resolve(id, ackReply{}) {
+		t.Error("resposta entregue depois do encerramento")
+	}
+	if _, _, err := acks.register(); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("register = %v, esperado %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestSocketEmitWithAck(t *testing.T) {
+	server := NewServer()
+	server.OnWithAck("chat", func(data proto.Message, socket *Socket) (proto.Message, error) {
+		content := data.(*ChatMessage).Content
+		switch content {
+		case "erro":
+			return nil, errors.New("recusado")
+		case "silêncio":
+			time.Sleep(time.Second)
+		}
+		return &ChatMessage{Content: "eco: " + content}, nil
+	})
+	server.On("binary", func(proto.Message, *Socket) {})
+	client, _ := socketPair(t, server)
+
+	tests := []struct {
+		name    string
+		event   string
+		content string
+		reply   string
+		remote  string
+		err     error
+	}{
+		{"resposta do handler", "chat", "oi", "eco: oi", "", nil},
+		{"erro do handler", "chat", "erro", "", "recusado", nil},
+		{"handler sem resposta confirma", "binary", "", "", "", nil},
+		{"prazo esgotado", "chat", "silêncio", "", "", context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			var msg proto.Message = &ChatMessage{Content: tt.content}
+			if tt.event == "binary" {
+				msg = &BinaryMessage{}
+			}
+			resp, err := client.EmitWithAck(ctx, tt.event, msg)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("erro = %v, esperado %v", err, tt.err)
+				}
+				return
+			}
+			if tt.remote != "" {
+				var remoteErr *RemoteError
+				if !errors.As(err, &remoteErr) || remoteErr.Message != tt.remote {
+					t.Fatalf("erro = %v, esperado erro remoto %q", err, tt.remote)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.reply == "" {
+				if resp != nil {
+					t.Errorf("resposta = %v, esperado nenhuma", resp)
+				}
+				return
+			}
+			if chat, ok := resp.(*ChatMessage); !ok || chat.Content != tt.reply {
+				t.Errorf("resposta = %v, esperado %q", resp, tt.reply)
+			}
+		})
+	}
+}
+
+func TestSocketEmitWithAckNoHandler(t *testing.T) {
+	client, _ := socketPair(t, NewServer())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "oi"})
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Message != ErrNoHandler.Error() {
+		t.Errorf("erro = %v, esperado %v", err, ErrNoHandler)
+	}
+}
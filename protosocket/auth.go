@@ -3,10 +3,15 @@ package protosocket
 import (
 	"context"
 	"errors"
+	"net/http"
+	"strings"
 
+	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 )
 
+var ErrMissingToken = errors.New("token não informado")
+
 type Authenticator interface {
 	Authenticate(token string) (Claims, error)
 }
@@ -19,6 +24,16 @@ type AuthMiddleware struct {
 
 const TokenContextKey = "token"
 
+const ClaimsContextKey = "claims"
+
+const (
+	// TokenQueryParam é o parâmetro de query aceito como token no handshake.
+	TokenQueryParam = "token"
+	// TokenProtocolPrefix identifica o token enviado em Sec-WebSocket-Protocol,
+	// para clientes de navegador que não conseguem definir cabeçalhos.
+	TokenProtocolPrefix = "bearer."
+)
+
 func GetTokenFromContext(ctx context.Context) string {
 	if token, ok := ctx.Value(TokenContextKey).(string); ok {
 		return token
@@ -26,6 +41,37 @@ func GetTokenFromContext(ctx context.Context) string {
 	return ""
 }
 
+// ClaimsFromContext retorna as claims do socket autenticado, se houver.
+func ClaimsFromContext(ctx context.Context) Claims {
+	if claims, ok := ctx.Value(ClaimsContextKey).(Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+// tokenFromRequest extrai o token do handshake, nesta ordem: cabeçalho
+// Authorization, parâmetro de query e Sec-WebSocket-Protocol. Também retorna o
+// subprotocolo usado, que precisa ser devolvido na resposta do upgrade.
+func tokenFromRequest(r *http.Request) (token string, protocol string) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if after, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(after), ""
+		}
+		return strings.TrimSpace(auth), ""
+	}
+
+	if token := r.URL.Query().Get(TokenQueryParam); token != "" {
+		return token, ""
+	}
+
+	for _, p := range websocket.Subprotocols(r) {
+		if after, ok := strings.CutPrefix(p, TokenProtocolPrefix); ok && after != "" {
+			return after, p
+		}
+	}
+	return "", ""
+}
+
 func (am *AuthMiddleware) Authenticate(token string) (Claims, error) {
 	if am.authenticator == nil {
 		return nil, errors.New("authenticator não configurado")
@@ -49,7 +95,7 @@ func (am *AuthMiddleware) Middleware() Middleware {
 				return errors.New("unauthorized")
 			}
 
-			ctx = context.WithValue(ctx, "claims", claims)
+			ctx = context.WithValue(ctx, ClaimsContextKey, claims)
 			return next(ctx, msg)
 		}
 	}
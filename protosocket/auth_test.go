@@ -0,0 +1,122 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// tokenAuth aceita apenas o token "valido".
+type tokenAuth struct{}
+
+func (tokenAuth) Authenticate(token string) (Claims, error) {
+	if token != "valido" {
+		return nil, errors.New("token inválido")
+	}
+	return Claims{"sub": "ana"}, nil
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		query    string
+		token    string
+		protocol string
+	}{
+		{"sem token", nil, "", "", ""},
+		{"cabeçalho bearer", http.Header{"Authorization": {"Bearer abc"}}, "", "abc", ""},
+		{"cabeçalho sem esquema", http.Header{"Authorization": {"abc"}}, "", "abc", ""},
+		{"query", nil, "token=abc", "abc", ""},
+		{"subprotocolo", http.Header{"Sec-Websocket-Protocol": {"json, bearer.abc"}}, "", "abc", "bearer.abc"},
+		{"subprotocolo sem token", http.Header{"Sec-Websocket-Protocol": {"bearer."}}, "", "", ""},
+		{"cabeçalho antes da query", http.Header{"Authorization": {"Bearer abc"}}, "token=def", "abc", ""},
+		{"query antes do subprotocolo", http.Header{"Sec-Websocket-Protocol": {"bearer.abc"}}, "token=def", "def", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			token, protocol := tokenFromRequest(r)
+			if token != tt.token || protocol != tt.protocol {
+				t.Errorf("token = %q, protocolo = %q; esperado %q, %q", token, protocol, tt.token, tt.protocol)
+			}
+		})
+	}
+}
+
+func TestServerAuthHandshake(t *testing.T) {
+	server := NewServer()
+	server.SetAuthenticator(tokenAuth{})
+	// As claims do handshake chegam aos middlewares pelo contexto
+	server.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg proto.Message) error {
+			if ClaimsFromContext(ctx)["sub"] != "ana" || GetTokenFromContext(ctx) != "valido" {
+				return errors.New("contexto sem claims")
+			}
+			return next(ctx, msg)
+		}
+	})
+	server.OnWithAck("chat", func(data proto.Message, socket *Socket) (proto.Message, error) {
+		return &ChatMessage{Content: socket.Claims()["sub"].(string)}, nil
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		query    url.Values
+		status   int
+		protocol string
+	}{
+		{"sem token", nil, nil, http.StatusUnauthorized, ""},
+		{"token recusado", http.Header{"Authorization": {"Bearer outro"}}, nil, http.StatusUnauthorized, ""},
+		{"cabeçalho", http.Header{"Authorization": {"Bearer valido"}}, nil, http.StatusSwitchingProtocols, ""},
+		{"query", nil, url.Values{TokenQueryParam: {"valido"}}, http.StatusSwitchingProtocols, ""},
+		{"subprotocolo", http.Header{"Sec-Websocket-Protocol": {"bearer.valido"}}, nil, http.StatusSwitchingProtocols, "bearer.valido"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "ws" + strings.TrimPrefix(ts.URL, "http") + "?" + tt.query.Encode()
+			conn, resp, err := websocket.DefaultDialer.Dial(target, tt.header)
+			if resp == nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, esperado %d", resp.StatusCode, tt.status)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if got := conn.Subprotocol(); got != tt.protocol {
+				t.Errorf("subprotocolo = %q, esperado %q", got, tt.protocol)
+			}
+
+			client := NewSocket(conn, "cliente")
+			go client.Listen()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			reply, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "oi"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reply.(*ChatMessage).Content; got != "ana" {
+				t.Errorf("claims no handler = %q, esperado %q", got, "ana")
+			}
+		})
+	}
+}
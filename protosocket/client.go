@@ -1,14 +1,14 @@
 package protosocket
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"sync/atomic"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	pb "github.com/mendes113/protosocket/protosocket/proto"
+	"github.com/mendes113/protosocket/protosocket/types"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
@@ -18,76 +18,268 @@ type websocketMessage struct {
 	err  error
 }
 
+// ClientAckHandler trata um evento no Client e devolve a resposta para EmitWithAck.
+type ClientAckHandler func(proto.Message, *Client) (proto.Message, error)
+
 type Client struct {
 	ID             string
 	conn           *websocket.Conn
+	writer         *writePump
+	heartbeat      *heartbeat
+	lock           sync.Mutex
+	onMissed       func(*Client)
+	onClosed       func(*Client)
+	done           chan struct{}
+	ctx            context.Context
+	cancel         context.CancelFunc
+	middlewares    []Middleware
+	remote         *Hello
+	codec          Codec
+	compression    CompressionConfig
+	fragmentation  FragmentConfig
+	reassembly     *reassembler
+	closeReason    error
+	onDisconnect   func(*Client, error)
+	onError        func(*Client, error)
 	handlers       map[string]func(proto.Message, *Client)
+	streams        *streamManager
+	streamHandlers map[string]func(*Stream, *Client)
+	ackHandlers    map[string]ClientAckHandler
+	acks           *ackTracker
 	logger         *zap.Logger
 	sequence       uint64
 	sequencer      *MessageSequencer
+	retransmit     *retransmitBuffer
+	delivery       *deliveryOutbox
+	onExpired      func(*Client, *Message)
+	onInvalid      func(*Client, *ValidationError)
 	metrics        *MetricsCollector
 	circuitBreaker *CircuitBreaker
 	validator      *MessageValidator
 	retryConfig    RetryConfig
 	textHandlers   map[string]func(string, *Client)
+	options        clientOptions
+	urls           []string
+	state          types.ConnectionState
+	onStateChange  func(types.ConnectionState)
+	outbox         []*Message
+	// closing é cancelado por Close e Shutdown, interrompendo a reconexão
+	closing     context.Context
+	markClosing context.CancelFunc
 }
 
+// NewClient conecta ao servidor com as opções padrão.
+//
+// Deprecated: use Dial, que devolve o erro de conexão em vez de encerrar o processo.
 func NewClient(url string) *Client {
-	logger := GetLogger()
-
-	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{})
+	c, err := Dial(context.Background(), url)
 	if err != nil {
-		logger.Fatal("erro de conexão",
+		GetLogger().Fatal("erro de conexão",
 			zap.String("url", url),
 			zap.Error(err))
 	}
+	return c
+}
 
+// newClient monta um Client sobre uma conexão já estabelecida.
+// O heartbeat é configurado aqui porque os handlers de ping/pong precisam
+// existir antes de a leitura começar.
+func newClient(conn *websocket.Conn, o clientOptions) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	closing, markClosing := context.WithCancel(context.Background())
 	c := &Client{
-		ID:             uuid.New().String()[:8],
+		ctx:            ctx,
+		cancel:         cancel,
+		ID:             o.id,
 		conn:           conn,
 		handlers:       make(map[string]func(proto.Message, *Client)),
-		logger:         logger,
+		ackHandlers:    make(map[string]ClientAckHandler),
+		streamHandlers: make(map[string]func(*Stream, *Client)),
+		acks:           newAckTracker(),
+		logger:         o.logger,
 		sequencer:      NewMessageSequencer(),
+		retransmit:     newRetransmitBuffer(DefaultSequencerConfig().RetransmitBuffer),
 		metrics:        NewMetricsCollector(),
-		circuitBreaker: NewCircuitBreaker(5, time.Second*10),
+		circuitBreaker: NewCircuitBreaker(o.breakerThreshold, o.breakerTimeout),
 		validator:      NewMessageValidator(),
-		retryConfig: RetryConfig{
-			MaxAttempts:       3,
-			InitialDelay:      time.Second,
-			MaxDelay:          time.Second * 5,
-			BackoffMultiplier: 2.0,
-		},
-		textHandlers: make(map[string]func(string, *Client)),
+		retryConfig:    o.retry,
+		textHandlers:   make(map[string]func(string, *Client)),
+		compression:    DefaultCompressionConfig(),
+		fragmentation:  DefaultFragmentConfig(),
+		options:        o,
+		state:          types.StateConnected,
+		onStateChange:  o.onStateChange,
+		done:           make(chan struct{}),
+		closing:        closing,
+		markClosing:    markClosing,
 	}
-
-	// Inicia a goroutine de escuta
-	go c.listen()
+	c.sequencer.deliver = c.deliverMessage
+	c.sequencer.nack = c.sendNack
+	c.sequencer.onGap = c.reportError
+	c.attach(conn)
+	c.streams = newStreamManager(ctx, c.ID, c.send, c.acceptStream)
+	c.sendHello()
 	return c
 }
 
+// attach prepara o escritor, o heartbeat e a remontagem de uma nova conexão.
+// Deve ser chamado com o lock ou antes de o Client ser compartilhado.
+func (c *Client) attach(conn *websocket.Conn) {
+	c.conn = conn
+	c.codec = codecFor(conn.Subprotocol())
+	c.remote = nil
+	c.closeReason = nil
+	c.writer = newWritePump(conn, DefaultWriterConfig(), func(err error) {
+		c.setCloseReason(err)
+		c.reportError(fmt.Errorf("erro de escrita: %w", err))
+	})
+	c.writer.setCompression(c.compression.Transport, c.compression.level())
+	c.writer.onExpired = c.reportExpired
+
+	c.heartbeat = nil
+	if c.options.heartbeat.Interval > 0 {
+		c.heartbeat = newHeartbeat(conn, c.options.heartbeat, func() {
+			c.logger.Warn("heartbeat perdido", zap.String("clientID", c.ID))
+			c.setCloseReason(ErrHeartbeatTimeout)
+			c.lock.Lock()
+			callback := c.onMissed
+			c.lock.Unlock()
+			if callback != nil {
+				callback(c)
+			}
+		})
+	}
+	c.reassembly = newReassembler(c.codec, c.reportError)
+	c.reassembly.setConfig(c.fragmentation)
+}
+
+// connection devolve a conexão atual, que muda a cada reconexão.
+func (c *Client) connection() (*websocket.Conn, *writePump, Codec) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conn, c.writer, c.codec
+}
+
+// OnHeartbeatMissed registra um callback chamado quando o servidor para de responder aos pings.
+func (c *Client) OnHeartbeatMissed(callback func(*Client)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onMissed = callback
+}
+
+// RTT retorna o último tempo de ida e volta medido pelo heartbeat.
+func (c *Client) RTT() time.Duration {
+	c.lock.Lock()
+	heartbeat := c.heartbeat
+	c.lock.Unlock()
+	if heartbeat == nil {
+		return 0
+	}
+	return heartbeat.RTT()
+}
+
 func (c *Client) On(event string, handler func(proto.Message, *Client)) {
 	c.handlers[event] = handler
 }
 
-func (c *Client) Emit(event string, msg proto.Message) error {
-	payload, err := proto.Marshal(msg)
+// Use adiciona middlewares executados antes dos handlers do Client.
+func (c *Client) Use(middleware ...Middleware) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.middlewares = append(c.middlewares, middleware...)
+}
+
+// Context retorna o contexto da conexão, cancelado quando ela é encerrada.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// OnWithAck registra um handler cuja resposta é devolvida a quem chamou EmitWithAck.
+func (c *Client) OnWithAck(event string, handler ClientAckHandler) {
+	c.ackHandlers[event] = handler
+}
+
+func (c *Client) Emit(event string, msg proto.Message, opts ...EmitOption) error {
+	envelope, err := newEnvelope(event, msg, c.ID, 0, opts...)
+	if err != nil {
+		return err
+	}
+	// Com entrega garantida a sequência é dada a cada envio
+	if c.delivery != nil {
+		return c.emitReliable(envelope)
+	}
+	return c.send(sequenced(&c.sequence, envelope))
+}
+
+// EmitWithAck envia uma mensagem e aguarda a resposta do handler remoto.
+// Como os handlers do Client rodam na goroutine de leitura, não deve ser
+// chamado de dentro de um deles.
+func (c *Client) EmitWithAck(ctx context.Context, event string, msg proto.Message, opts ...EmitOption) (proto.Message, error) {
+	envelope, err := newEnvelope(event, msg, c.ID, 0, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, replyCh, err := c.acks.register()
 	if err != nil {
-		return fmt.Errorf("erro ao serializar mensagem: %w", err)
+		return nil, err
 	}
+	defer c.acks.cancel(id)
 
-	wrapper := &pb.MessageWrapper{
-		Event:    event,
-		Data:     payload,
-		SenderId: c.ID,
-		Sequence: atomic.AddUint64(&c.sequence, 1),
+	envelope.AckId = id
+	if err := c.send(sequenced(&c.sequence, envelope)); err != nil {
+		return nil, err
 	}
 
-	data, err := proto.Marshal(wrapper)
+	select {
+	case reply := <-replyCh:
+		return reply.decode()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send coloca o envelope na fila de escrita da conexão ou, durante uma
+// reconexão, no buffer enviado quando ela voltar.
+func (c *Client) send(envelope *Message) error {
+	if c.dropExpired(envelope) {
+		return ErrMessageExpired
+	}
+	c.retransmit.store(envelope)
+	c.lock.Lock()
+	if c.state != types.StateConnected {
+		defer c.lock.Unlock()
+		return c.buffer(envelope)
+	}
+	c.lock.Unlock()
+	return c.write(envelope)
+}
+
+// write serializa o envelope e o coloca na fila de escrita da conexão atual.
+func (c *Client) write(envelope *Message) error {
+	if c.dropExpired(envelope) {
+		return ErrMessageExpired
+	}
+	c.lock.Lock()
+	writer, codec := c.writer, c.codec
+	compression, fragmentation, remote := c.compression, c.fragmentation, c.remote
+	c.lock.Unlock()
+	if err := compressEnvelope(envelope, compression, remote); err != nil {
+		return err
+	}
+
+	data, fragments, err := encodeFrames(envelope, codec, fragmentation, remote)
 	if err != nil {
-		return fmt.Errorf("erro ao serializar wrapper: %w", err)
+		return fmt.Errorf("erro ao serializar envelope: %w", err)
 	}
+	return writer.enqueueEnvelope(codec.FrameType(), data, fragments, envelope)
+}
 
-	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+// reply devolve a resposta de um EmitWithAck recebido.
+func (c *Client) reply(ackID string, data proto.Message, handlerErr error) {
+	if err := c.send(newReply(ackID, c.ID, data, handlerErr)); err != nil {
+		c.reportError(fmt.Errorf("erro ao enviar ack %s: %w", ackID, err))
+	}
 }
 
 func (c *Client) EmitWithRetry(event string, msg proto.Message) error {
@@ -98,35 +290,154 @@ func (c *Client) EmitWithRetry(event string, msg proto.Message) error {
 	})
 }
 
+// listen atende a conexão e, quando ela cai, reconecta se a reconexão estiver
+// ativa. Handlers, middlewares e o ID são mantidos entre as conexões.
 func (c *Client) listen() {
-	defer c.conn.Close()
+	defer close(c.done)
+	defer func() {
+		c.lock.Lock()
+		onClosed := c.onClosed
+		c.lock.Unlock()
+		if onClosed != nil {
+			onClosed(c)
+		}
+	}()
+	defer c.cancel()
+	defer c.acks.failAll(ErrConnectionClosed)
+	defer c.streams.failAll(ErrConnectionClosed)
+	defer c.sequencer.reset(0)
+
 	for {
-		msgType, data, err := c.conn.ReadMessage()
-		if err != nil {
-			c.logger.Error("erro na leitura", zap.Error(err))
+		reason := c.serve()
+
+		c.lock.Lock()
+		onDisconnect := c.onDisconnect
+		c.lock.Unlock()
+		if onDisconnect != nil {
+			onDisconnect(c, reason)
+		}
+
+		if c.State() != types.StateReconnecting {
 			return
 		}
+		if err := c.reconnect(); err != nil {
+			c.reportError(err)
+			c.setState(types.StateDisconnected)
+			c.discardOutbox(err)
+			return
+		}
+	}
+}
+
+// readStopped atualiza o estado quando serve para de ler e devolve o motivo.
+func (c *Client) readStopped() error {
+	reason := c.CloseReason()
+	if c.shouldReconnect(reason) {
+		c.setState(types.StateReconnecting)
+	} else {
+		c.setState(types.StateDisconnected)
+	}
+	return reason
+}
+
+// serve lê a conexão atual até ela cair e devolve o motivo.
+func (c *Client) serve() error {
+	c.lock.Lock()
+	conn, writer, codec := c.conn, c.writer, c.codec
+	heartbeat, reassembly := c.heartbeat, c.reassembly
+	c.lock.Unlock()
+
+	defer conn.Close()
+	defer writer.stop()
+	if heartbeat != nil {
+		go heartbeat.run()
+		defer heartbeat.stop()
+	}
+	defer c.acks.failAll(ErrConnectionClosed)
+	defer c.streams.failAll(ErrConnectionClosed)
+	defer reassembly.stop()
+	for {
+		c.lock.Lock()
+		limit := c.compression.MaxDecompressedSize
+		c.lock.Unlock()
+
+		msgType, data, err := readMessage(conn, limit)
+		if errors.Is(err, ErrDecompressionLimit) {
+			c.closeWithCode(websocket.CloseMessageTooBig, err)
+			return c.readStopped()
+		}
+		if err != nil {
+			if heartbeat != nil {
+				heartbeat.handleReadError(err)
+			}
+			c.setCloseReason(err)
+			return c.readStopped()
+		}
 
 		c.metrics.RecordReceivedMessage(len(data))
 
 		switch msgType {
-		case websocket.BinaryMessage:
-			// Processa mensagens Protobuf
-			var wrapper pb.MessageWrapper
-			if err := proto.Unmarshal(data, &wrapper); err != nil {
-				c.logger.Error("erro ao decodificar wrapper", zap.Error(err))
+		case codec.FrameType():
+			// Processa os envelopes no codec negociado
+			wrapper, err := codec.Decode(data)
+			if err == nil {
+				wrapper, err = reassembly.receive(wrapper, limit)
+			}
+			if err != nil {
+				c.reportError(fmt.Errorf("erro ao decodificar envelope: %w", err))
+				continue
+			}
+			// Fragmento de uma mensagem ainda incompleta
+			if wrapper == nil {
+				continue
+			}
+			if err := decompressEnvelope(wrapper, limit); err != nil {
+				if errors.Is(err, ErrDecompressionLimit) {
+					c.closeWithCode(websocket.CloseMessageTooBig, err)
+					return c.readStopped()
+				}
+				c.reportError(err)
+				continue
+			}
+
+			if wrapper.Event == HelloEvent {
+				c.handleHello(wrapper)
 				continue
 			}
 
-			if handler, ok := c.handlers[wrapper.Event]; ok {
-				var payload pb.Message
-				if err := proto.Unmarshal(wrapper.Data, &payload); err != nil {
-					c.logger.Error("erro ao decodificar payload", zap.Error(err))
-					continue
+			if wrapper.Event == NackEvent {
+				c.handleNack(wrapper)
+				continue
+			}
+
+			if wrapper.Event == DeliveredEvent {
+				c.handleDelivered(wrapper)
+				continue
+			}
+
+			if wrapper.Event == ValidationEvent {
+				c.handleInvalid(wrapper)
+				continue
+			}
+
+			if wrapper.StreamId != "" {
+				if err := c.streams.handle(wrapper); err != nil {
+					c.reportError(err)
 				}
-				handler(&payload, c)
+				continue
+			}
+
+			if wrapper.IsReply {
+				c.acks.resolve(wrapper.AckId, ackReply{
+					data:     wrapper.Data,
+					dataType: wrapper.DataType,
+					err:      wrapper.Error,
+				})
+				continue
 			}
 
+			c.processMessageInternal(wrapper)
+
 		case websocket.TextMessage:
 			// Processa mensagens de texto simples
 			if textHandler, ok := c.textHandlers["text"]; ok {
@@ -140,9 +451,10 @@ func (c *Client) listen() {
 }
 
 func (c *Client) processMessage(msg *websocketMessage) error {
-	var wrapper pb.MessageWrapper
-	if err := proto.Unmarshal(msg.data, &wrapper); err != nil {
-		return fmt.Errorf("erro ao decodificar wrapper: %v", err)
+	_, _, codec := c.connection()
+	wrapper, err := codec.Decode(msg.data)
+	if err != nil {
+		return fmt.Errorf("erro ao decodificar envelope: %v", err)
 	}
 
 	// Verifica se a mensagem é para este cliente
@@ -151,42 +463,26 @@ func (c *Client) processMessage(msg *websocketMessage) error {
 	}
 
 	if handler, ok := c.handlers[wrapper.Event]; ok {
-		var payload pb.Message
-		if err := proto.Unmarshal(wrapper.Data, &payload); err != nil {
+		payload, err := DefaultRegistry.Decode(wrapper.Event, wrapper.DataType, wrapper.Data)
+		if err != nil {
 			return fmt.Errorf("erro ao decodificar payload: %v", err)
 		}
-		handler(&payload, c)
+		handler(payload, c)
 		return nil
 	}
 
 	return fmt.Errorf("handler não encontrado para o evento: %s", wrapper.Event)
 }
 
-func (c *Client) processMessageInternal(msg *SequencedMessage) error {
-	c.sequencer.lock.Lock()
-	defer c.sequencer.lock.Unlock()
-
-	lastSeq := c.sequencer.lastSeq[msg.SenderId]
-
-	// Se é a próxima mensagem esperada
-	if msg.Sequence == lastSeq+1 {
-		c.sequencer.lastSeq[msg.SenderId] = msg.Sequence
-		return c.deliverMessage(msg)
-	}
-
-	// Se é uma mensagem futura, guarda no buffer
-	if msg.Sequence > lastSeq+1 {
-		c.sequencer.buffer[msg.SenderId] = append(
-			c.sequencer.buffer[msg.SenderId], *msg)
-		c.tryDeliverBuffered(msg.SenderId)
-	}
-
-	return nil
-}
-
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	c.markClosing()
+	conn, writer, _ := c.connection()
+	if conn != nil {
+		c.setCloseReason(ErrConnectionClosed)
+		// Envia o que ainda estiver na fila antes de fechar
+		writer.stop()
+		writer.wait()
+		return conn.Close()
 	}
 	return nil
 }
@@ -198,7 +494,11 @@ func (c *Client) OnText(handler func(string, *Client)) {
 
 // Novo método para enviar mensagens de texto
 func (c *Client) EmitText(text string) error {
-	return c.conn.WriteMessage(websocket.TextMessage, []byte(text))
+	_, writer, codec := c.connection()
+	if codec.FrameType() == websocket.TextMessage {
+		return ErrTextFrameReserved
+	}
+	return writer.enqueue(websocket.TextMessage, []byte(text))
 }
 
 // Adicione os demais métodos (On, Emit, listen) aqui...
@@ -0,0 +1,184 @@
+package protosocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec define como o envelope é escrito nos frames WebSocket. O codec de cada
+// conexão é escolhido pelo subprotocolo negociado no handshake: "protosocket.v1"
+// usa o protobuf binário e "protosocket.v1+json" usa protojson.
+type Codec interface {
+	// Name é o sufixo do subprotocolo, como "json"; vazio para o protobuf binário.
+	Name() string
+	// FrameType é o tipo de frame usado: websocket.BinaryMessage ou websocket.TextMessage.
+	FrameType() int
+	Encode(msg *Message) ([]byte, error)
+	Decode(data []byte) (*Message, error)
+}
+
+var (
+	// ProtobufCodec é o envelope protobuf binário, padrão de todas as conexões.
+	ProtobufCodec Codec = protobufCodec{}
+	// JSONCodec escreve o envelope em protojson, em frames de texto. O payload
+	// vai como objeto JSON no campo "payload", legível no devtools do navegador.
+	JSONCodec Codec = jsonCodec{}
+)
+
+var ErrTextFrameReserved = errors.New("frames de texto são usados pelo codec da conexão")
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{
+		ProtobufCodec.Name(): ProtobufCodec,
+		JSONCodec.Name():     JSONCodec,
+	}
+)
+
+// RegisterCodec adiciona um codec e passa a aceitar o subprotocolo dele.
+// Deve ser chamado na inicialização, antes de abrir conexões.
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	if _, ok := codecs[codec.Name()]; !ok {
+		SupportedSubprotocols = append(SupportedSubprotocols, codecSubprotocol(codec))
+	}
+	codecs[codec.Name()] = codec
+}
+
+// supportedSubprotocols copia SupportedSubprotocols sob o codecsLock, para
+// ler a lista sem disputar com um RegisterCodec concorrente.
+func supportedSubprotocols() []string {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	return slices.Clone(SupportedSubprotocols)
+}
+
+// codecSubprotocol devolve o subprotocolo que seleciona o codec.
+func codecSubprotocol(codec Codec) string {
+	if codec.Name() == "" {
+		return SubprotocolV1
+	}
+	return SubprotocolV1 + "+" + codec.Name()
+}
+
+// codecFor devolve o codec do subprotocolo negociado; conexões legadas usam protobuf.
+func codecFor(protocol string) Codec {
+	_, name, _ := strings.Cut(protocol, "+")
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	if codec, ok := codecs[name]; ok {
+		return codec
+	}
+	return ProtobufCodec
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return ""
+}
+
+func (protobufCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (protobufCodec) Encode(msg *Message) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte) (*Message, error) {
+	return decodeEnvelope(data)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+// Encode escreve o envelope em protojson. Quando o tipo do payload é conhecido,
+// ele sai como objeto em "payload" no lugar dos bytes em base64 de "data";
+// payloads comprimidos e fragmentos continuam em "data".
+func (jsonCodec) Encode(msg *Message) ([]byte, error) {
+	if msg.DataType == "" || msg.Encoding != "" || msg.HasFlag(EnvelopeFlag_FLAG_FRAGMENT) {
+		return protojson.Marshal(msg)
+	}
+	mt, err := DefaultRegistry.messageType(msg.Event, msg.DataType)
+	if err != nil {
+		return protojson.Marshal(msg)
+	}
+	data := mt.New().Interface()
+	if err := proto.Unmarshal(msg.Data, data); err != nil {
+		return nil, err
+	}
+	payload, err := protojson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := proto.Clone(msg).(*Message)
+	envelope.Data = nil
+	b, err := protojson.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fields["payload"] = payload
+	return json.Marshal(fields)
+}
+
+// Decode lê o envelope em protojson e converte o "payload" para protobuf. O
+// tipo vem de "dataType" ou, na falta dele, do tipo registrado para o evento.
+func (jsonCodec) Decode(data []byte) (*Message, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	payload, hasPayload := fields["payload"]
+	delete(fields, "payload")
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(&msg); err != nil {
+		return nil, err
+	}
+	if !hasPayload {
+		return &msg, nil
+	}
+
+	mt, err := DefaultRegistry.messageType(msg.Event, msg.DataType)
+	if err != nil {
+		return nil, err
+	}
+	value := mt.New().Interface()
+	if err := protojson.Unmarshal(payload, value); err != nil {
+		return nil, fmt.Errorf("payload inválido: %w", err)
+	}
+	if msg.Data, err = proto.Marshal(value); err != nil {
+		return nil, err
+	}
+	msg.DataType = dataTypeOf(value)
+	return &msg, nil
+}
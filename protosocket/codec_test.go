@@ -0,0 +1,174 @@
+package protosocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		protocol string
+		codec    Codec
+	}{
+		{SubprotocolV1, ProtobufCodec},
+		{SubprotocolV1JSON, JSONCodec},
+		{"", ProtobufCodec},
+		{SubprotocolV1 + "+desconhecido", ProtobufCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			if got := codecFor(tt.protocol); got != tt.codec {
+				t.Errorf("codec = %T, esperado %T", got, tt.codec)
+			}
+		})
+	}
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	chat, err := newEnvelope("chat", &ChatMessage{Content: "oi", Sender: "ana"}, "a", 1, WithHeader("trace", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := proto.Clone(chat).(*Message)
+	if err := compressEnvelope(compressed, CompressionConfig{Algorithm: GzipCompression}, &Hello{Features: []string{FeatureGzip}}); err != nil {
+		t.Fatal(err)
+	}
+	if !compressed.HasFlag(EnvelopeFlag_FLAG_COMPRESSED) {
+		// Payload pequeno demais para encolher: vale como envelope com encoding
+		compressed.Encoding = GzipCompression.String()
+	}
+
+	tests := []struct {
+		name string
+		msg  *Message
+		// payload indica se o conteúdo sai como objeto JSON legível
+		payload bool
+	}{
+		{"payload conhecido", chat, true},
+		{"sem payload", &Message{Version: ProtocolVersion, Event: "ping"}, false},
+		{"payload comprimido", compressed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := JSONCodec.Encode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(b, &fields); err != nil {
+				t.Fatalf("frame não é JSON: %v", err)
+			}
+			if _, ok := fields["payload"]; ok != tt.payload {
+				t.Errorf("payload presente = %v, esperado %v: %s", ok, tt.payload, b)
+			}
+
+			decoded, err := JSONCodec.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(decoded, tt.msg) {
+				t.Errorf("envelope = %v, esperado %v", decoded, tt.msg)
+			}
+		})
+	}
+}
+
+func TestServerJSONCodec(t *testing.T) {
+	server := NewServer()
+	received := make(chan *ChatMessage, 1)
+	server.On("chat", func(data proto.Message, _ *Socket) { received <- data.(*ChatMessage) })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// Um cliente de navegador que só fala JSON
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolV1JSON}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != SubprotocolV1JSON {
+		t.Fatalf("subprotocolo = %q", conn.Subprotocol())
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	frameType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello, err := JSONCodec.Decode(data); frameType != websocket.TextMessage || err != nil || hello.Event != HelloEvent {
+		t.Fatalf("hello = %s (%v)", data, err)
+	}
+
+	frame := `{"version":1,"event":"chat","dataType":"protosocket.ChatMessage","payload":{"content":"oi"}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Content != "oi" {
+			t.Errorf("conteúdo = %q", msg.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mensagem JSON não chegou ao handler")
+	}
+}
+
+// namedCodec é o protobuf binário com outro nome, para registrar codecs novos.
+type namedCodec struct {
+	protobufCodec
+	name string
+}
+
+func (c namedCodec) Name() string {
+	return c.name
+}
+
+func TestRegisterCodecConcurrent(t *testing.T) {
+	codecsLock.Lock()
+	supported := slices.Clone(SupportedSubprotocols)
+	codecsLock.Unlock()
+	t.Cleanup(func() {
+		codecsLock.Lock()
+		defer codecsLock.Unlock()
+		SupportedSubprotocols = supported
+		for i := range 8 {
+			delete(codecs, fmt.Sprint("teste", i))
+		}
+	})
+
+	// Conexões negociam e discam enquanto codecs novos são registrados
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterCodec(namedCodec{name: fmt.Sprint("teste", i)})
+		}()
+		go func() {
+			defer wg.Done()
+			if protocol, err := negotiateSubprotocol([]string{SubprotocolV1JSON}); err != nil || protocol != SubprotocolV1JSON {
+				t.Errorf("subprotocolo = %q (%v), esperado %q", protocol, err, SubprotocolV1JSON)
+			}
+			newDialer()
+			defaultClientOptions()
+		}()
+	}
+	wg.Wait()
+
+	protocol, err := negotiateSubprotocol([]string{SubprotocolV1 + "+teste3"})
+	if err != nil || protocol != SubprotocolV1+"+teste3" {
+		t.Errorf("subprotocolo = %q (%v), esperado o do codec registrado", protocol, err)
+	}
+}
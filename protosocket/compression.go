@@ -2,10 +2,14 @@ package protosocket
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 
+	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -14,11 +18,104 @@ type CompressionType int
 const (
 	NoCompression CompressionType = iota
 	GzipCompression
+	FlateCompression
 )
 
-func compressData(data []byte) ([]byte, error) {
+// Recursos de compressão anunciados no hello. Toda ponta sabe descomprimir
+// os dois formatos, então eles são sempre anunciados.
+const (
+	FeatureGzip  = "compression.gzip"
+	FeatureFlate = "compression.deflate"
+)
+
+var (
+	ErrDecompressionLimit = errors.New("mensagem descomprimida excede o limite")
+	ErrUnknownEncoding    = errors.New("codificação de envelope desconhecida")
+)
+
+// String retorna o nome usado no campo encoding do envelope.
+func (t CompressionType) String() string {
+	switch t {
+	case GzipCompression:
+		return "gzip"
+	case FlateCompression:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+func (t CompressionType) feature() string {
+	switch t {
+	case GzipCompression:
+		return FeatureGzip
+	case FlateCompression:
+		return FeatureFlate
+	default:
+		return ""
+	}
+}
+
+func compressionFromEncoding(encoding string) (CompressionType, error) {
+	switch encoding {
+	case "gzip":
+		return GzipCompression, nil
+	case "deflate":
+		return FlateCompression, nil
+	default:
+		return NoCompression, fmt.Errorf("%w: %q", ErrUnknownEncoding, encoding)
+	}
+}
+
+// CompressionConfig controla a compressão de uma conexão.
+type CompressionConfig struct {
+	// Transport habilita o permessage-deflate do WebSocket, negociado no upgrade.
+	Transport bool
+	// Algorithm comprime o payload do envelope; só é usado se o outro lado
+	// anunciou suporte no hello.
+	Algorithm CompressionType
+	// Threshold é o tamanho mínimo do payload para tentar comprimir.
+	Threshold int
+	// Level segue os níveis de compress/flate; zero usa flate.DefaultCompression.
+	Level int
+	// MaxDecompressedSize limita o tamanho de uma mensagem recebida depois de
+	// descomprimida, protegendo contra bombas de descompressão. Zero desativa.
+	MaxDecompressedSize int64
+}
+
+// DefaultCompressionConfig comprime com gzip payloads a partir de 1 KiB.
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Algorithm:           GzipCompression,
+		Threshold:           1024,
+		Level:               flate.DefaultCompression,
+		MaxDecompressedSize: 16 << 20,
+	}
+}
+
+func (c CompressionConfig) level() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+func compressData(data []byte, algorithm CompressionType, level int) ([]byte, error) {
 	var buf bytes.Buffer
-	writer := gzip.NewWriter(&buf)
+	var writer io.WriteCloser
+	var err error
+
+	switch algorithm {
+	case GzipCompression:
+		writer, err = gzip.NewWriterLevel(&buf, level)
+	case FlateCompression:
+		writer, err = flate.NewWriter(&buf, level)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := writer.Write(data); err != nil {
 		return nil, err
@@ -31,43 +128,141 @@ func compressData(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func decompressData(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
+// decompressData descomprime até limit bytes; limit zero não impõe limite.
+func decompressData(data []byte, algorithm CompressionType, limit int64) ([]byte, error) {
+	var reader io.ReadCloser
+	switch algorithm {
+	case GzipCompression:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		reader = gz
+	case FlateCompression:
+		reader = flate.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	defer reader.Close()
+
+	return readLimited(reader, limit)
+}
+
+// readLimited lê tudo de r, falhando se passar de limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
+	if int64(len(data)) > limit {
+		return nil, ErrDecompressionLimit
+	}
+	return data, nil
+}
+
+// readMessage lê a próxima mensagem aplicando o limite também ao que o
+// permessage-deflate descomprime, que o SetReadLimit do gorilla não cobre.
+func readMessage(conn *websocket.Conn, limit int64) (int, []byte, error) {
+	msgType, r, err := conn.NextReader()
+	if err != nil {
+		return msgType, nil, err
+	}
+	data, err := readLimited(r, limit)
+	return msgType, data, err
+}
+
+// compressEnvelope comprime o payload quando vale a pena e o outro lado suporta.
+func compressEnvelope(msg *Message, config CompressionConfig, remote *Hello) error {
+	feature := config.Algorithm.feature()
+	if feature == "" || len(msg.Data) < config.Threshold || !hasFeature(remote, feature) {
+		return nil
+	}
+
+	compressed, err := compressData(msg.Data, config.Algorithm, config.level())
+	if err != nil {
+		return fmt.Errorf("erro ao comprimir envelope: %w", err)
+	}
+	// Payloads que não encolhem seguem como estão
+	if len(compressed) >= len(msg.Data) {
+		return nil
+	}
+
+	msg.Data = compressed
+	msg.Encoding = config.Algorithm.String()
+	msg.SetFlag(EnvelopeFlag_FLAG_COMPRESSED)
+	return nil
+}
 
-	return io.ReadAll(reader)
+// decompressEnvelope desfaz a compressão do payload, respeitando o limite.
+func decompressEnvelope(msg *Message, limit int64) error {
+	if !msg.HasFlag(EnvelopeFlag_FLAG_COMPRESSED) {
+		return nil
+	}
+
+	algorithm, err := compressionFromEncoding(msg.Encoding)
+	if err != nil {
+		return err
+	}
+	data, err := decompressData(msg.Data, algorithm, limit)
+	if err != nil {
+		return fmt.Errorf("erro ao descomprimir envelope: %w", err)
+	}
+
+	msg.Data = data
+	msg.Encoding = ""
+	msg.Flags &^= uint32(EnvelopeFlag_FLAG_COMPRESSED)
+	return nil
 }
 
-// CompressionMiddleware aplica compressão nas mensagens
+// CompressionMiddleware aplica compressão nas mensagens.
+//
+// Deprecated: a compressão agora acontece no envelope e no transporte; veja
+// CompressionConfig. O middleware foi mantido apenas por compatibilidade e
+// não altera as mensagens.
 func CompressionMiddleware() Middleware {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(ctx context.Context, msg proto.Message) error {
-			data, err := proto.Marshal(msg)
-			if err != nil {
-				return err
-			}
-
-			// Comprime os dados
-			compressed, err := compressData(data)
-			if err != nil {
-				return err
-			}
-
-			// Descomprime antes de passar adiante
-			decompressed, err := decompressData(compressed)
-			if err != nil {
-				return err
-			}
-
-			// Reconstrói a mensagem
-			if err := proto.Unmarshal(decompressed, msg); err != nil {
-				return err
-			}
-
 			return next(ctx, msg)
 		}
 	}
 }
+
+// SetCompression define a compressão das próximas conexões. Deve ser chamado
+// antes de o servidor começar a aceitar conexões.
+func (s *Server) SetCompression(config CompressionConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compression = config
+	s.upgrader.EnableCompression = config.Transport
+}
+
+// SetCompression define a compressão das mensagens enviadas por este socket e
+// o limite de descompressão das recebidas. O permessage-deflate só é usado se
+// tiver sido negociado no upgrade.
+func (s *Socket) SetCompression(config CompressionConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compression = config
+	s.writer.setCompression(config.Transport, config.level())
+}
+
+// SetCompression define a compressão das mensagens enviadas pelo Client e o
+// limite de descompressão das recebidas. O permessage-deflate é sempre
+// oferecido no dial; Transport controla se ele é usado na escrita.
+func (c *Client) SetCompression(config CompressionConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.compression = config
+	c.writer.setCompression(config.Transport, config.level())
+}
+
+// SetCompression define a compressão dos próximos links. Deve ser chamado antes de Start.
+func (p *Peer) SetCompression(config CompressionConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.compression = config
+	p.upgrader.EnableCompression = config.Transport
+}
@@ -0,0 +1,226 @@
+package protosocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// compressible devolve n bytes que encolhem bem ao comprimir.
+func compressible(n int) []byte {
+	return bytes.Repeat([]byte("protosocket "), n/12+1)[:n]
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := compressible(4096)
+	tests := []struct {
+		name      string
+		algorithm CompressionType
+		shrinks   bool
+	}{
+		{"gzip", GzipCompression, true},
+		{"deflate", FlateCompression, true},
+		{"sem compressão", NoCompression, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed, err := compressData(data, tt.algorithm, DefaultCompressionConfig().level())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(compressed) < len(data); got != tt.shrinks {
+				t.Errorf("%d bytes comprimidos em %d", len(data), len(compressed))
+			}
+			restored, err := decompressData(compressed, tt.algorithm, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored, data) {
+				t.Error("dados diferentes depois da descompressão")
+			}
+		})
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	compressed, err := compressData(compressible(4096), GzipCompression, DefaultCompressionConfig().level())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		limit int64
+		err   error
+	}{
+		{"sem limite", 0, nil},
+		{"no limite", 4096, nil},
+		{"acima do limite", 4095, ErrDecompressionLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decompressData(compressed, GzipCompression, tt.limit); !errors.Is(err, tt.err) {
+				t.Errorf("erro = %v, esperado %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompressEnvelope(t *testing.T) {
+	gzipPeer := &Hello{Features: []string{FeatureGzip}}
+	config := DefaultCompressionConfig()
+
+	tests := []struct {
+		name       string
+		data       []byte
+		config     CompressionConfig
+		remote     *Hello
+		compressed bool
+	}{
+		{"acima do limiar", compressible(4096), config, gzipPeer, true},
+		{"abaixo do limiar", compressible(100), config, gzipPeer, false},
+		{"outro lado sem suporte", compressible(4096), config, &Hello{}, false},
+		{"cliente legado", compressible(4096), config, nil, false},
+		{"não encolhe", []byte("abc"), CompressionConfig{Algorithm: GzipCompression}, gzipPeer, false},
+		{"compressão desligada", compressible(4096), CompressionConfig{}, gzipPeer, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Data: tt.data}
+			if err := compressEnvelope(msg, tt.config, tt.remote); err != nil {
+				t.Fatal(err)
+			}
+			if got := msg.HasFlag(EnvelopeFlag_FLAG_COMPRESSED); got != tt.compressed {
+				t.Fatalf("comprimido = %v, esperado %v", got, tt.compressed)
+			}
+			if err := decompressEnvelope(msg, config.MaxDecompressedSize); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(msg.Data, tt.data) || msg.Encoding != "" || msg.Flags != 0 {
+				t.Errorf("envelope não foi restaurado: %d bytes, %q, flags %b", len(msg.Data), msg.Encoding, msg.Flags)
+			}
+		})
+	}
+
+	unknown := &Message{Encoding: "brotli"}
+	unknown.SetFlag(EnvelopeFlag_FLAG_COMPRESSED)
+	if err := decompressEnvelope(unknown, 0); !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("erro = %v, esperado %v", err, ErrUnknownEncoding)
+	}
+}
+
+// compressionBomb monta um envelope pequeno que descomprime em size bytes.
+func compressionBomb(t *testing.T, size int) []byte {
+	t.Helper()
+	data, err := compressData(make([]byte, size), GzipCompression, DefaultCompressionConfig().level())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Version: ProtocolVersion, Event: "chat", Data: data, Encoding: GzipCompression.String()}
+	msg.SetFlag(EnvelopeFlag_FLAG_COMPRESSED)
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServerRejectsCompressionBomb(t *testing.T) {
+	server := NewServer()
+	config := DefaultCompressionConfig()
+	config.MaxDecompressedSize = 1 << 20
+	server.SetCompression(config)
+	reasons := make(chan error, 1)
+	server.OnDisconnect(func(_ *Socket, reason error) { reasons <- reason })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.BinaryMessage, compressionBomb(t, 8<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("leitura = %v, esperado fechamento %d", err, websocket.CloseMessageTooBig)
+	}
+	select {
+	case reason := <-reasons:
+		if !errors.Is(reason, ErrDecompressionLimit) {
+			t.Errorf("motivo = %v, esperado %v", reason, ErrDecompressionLimit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect não foi chamado")
+	}
+}
+
+func TestClientRejectsCompressionBomb(t *testing.T) {
+	// Um servidor que manda a bomba, acima do limite padrão do cliente,
+	// seguida de uma mensagem válida
+	bomb := compressionBomb(t, 2*int(DefaultCompressionConfig().MaxDecompressedSize))
+	closed := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		chat, _ := newEnvelope("chat", &ChatMessage{Content: "depois da bomba"}, "servidor", 0)
+		valid, _ := proto.Marshal(chat)
+		conn.WriteMessage(websocket.BinaryMessage, bomb)
+		conn.WriteMessage(websocket.BinaryMessage, valid)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	received := make(chan struct{}, 1)
+	client.On("chat", func(proto.Message, *Client) { received <- struct{}{} })
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Errorf("leitura = %v, esperado fechamento %d", err, websocket.CloseMessageTooBig)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("servidor não recebeu o fechamento")
+	}
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("cliente continuou lendo depois da bomba")
+	}
+	if !errors.Is(client.CloseReason(), ErrDecompressionLimit) {
+		t.Errorf("motivo = %v, esperado %v", client.CloseReason(), ErrDecompressionLimit)
+	}
+	select {
+	case <-received:
+		t.Error("mensagem depois da bomba chegou ao handler")
+	default:
+	}
+}
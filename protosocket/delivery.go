@@ -0,0 +1,536 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mendes113/protosocket/protosocket/storage"
+	"github.com/mendes113/protosocket/protosocket/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// DeliveredEvent é o evento reservado com que o receptor confirma os
+// envelopes com entrega garantida.
+const DeliveredEvent = "$delivered"
+
+// FeatureDelivery indica, no hello, que a ponta confirma os envelopes com
+// FLAG_RELIABLE e descarta as repetições.
+const FeatureDelivery = "delivery"
+
+var ErrOutboxFull = errors.New("outbox de entrega cheio")
+
+// Estados dos envelopes guardados no MessageStore.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryExpired   = "expired"
+)
+
+// DeliveryConfig controla a entrega garantida (pelo menos uma vez). No Client
+// vale para o envio; no Server e no Socket, para a deduplicação no recebimento.
+type DeliveryConfig struct {
+	// RetryInterval é quanto o remetente espera pela confirmação antes de reenviar.
+	RetryInterval time.Duration
+	// MaxPending limita os envelopes sem confirmação; além dele Emit retorna ErrOutboxFull.
+	MaxPending int
+	// Store persiste o outbox para que sobreviva a um reinício; nil mantém em
+	// memória. O Client deve ter ID fixo (WithID) para recuperar o que é seu, e
+	// o GetByID do Store deve devolver storage.ErrNotFound para IDs ausentes.
+	Store storage.MessageStore
+	// DedupWindow é por quanto tempo o receptor lembra de um envelope já entregue.
+	DedupWindow time.Duration
+	// DedupSize limita os envelopes lembrados pelo receptor.
+	DedupSize int
+}
+
+// DefaultDeliveryConfig reenvia a cada 5s e lembra dos envelopes por 10 minutos.
+func DefaultDeliveryConfig() DeliveryConfig {
+	return DeliveryConfig{
+		RetryInterval: 5 * time.Second,
+		MaxPending:    10000,
+		DedupWindow:   10 * time.Minute,
+		DedupSize:     100000,
+	}
+}
+
+// WithDelivery liga a entrega garantida: cada Emit fica no outbox e é
+// reenviado, inclusive depois de reconexões, até o servidor confirmar.
+func WithDelivery(config DeliveryConfig) Option {
+	return func(o *clientOptions) {
+		o.delivery = &config
+	}
+}
+
+// pendingDelivery é um envelope aguardando confirmação.
+type pendingDelivery struct {
+	envelope *Message
+	sentAt   time.Time
+}
+
+// deliveryOutbox guarda os envelopes enviados com entrega garantida até a confirmação.
+type deliveryOutbox struct {
+	lock      sync.Mutex
+	indexLock sync.Mutex // Serializa as gravações do índice no Store
+	config    DeliveryConfig
+	senderID  string
+	pending   map[string]*pendingDelivery
+	order     []string
+}
+
+func newDeliveryOutbox(config DeliveryConfig, senderID string) *deliveryOutbox {
+	return &deliveryOutbox{
+		config:   config,
+		senderID: senderID,
+		pending:  make(map[string]*pendingDelivery),
+	}
+}
+
+// add guarda o envelope e, com Store, o persiste antes do primeiro envio.
+func (o *deliveryOutbox) add(envelope *Message) error {
+	o.lock.Lock()
+	if o.config.MaxPending > 0 && len(o.pending) >= o.config.MaxPending {
+		o.lock.Unlock()
+		return ErrOutboxFull
+	}
+	o.pending[envelope.MessageId] = &pendingDelivery{envelope: envelope}
+	o.order = append(o.order, envelope.MessageId)
+	o.lock.Unlock()
+
+	if err := o.save(envelope, deliveryPending); err != nil {
+		o.confirm([]string{envelope.MessageId})
+		return fmt.Errorf("erro ao persistir envelope: %w", err)
+	}
+	return nil
+}
+
+// due marca como enviados e devolve, em ordem, os envelopes nunca enviados ou
+// cuja confirmação passou de RetryInterval. Com all, devolve todos.
+func (o *deliveryOutbox) due(all bool) []*Message {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	now := time.Now()
+	var envelopes []*Message
+	order := o.order[:0]
+	for _, id := range o.order {
+		p, ok := o.pending[id]
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		if all || p.sentAt.IsZero() || now.Sub(p.sentAt) >= o.config.RetryInterval {
+			p.sentAt = now
+			envelopes = append(envelopes, p.envelope)
+		}
+	}
+	o.order = order
+	return envelopes
+}
+
+// purge tira do outbox e devolve os envelopes que expiraram sem confirmação.
+func (o *deliveryOutbox) purge() []*Message {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	now := time.Now()
+	var expired []*Message
+	for id, p := range o.pending {
+		if p.envelope.expired(now) {
+			delete(o.pending, id)
+			expired = append(expired, p.envelope)
+		}
+	}
+	return expired
+}
+
+// confirm remove os envelopes confirmados pelo receptor.
+func (o *deliveryOutbox) confirm(ids []string) []*Message {
+	o.lock.Lock()
+	var confirmed []*Message
+	for _, id := range ids {
+		if p, ok := o.pending[id]; ok {
+			confirmed = append(confirmed, p.envelope)
+			delete(o.pending, id)
+		}
+	}
+	o.lock.Unlock()
+	return confirmed
+}
+
+func (o *deliveryOutbox) len() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.pending)
+}
+
+// save grava o envelope no Store com o estado informado e atualiza o índice
+// do remetente. O MessageStore não apaga por ID, então a confirmação é gravada
+// por cima do pendente.
+func (o *deliveryOutbox) save(envelope *Message, state string) error {
+	if o.config.Store == nil {
+		return nil
+	}
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	err = o.config.Store.Save(context.Background(), &types.Message{
+		ID:   envelope.MessageId,
+		Type: envelope.Event,
+		Data: data,
+		Metadata: map[string]string{
+			"sender": o.senderID,
+			"state":  state,
+		},
+		Timestamp: envelope.SentAt(),
+	})
+	if err != nil {
+		return err
+	}
+	return o.saveIndex()
+}
+
+// indexID é o registro do Store com os IDs pendentes do remetente.
+func (o *deliveryOutbox) indexID() string {
+	return "outbox:" + o.senderID
+}
+
+// saveIndex grava os IDs ainda pendentes, para que restore leia apenas os
+// envelopes deste remetente.
+func (o *deliveryOutbox) saveIndex() error {
+	o.indexLock.Lock()
+	defer o.indexLock.Unlock()
+
+	o.lock.Lock()
+	ids := make([]string, 0, len(o.pending))
+	for _, id := range o.order {
+		if _, ok := o.pending[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	o.lock.Unlock()
+
+	return o.config.Store.Save(context.Background(), &types.Message{
+		ID:        o.indexID(),
+		Type:      "outbox",
+		Data:      []byte(strings.Join(ids, "\n")),
+		Metadata:  map[string]string{"sender": o.senderID},
+		Timestamp: time.Now(),
+	})
+}
+
+// restore carrega do Store os envelopes deste remetente ainda sem
+// confirmação, a partir do índice gravado por saveIndex.
+func (o *deliveryOutbox) restore(ctx context.Context) error {
+	if o.config.Store == nil {
+		return nil
+	}
+	index, err := o.config.Store.GetByID(ctx, o.indexID())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []*types.Message
+	for _, id := range strings.Split(string(index.Data), "\n") {
+		if id == "" {
+			continue
+		}
+		msg, err := o.config.Store.GetByID(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stored = append(stored, msg)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, msg := range stored {
+		if msg.Metadata["sender"] != o.senderID || msg.Metadata["state"] != deliveryPending {
+			continue
+		}
+		var envelope Message
+		if err := proto.Unmarshal(msg.Data, &envelope); err != nil {
+			return fmt.Errorf("envelope %s inválido no store: %w", msg.ID, err)
+		}
+		if _, ok := o.pending[envelope.MessageId]; ok {
+			continue
+		}
+		o.pending[envelope.MessageId] = &pendingDelivery{envelope: &envelope}
+		o.order = append(o.order, envelope.MessageId)
+	}
+	return nil
+}
+
+// emitReliable guarda o envelope no outbox e o envia se a conexão estiver de
+// pé; durante uma queda ele espera a reconexão no próprio outbox.
+func (c *Client) emitReliable(envelope *Message) error {
+	envelope.SetFlag(EnvelopeFlag_FLAG_RELIABLE)
+	if err := c.delivery.add(envelope); err != nil {
+		return err
+	}
+	if c.State() == types.StateConnected {
+		c.redeliver(false)
+	}
+	return nil
+}
+
+// redeliver envia os envelopes pendentes que venceram. Cada envio recebe uma
+// sequência nova; a repetição é descartada pelo MessageId no receptor.
+func (c *Client) redeliver(all bool) {
+	c.purgeDeliveries()
+	for _, envelope := range c.delivery.due(all) {
+		resend := sequenced(&c.sequence, proto.Clone(envelope).(*Message))
+		err := c.send(resend)
+		// Venceu entre a seleção e o envio; send já contou a expiração
+		if errors.Is(err, ErrMessageExpired) {
+			c.delivery.confirm([]string{envelope.MessageId})
+			c.expireDelivery(envelope)
+			continue
+		}
+		if err != nil {
+			c.reportError(fmt.Errorf("erro ao enviar '%s' com entrega garantida: %w", envelope.Event, err))
+			return
+		}
+	}
+}
+
+// purgeDeliveries descarta do outbox os envelopes vencidos, mesmo com a conexão caída.
+func (c *Client) purgeDeliveries() {
+	for _, envelope := range c.delivery.purge() {
+		c.reportExpired(envelope)
+		c.expireDelivery(envelope)
+	}
+}
+
+// expireDelivery grava no Store que o envelope expirou sem confirmação.
+func (c *Client) expireDelivery(envelope *Message) {
+	if err := c.delivery.save(envelope, deliveryExpired); err != nil {
+		c.reportError(fmt.Errorf("erro ao gravar expiração de %s: %w", envelope.MessageId, err))
+	}
+}
+
+// runDelivery reenvia periodicamente o que não foi confirmado enquanto o Client existir.
+func (c *Client) runDelivery() {
+	ticker := time.NewTicker(max(c.delivery.config.RetryInterval/2, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.purgeDeliveries()
+			// Servidores sem o recurso nunca confirmam
+			if c.State() == types.StateConnected && c.HasRemoteFeature(FeatureDelivery) {
+				c.redeliver(false)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleDelivered tira do outbox os envelopes confirmados.
+func (c *Client) handleDelivered(msg *Message) {
+	if c.delivery == nil {
+		return
+	}
+	var delivered Delivered
+	if err := proto.Unmarshal(msg.Data, &delivered); err != nil {
+		c.reportError(fmt.Errorf("confirmação de entrega inválida: %w", err))
+		return
+	}
+	for _, envelope := range c.delivery.confirm(delivered.MessageIds) {
+		if err := c.delivery.save(envelope, deliveryDelivered); err != nil {
+			c.reportError(fmt.Errorf("erro ao gravar confirmação de %s: %w", envelope.MessageId, err))
+		}
+	}
+}
+
+// PendingDeliveries retorna quantos envelopes aguardam confirmação.
+func (c *Client) PendingDeliveries() int {
+	if c.delivery == nil {
+		return 0
+	}
+	return c.delivery.len()
+}
+
+// dedupWindow lembra os envelopes já recebidos, por escopo, remetente e
+// MessageId, durante a janela configurada.
+type dedupWindow struct {
+	lock   sync.Mutex
+	window time.Duration
+	size   int
+	seen   map[string]bool // true quando o handler já terminou
+	order  []dedupEntry
+}
+
+type dedupEntry struct {
+	key string
+	at  time.Time
+}
+
+func newDedupWindow(config DeliveryConfig) *dedupWindow {
+	return &dedupWindow{
+		window: config.DedupWindow,
+		size:   config.DedupSize,
+		seen:   make(map[string]bool),
+	}
+}
+
+// dedupKey prefixa o envelope com o escopo de quem o recebeu, para que um
+// cliente não consiga se passar por outro repetindo SenderId e MessageId.
+func dedupKey(scope string, msg *Message) string {
+	return scope + "/" + msg.SenderId + "/" + msg.MessageId
+}
+
+// check registra o envelope e indica se é a primeira vez que ele chega e, se
+// não for, se o handler da primeira chegada já terminou.
+func (d *dedupWindow) check(scope string, msg *Message) (first, processed bool) {
+	key := dedupKey(scope, msg)
+	now := time.Now()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.prune(now)
+	if processed, ok := d.seen[key]; ok {
+		return false, processed
+	}
+	d.seen[key] = false
+	d.order = append(d.order, dedupEntry{key: key, at: now})
+	return true, false
+}
+
+// processed marca o envelope como entregue ao handler.
+func (d *dedupWindow) processed(scope string, msg *Message) {
+	key := dedupKey(scope, msg)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.seen[key]; ok {
+		d.seen[key] = true
+	}
+}
+
+// forget esquece um envelope cujo handler não chegou a rodar, para que o
+// reenvio do remetente seja aceito de novo.
+func (d *dedupWindow) forget(scope string, msg *Message) {
+	key := dedupKey(scope, msg)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if processed, ok := d.seen[key]; !ok || processed {
+		return
+	}
+	delete(d.seen, key)
+	for i := len(d.order) - 1; i >= 0; i-- {
+		if d.order[i].key == key {
+			d.order = slices.Delete(d.order, i, i+1)
+			return
+		}
+	}
+}
+
+// prune esquece os envelopes fora da janela ou além do tamanho. Deve ser chamado com o lock.
+func (d *dedupWindow) prune(now time.Time) {
+	for len(d.order) > 0 {
+		oldest := d.order[0]
+		if now.Sub(oldest.at) < d.window && (d.size <= 0 || len(d.order) < d.size) {
+			return
+		}
+		delete(d.seen, oldest.key)
+		d.order = d.order[1:]
+	}
+}
+
+// SetDelivery define a deduplicação dos envelopes com entrega garantida. A
+// janela é compartilhada pelos sockets, para que reenvios depois de uma
+// reconexão também sejam reconhecidos. Os sockets autenticados são
+// identificados pela claim "sub"; sem autenticação vale o ID que o cliente
+// declara no hello, que não é verificado e não isola um cliente de outro que
+// repita o mesmo ID. Clientes legados, sem hello, ficam no escopo do socket.
+func (s *Server) SetDelivery(config DeliveryConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dedup = newDedupWindow(config)
+}
+
+// SetDelivery define a janela de deduplicação deste socket.
+func (s *Socket) SetDelivery(config DeliveryConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dedup = newDedupWindow(config)
+}
+
+func (s *Socket) shareDedup(dedup *dedupWindow) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dedup = dedup
+}
+
+func (s *Socket) deduplication() *dedupWindow {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dedup
+}
+
+// dedupScope identifica o dono dos envelopes na janela de deduplicação: a
+// claim "sub" nos sockets autenticados e o ID do hello nos demais, que
+// sobrevivem a reconexões, ou o próprio socket quando não há nenhum dos dois.
+func (s *Socket) dedupScope() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if subject, ok := s.claims["sub"].(string); ok && subject != "" {
+		return "sub:" + subject
+	}
+	if id := s.remote.GetId(); id != "" {
+		return "hello:" + id
+	}
+	return "socket:" + s.ID
+}
+
+// acceptDelivery indica se o envelope deve ser entregue aos handlers.
+// Repetições de envelopes já processados são apenas confirmadas de novo; as
+// que chegam com a primeira ainda na fila esperam a confirmação dela.
+func (s *Socket) acceptDelivery(msg *Message) bool {
+	if !msg.HasFlag(EnvelopeFlag_FLAG_RELIABLE) {
+		return true
+	}
+	first, processed := s.deduplication().check(s.dedupScope(), msg)
+	if processed {
+		s.sendDelivered(msg)
+	}
+	return first
+}
+
+// confirmDelivery marca o envelope como processado e avisa o remetente.
+func (s *Socket) confirmDelivery(msg *Message) {
+	if !msg.HasFlag(EnvelopeFlag_FLAG_RELIABLE) {
+		return
+	}
+	s.deduplication().processed(s.dedupScope(), msg)
+	s.sendDelivered(msg)
+}
+
+// forgetDelivery desfaz o registro de um envelope que o dispatcher recusou,
+// já que o remetente vai reenviá-lo sem ter recebido a confirmação.
+func (s *Socket) forgetDelivery(msg *Message) {
+	if !msg.HasFlag(EnvelopeFlag_FLAG_RELIABLE) {
+		return
+	}
+	s.deduplication().forget(s.dedupScope(), msg)
+}
+
+func (s *Socket) sendDelivered(msg *Message) {
+	confirmation, err := newEnvelope(DeliveredEvent, &Delivered{MessageIds: []string{msg.MessageId}}, "", 0)
+	if err == nil {
+		err = s.send(confirmation)
+	}
+	if err != nil {
+		s.reportError(fmt.Errorf("erro ao confirmar entrega de %s: %w", msg.MessageId, err))
+	}
+}
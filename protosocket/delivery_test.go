@@ -0,0 +1,522 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mendes113/protosocket/protosocket/storage"
+	"github.com/mendes113/protosocket/protosocket/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// memoryStore é um MessageStore em memória para os testes do outbox.
+type memoryStore struct {
+	lock     sync.Mutex
+	messages map[string]*types.Message
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{messages: make(map[string]*types.Message)}
+}
+
+func (m *memoryStore) Save(ctx context.Context, msg *types.Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages[msg.ID] = msg
+	return nil
+}
+
+func (m *memoryStore) GetByID(ctx context.Context, id string) (*types.Message, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if msg, ok := m.messages[id]; ok {
+		return msg, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *memoryStore) GetByTimeRange(ctx context.Context, start, end time.Time) ([]*types.Message, error) {
+	return nil, errors.New("restore não deve varrer o store")
+}
+
+func (m *memoryStore) DeleteOlderThan(ctx context.Context, age time.Duration) error {
+	return nil
+}
+
+// subjectAuth aceita qualquer token e o usa como claim "sub".
+type subjectAuth struct{}
+
+func (subjectAuth) Authenticate(token string) (Claims, error) {
+	return Claims{"sub": token}, nil
+}
+
+func reliable(t *testing.T, sender, content string) *Message {
+	t.Helper()
+	envelope, err := newEnvelope("chat", &ChatMessage{Content: content}, sender, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope.SetFlag(EnvelopeFlag_FLAG_RELIABLE)
+	return envelope
+}
+
+func messageIDs(envelopes []*Message) []string {
+	ids := make([]string, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		ids = append(ids, envelope.MessageId)
+	}
+	return ids
+}
+
+func TestDedupWindow(t *testing.T) {
+	a := &Message{SenderId: "a", MessageId: "1"}
+	b := &Message{SenderId: "b", MessageId: "1"}
+
+	type step struct {
+		scope     string
+		msg       *Message
+		done      bool // marca como processado em vez de verificar
+		forget    bool // esquece em vez de verificar
+		first     bool
+		processed bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"primeira chegada", []step{
+			{scope: "s1", msg: a, first: true},
+		}},
+		{"repetida em processamento", []step{
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: a},
+		}},
+		{"repetida já processada", []step{
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: a, done: true},
+			{scope: "s1", msg: a, processed: true},
+			{scope: "s1", msg: a, processed: true},
+		}},
+		{"remetentes distintos", []step{
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: b, first: true},
+		}},
+		{"escopos distintos com o mesmo remetente", []step{
+			{scope: "socket:1", msg: a, first: true},
+			{scope: "socket:1", msg: a, done: true},
+			{scope: "socket:2", msg: a, first: true},
+		}},
+		{"recusado pelo dispatcher volta a ser aceito", []step{
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: a, forget: true},
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: a},
+		}},
+		{"processado não é esquecido", []step{
+			{scope: "s1", msg: a, first: true},
+			{scope: "s1", msg: a, done: true},
+			{scope: "s1", msg: a, forget: true},
+			{scope: "s1", msg: a, processed: true},
+		}},
+		{"processado sem chegada não é lembrado", []step{
+			{scope: "s1", msg: a, done: true},
+			{scope: "s1", msg: a, first: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDedupWindow(DefaultDeliveryConfig())
+			for i, step := range tt.steps {
+				if step.done {
+					d.processed(step.scope, step.msg)
+					continue
+				}
+				if step.forget {
+					d.forget(step.scope, step.msg)
+					continue
+				}
+				first, processed := d.check(step.scope, step.msg)
+				if first != step.first || processed != step.processed {
+					t.Errorf("passo %d: check = (%v, %v), esperado (%v, %v)", i, first, processed, step.first, step.processed)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupWindowPrune(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		size   int
+		sleep  time.Duration
+		others int
+		first  bool
+	}{
+		{"dentro da janela", time.Minute, 10, 0, 0, false},
+		{"fora da janela", 10 * time.Millisecond, 10, 30 * time.Millisecond, 0, true},
+		{"além do tamanho", time.Minute, 3, 0, 3, true},
+		{"sem limite de tamanho", time.Minute, 0, 0, 100, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDedupWindow(DeliveryConfig{DedupWindow: tt.window, DedupSize: tt.size})
+			msg := &Message{SenderId: "a", MessageId: "1"}
+			d.check("s1", msg)
+			for i := 0; i < tt.others; i++ {
+				d.check("s1", &Message{SenderId: "a", MessageId: fmt.Sprint("outro-", i)})
+			}
+			time.Sleep(tt.sleep)
+
+			if first, _ := d.check("s1", msg); first != tt.first {
+				t.Errorf("primeira chegada = %v, esperado %v", first, tt.first)
+			}
+		})
+	}
+}
+
+func TestDeliveryOutbox(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, o *deliveryOutbox, envelopes []*Message)
+	}{
+		{"novos são enviados uma vez", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			if got := o.due(false); len(got) != 3 {
+				t.Fatalf("envios = %d, esperado 3", len(got))
+			}
+			if got := o.due(false); len(got) != 0 {
+				t.Fatalf("reenviados antes de RetryInterval: %d", len(got))
+			}
+		}},
+		{"reenvio depois de RetryInterval", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			o.due(false)
+			time.Sleep(30 * time.Millisecond)
+			if got := messageIDs(o.due(false)); !slices.Equal(got, messageIDs(envelopes)) {
+				t.Fatalf("reenvios = %v, esperado %v", got, messageIDs(envelopes))
+			}
+		}},
+		{"reconexão reenvia todos", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			o.due(false)
+			if got := o.due(true); len(got) != 3 {
+				t.Fatalf("reenvios = %d, esperado 3", len(got))
+			}
+		}},
+		{"confirmados saem do outbox", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			confirmed := o.confirm([]string{envelopes[1].MessageId, "desconhecido"})
+			if len(confirmed) != 1 || o.len() != 2 {
+				t.Fatalf("confirmados = %d, pendentes = %d", len(confirmed), o.len())
+			}
+			want := []string{envelopes[0].MessageId, envelopes[2].MessageId}
+			if got := messageIDs(o.due(true)); !slices.Equal(got, want) {
+				t.Fatalf("reenvios = %v, esperado %v", got, want)
+			}
+		}},
+		{"confirmação repetida", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			o.confirm([]string{envelopes[0].MessageId})
+			if confirmed := o.confirm([]string{envelopes[0].MessageId}); len(confirmed) != 0 {
+				t.Fatalf("confirmação repetida devolveu %d envelopes", len(confirmed))
+			}
+		}},
+		{"outbox cheio", func(t *testing.T, o *deliveryOutbox, envelopes []*Message) {
+			if err := o.add(reliable(t, "a", "4")); !errors.Is(err, ErrOutboxFull) {
+				t.Fatalf("erro = %v, esperado %v", err, ErrOutboxFull)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newDeliveryOutbox(DeliveryConfig{RetryInterval: 20 * time.Millisecond, MaxPending: 3}, "a")
+			envelopes := []*Message{reliable(t, "a", "1"), reliable(t, "a", "2"), reliable(t, "a", "3")}
+			for _, envelope := range envelopes {
+				if err := o.add(envelope); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.run(t, o, envelopes)
+		})
+	}
+}
+
+func TestDeliveryOutboxRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		prepare  func(t *testing.T, store *memoryStore) []string
+		restored int
+	}{
+		{"sem índice", func(t *testing.T, store *memoryStore) []string {
+			return nil
+		}, 0},
+		{"pendentes", func(t *testing.T, store *memoryStore) []string {
+			o := newDeliveryOutbox(DeliveryConfig{Store: store}, "a")
+			first, second := reliable(t, "a", "1"), reliable(t, "a", "2")
+			o.add(first)
+			o.add(second)
+			return []string{first.MessageId, second.MessageId}
+		}, 2},
+		{"confirmados não voltam", func(t *testing.T, store *memoryStore) []string {
+			o := newDeliveryOutbox(DeliveryConfig{Store: store}, "a")
+			first, second := reliable(t, "a", "1"), reliable(t, "a", "2")
+			o.add(first)
+			o.add(second)
+			o.confirm([]string{first.MessageId})
+			o.save(first, deliveryDelivered)
+			return []string{second.MessageId}
+		}, 1},
+		{"outro remetente", func(t *testing.T, store *memoryStore) []string {
+			o := newDeliveryOutbox(DeliveryConfig{Store: store}, "b")
+			o.add(reliable(t, "b", "1"))
+			return nil
+		}, 0},
+		{"envelope ausente do store", func(t *testing.T, store *memoryStore) []string {
+			o := newDeliveryOutbox(DeliveryConfig{Store: store}, "a")
+			lost, kept := reliable(t, "a", "1"), reliable(t, "a", "2")
+			o.add(lost)
+			o.add(kept)
+			store.lock.Lock()
+			delete(store.messages, lost.MessageId)
+			store.lock.Unlock()
+			return []string{kept.MessageId}
+		}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			want := tt.prepare(t, store)
+
+			o := newDeliveryOutbox(DeliveryConfig{Store: store}, "a")
+			if err := o.restore(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if o.len() != tt.restored {
+				t.Fatalf("restaurados = %d, esperado %d", o.len(), tt.restored)
+			}
+			if got := messageIDs(o.due(true)); !slices.Equal(got, want) {
+				t.Errorf("restaurados = %v, esperado %v", got, want)
+			}
+
+			// Restaurar de novo não duplica o outbox
+			if err := o.restore(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if o.len() != tt.restored {
+				t.Errorf("restaurar de novo duplicou o outbox: %d", o.len())
+			}
+		})
+	}
+}
+
+func TestDeliveryExactlyOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		auth Authenticator
+		opts []Option
+	}{
+		// A claim "sub" mantém o escopo da deduplicação entre as conexões
+		{"autenticado", subjectAuth{}, []Option{WithHTTPHeader(http.Header{"Authorization": {"Bearer cliente"}})}},
+		// Sem autenticação, o ID do hello identifica o cliente na reconexão
+		{"sem autenticação", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			if tt.auth != nil {
+				server.SetAuthenticator(tt.auth)
+			}
+			var lock sync.Mutex
+			handled := make(map[string]int)
+			server.On("chat", func(msg proto.Message, socket *Socket) {
+				// Lento o bastante para que os reenvios cheguem durante o handler
+				time.Sleep(30 * time.Millisecond)
+				lock.Lock()
+				handled[msg.(*ChatMessage).Content]++
+				lock.Unlock()
+			})
+			sockets := make(chan *Socket, 4)
+			server.OnConnection(func(s *Socket) { sockets <- s })
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			config := DefaultDeliveryConfig()
+			config.RetryInterval = 10 * time.Millisecond
+			config.Store = newMemoryStore()
+			retry := DefaultRetryConfig()
+			retry.InitialDelay = 10 * time.Millisecond
+			opts := append([]Option{WithID("cliente"), WithDelivery(config), WithReconnect(retry)}, tt.opts...)
+			client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			socket := <-sockets
+
+			const total = 20
+			for i := 0; i < total; i++ {
+				if i == total/2 {
+					// Derruba a conexão no meio do envio
+					socket.Conn.Close()
+				}
+				if err := client.Emit("chat", &ChatMessage{Content: fmt.Sprint(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for client.PendingDeliveries() > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := client.PendingDeliveries(); n > 0 {
+				t.Fatalf("%d envelopes sem confirmação", n)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for i := 0; i < total; i++ {
+				if n := handled[fmt.Sprint(i)]; n != 1 {
+					t.Errorf("mensagem %d processada %d vezes", i, n)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryRedeliveredAfterReconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		auth Authenticator
+		opts []Option
+	}{
+		{"autenticado", subjectAuth{}, []Option{WithHTTPHeader(http.Header{"Authorization": {"Bearer cliente"}})}},
+		{"sem autenticação", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			if tt.auth != nil {
+				server.SetAuthenticator(tt.auth)
+			}
+			started, release := make(chan struct{}, 1), make(chan struct{})
+			var handled atomic.Int32
+			server.On("chat", func(msg proto.Message, socket *Socket) {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-release
+				handled.Add(1)
+			})
+			sockets := make(chan *Socket, 4)
+			server.OnConnection(func(s *Socket) { sockets <- s })
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			config := DefaultDeliveryConfig()
+			config.RetryInterval = 10 * time.Millisecond
+			opts := append([]Option{WithDelivery(config), WithReconnect(fastReconnect)}, tt.opts...)
+			client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			socket := <-sockets
+
+			if err := client.Emit("chat", &ChatMessage{Content: "oi"}); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			// A conexão cai com o handler em andamento: a confirmação se perde e
+			// o cliente reenvia o envelope pela nova conexão
+			socket.Conn.Close()
+			<-sockets
+			close(release)
+
+			deadline := time.Now().Add(time.Second)
+			for client.PendingDeliveries() > 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if n := client.PendingDeliveries(); n > 0 {
+				t.Fatalf("%d envelopes sem confirmação", n)
+			}
+			if n := handled.Load(); n != 1 {
+				t.Errorf("mensagem processada %d vezes, esperado 1", n)
+			}
+		})
+	}
+}
+
+func TestDeliveryAfterSaturatedDispatch(t *testing.T) {
+	server := NewServer()
+	// Um handler por vez e uma vaga na fila: a terceira mensagem é recusada
+	server.SetDispatch(DispatchConfig{Mode: DispatchOrdered, QueueSize: 1, Policy: SaturationReject})
+	saturated := make(chan struct{}, 1)
+	server.OnError(func(_ *Socket, err error) {
+		if errors.Is(err, ErrDispatchSaturated) {
+			select {
+			case saturated <- struct{}{}:
+			default:
+			}
+		}
+	})
+	release := make(chan struct{})
+	var lock sync.Mutex
+	handled := make(map[string]int)
+	server.On("chat", func(msg proto.Message, socket *Socket) {
+		<-release
+		lock.Lock()
+		handled[msg.(*ChatMessage).Content]++
+		lock.Unlock()
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := DefaultDeliveryConfig()
+	config.RetryInterval = 20 * time.Millisecond
+	client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"), WithDelivery(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	contents := []string{"1", "2", "3"}
+	for _, content := range contents {
+		if err := client.Emit("chat", &ChatMessage{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-saturated:
+	case <-time.After(time.Second):
+		t.Fatal("a fila de handlers não saturou")
+	}
+	close(release)
+
+	// O envelope recusado volta no reenvio e chega ao handler uma vez
+	deadline := time.Now().Add(2 * time.Second)
+	for client.PendingDeliveries() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := client.PendingDeliveries(); n > 0 {
+		t.Fatalf("%d envelopes sem confirmação", n)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, content := range contents {
+		if n := handled[content]; n != 1 {
+			t.Errorf("mensagem %s processada %d vezes", content, n)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package protosocket
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/mendes113/protosocket/protosocket/types"
+	"go.uber.org/zap"
+)
+
+// Option ajusta o Client criado por Dial.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	header           http.Header
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
+	subprotocols     []string
+	logger           *zap.Logger
+	retry            RetryConfig
+	breakerThreshold int
+	breakerTimeout   time.Duration
+	id               string
+	heartbeat        HeartbeatConfig
+	reconnect        *RetryConfig
+	fallbackURLs     []string
+	reconnectBuffer  int
+	onStateChange    func(types.ConnectionState)
+	delivery         *DeliveryConfig
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		header:           http.Header{},
+		handshakeTimeout: 45 * time.Second,
+		subprotocols:     supportedSubprotocols(),
+		logger:           GetLogger(),
+		retry:            DefaultRetryConfig(),
+		breakerThreshold: 5,
+		breakerTimeout:   10 * time.Second,
+		id:               uuid.New().String()[:8],
+		heartbeat:        DefaultHeartbeatConfig(),
+		reconnectBuffer:  DefaultReconnectBuffer,
+	}
+}
+
+// WithHTTPHeader adiciona cabeçalhos ao handshake, como Authorization.
+func WithHTTPHeader(header http.Header) Option {
+	return func(o *clientOptions) {
+		for key, values := range header {
+			for _, value := range values {
+				o.header.Add(key, value)
+			}
+		}
+	}
+}
+
+// WithTLSConfig define a configuração TLS das URLs wss://.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *clientOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithHandshakeTimeout limita a duração do handshake; o padrão é 45s.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.handshakeTimeout = timeout
+	}
+}
+
+// WithSubprotocols define os subprotocolos pedidos no handshake, em ordem de
+// preferência. Sem subprotocolo o servidor trata o cliente como legado.
+func WithSubprotocols(protocols ...string) Option {
+	return func(o *clientOptions) {
+		o.subprotocols = protocols
+	}
+}
+
+// WithCodec pede apenas o subprotocolo do codec informado.
+func WithCodec(codec Codec) Option {
+	return WithSubprotocols(codecSubprotocol(codec))
+}
+
+// WithLogger substitui o logger global no Client.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+// WithRetryConfig define as tentativas de EmitWithRetry.
+func WithRetryConfig(config RetryConfig) Option {
+	return func(o *clientOptions) {
+		o.retry = config
+	}
+}
+
+// WithCircuitBreaker define quantas falhas seguidas abrem o circuito de
+// EmitWithRetry e por quanto tempo ele fica aberto.
+func WithCircuitBreaker(threshold int, timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.breakerThreshold = threshold
+		o.breakerTimeout = timeout
+	}
+}
+
+// WithID define o ID do Client, anunciado no hello e usado como remetente.
+func WithID(id string) Option {
+	return func(o *clientOptions) {
+		o.id = id
+	}
+}
+
+// WithHeartbeat define o heartbeat da conexão; Interval zero desativa.
+func WithHeartbeat(config HeartbeatConfig) Option {
+	return func(o *clientOptions) {
+		o.heartbeat = config
+	}
+}
+
+// WithReconnect liga a reconexão automática quando a conexão cai, com backoff
+// exponencial e jitter. MaxAttempts zero tenta para sempre.
+func WithReconnect(config RetryConfig) Option {
+	return func(o *clientOptions) {
+		o.reconnect = &config
+	}
+}
+
+// WithFallbackURLs define URLs tentadas em ordem, depois da principal, no dial
+// e em cada tentativa de reconexão.
+func WithFallbackURLs(urls ...string) Option {
+	return func(o *clientOptions) {
+		o.fallbackURLs = append(o.fallbackURLs, urls...)
+	}
+}
+
+// WithReconnectBuffer limita quantos envelopes são guardados durante uma queda;
+// além dele, Emit retorna ErrReconnectBufferFull.
+func WithReconnectBuffer(limit int) Option {
+	return func(o *clientOptions) {
+		o.reconnectBuffer = limit
+	}
+}
+
+// WithStateHandler registra o callback de mudança de estado já no dial inicial.
+func WithStateHandler(callback func(state types.ConnectionState)) Option {
+	return func(o *clientOptions) {
+		o.onStateChange = callback
+	}
+}
+
+// Dial conecta ao servidor e devolve o Client já escutando. Se a URL principal
+// falhar, as de WithFallbackURLs são tentadas em ordem. Cancelar ctx
+// interrompe apenas o handshake.
+func Dial(ctx context.Context, url string, opts ...Option) (*Client, error) {
+	o := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// O outbox persistido é recuperado antes de conectar
+	var outbox *deliveryOutbox
+	if o.delivery != nil {
+		outbox = newDeliveryOutbox(*o.delivery, o.id)
+		if err := outbox.restore(ctx); err != nil {
+			return nil, fmt.Errorf("erro ao recuperar outbox de entrega: %w", err)
+		}
+	}
+	if o.onStateChange != nil {
+		o.onStateChange(types.StateConnecting)
+	}
+
+	urls := append([]string{url}, o.fallbackURLs...)
+	var err error
+	for _, url := range urls {
+		var conn *websocket.Conn
+		conn, err = o.dial(ctx, url)
+		if err != nil {
+			continue
+		}
+
+		c := newClient(conn, o)
+		c.urls = urls
+		c.delivery = outbox
+		if o.onStateChange != nil {
+			o.onStateChange(types.StateConnected)
+		}
+		go c.listen()
+		if outbox != nil {
+			go c.runDelivery()
+			c.redeliver(false)
+		}
+		return c, nil
+	}
+	if o.onStateChange != nil {
+		o.onStateChange(types.StateDisconnected)
+	}
+	return nil, err
+}
+
+// dial faz o handshake com uma URL.
+func (o clientOptions) dial(ctx context.Context, url string) (*websocket.Conn, error) {
+	dialer := newDialer()
+	dialer.Subprotocols = o.subprotocols
+	dialer.TLSClientConfig = o.tlsConfig
+	dialer.HandshakeTimeout = o.handshakeTimeout
+
+	conn, resp, err := dialer.DialContext(ctx, url, o.header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("erro ao conectar em %s: %w (status %d)", url, err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("erro ao conectar em %s: %w", url, err)
+	}
+	return conn, nil
+}
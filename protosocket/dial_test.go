@@ -0,0 +1,76 @@
+package protosocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDial(t *testing.T) {
+	server := NewServer()
+	server.SetAuthenticator(tokenAuth{})
+	connected := make(chan *Socket, 1)
+	server.OnConnection(func(socket *Socket) { connected <- socket })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	tests := []struct {
+		name     string
+		url      string
+		opts     []Option
+		protocol string
+		// errText é um trecho do erro esperado; vazio quando a conexão deve abrir
+		errText string
+	}{
+		{"token no cabeçalho", url, []Option{WithHTTPHeader(http.Header{"Authorization": {"Bearer valido"}})}, SubprotocolV1, ""},
+		{"codec JSON", url, []Option{WithHTTPHeader(http.Header{"Authorization": {"Bearer valido"}}), WithCodec(JSONCodec)}, SubprotocolV1JSON, ""},
+		{"token recusado", url, []Option{WithHTTPHeader(http.Header{"Authorization": {"Bearer outro"}})}, "", "status 401"},
+		{"servidor fora do ar", "ws://127.0.0.1:1", nil, "", "erro ao conectar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			opts := append([]Option{WithID("cliente")}, tt.opts...)
+			client, err := Dial(ctx, tt.url, opts...)
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("erro = %v, esperado %q", err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			socket := <-connected
+			if client.ID != "cliente" || client.Subprotocol() != tt.protocol || socket.Subprotocol() != tt.protocol {
+				t.Errorf("ID = %q, subprotocolo = %q; esperado %q", client.ID, client.Subprotocol(), tt.protocol)
+			}
+		})
+	}
+}
+
+func TestDialContextCanceled(t *testing.T) {
+	// Um servidor que aceita a conexão TCP mas nunca responde ao handshake
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")); err == nil {
+		t.Fatal("Dial conectou sem handshake")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dial levou %v depois do cancelamento", elapsed)
+	}
+}
@@ -0,0 +1,372 @@
+package protosocket
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrDispatchSaturated = errors.New("fila de handlers cheia")
+
+// DispatchMode define como as mensagens recebidas são entregues aos handlers.
+type DispatchMode int
+
+const (
+	// DispatchOrdered processa as mensagens de um socket uma de cada vez, na
+	// ordem de chegada.
+	// Mensagens com PriorityControl ou PriorityHigh passam na frente das
+	// demais, mantendo a ordem entre si.
+	DispatchOrdered DispatchMode = iota
+	// DispatchPerKey mantém a ordem apenas entre mensagens com a mesma chave
+	// (o evento, por padrão); chaves diferentes rodam em paralelo.
+	DispatchPerKey
+	// DispatchPool usa um pool de workers compartilhado por todos os sockets
+	// do servidor, sem garantia de ordem. As prioridades altas também passam
+	// na frente.
+	DispatchPool
+	// DispatchConcurrent abre uma goroutine por mensagem, sem limite nem ordem.
+	DispatchConcurrent
+)
+
+// SaturationPolicy define o que fazer quando a fila de handlers está cheia.
+type SaturationPolicy int
+
+const (
+	// SaturationBlock pausa a leitura do socket até abrir espaço na fila.
+	SaturationBlock SaturationPolicy = iota
+	// SaturationReject descarta a mensagem e devolve ErrDispatchSaturated ao ack.
+	SaturationReject
+	// SaturationDisconnect encerra a conexão do socket que saturou a fila.
+	SaturationDisconnect
+)
+
+// DispatchConfig controla a entrega das mensagens aos handlers.
+type DispatchConfig struct {
+	Mode DispatchMode
+	// Workers é o número de workers do pool ou de filas do DispatchPerKey.
+	Workers int
+	// QueueSize é a capacidade de cada fila.
+	QueueSize int
+	Policy    SaturationPolicy
+	// KeyFunc escolhe a chave de ordenação do DispatchPerKey; por padrão, o evento.
+	KeyFunc func(meta MessageMetadata) string
+}
+
+// DefaultDispatchConfig processa as mensagens de cada socket em ordem.
+func DefaultDispatchConfig() DispatchConfig {
+	return DispatchConfig{
+		Mode:      DispatchOrdered,
+		Workers:   8,
+		QueueSize: 256,
+		Policy:    SaturationBlock,
+	}
+}
+
+// DispatchStats reúne as métricas das filas de handlers.
+type DispatchStats struct {
+	// Queued é o número de mensagens aguardando um worker.
+	Queued int64
+	// Running é o número de handlers em execução.
+	Running   int64
+	Processed uint64
+	Rejected  uint64
+}
+
+func (s DispatchStats) add(other DispatchStats) DispatchStats {
+	s.Queued += other.Queued
+	s.Running += other.Running
+	s.Processed += other.Processed
+	s.Rejected += other.Rejected
+	return s
+}
+
+// dispatcher distribui tarefas entre filas atendidas por workers fixos.
+type dispatcher struct {
+	config    DispatchConfig
+	lanes     []chan func()
+	express   chan func() // Prioridades altas no DispatchOrdered e no DispatchPool
+	done      chan struct{}
+	stopOnce  sync.Once
+	queued    atomic.Int64
+	running   atomic.Int64
+	processed atomic.Uint64
+	rejected  atomic.Uint64
+
+	usersLock sync.Mutex
+	users     int  // Sockets que usam o pool compartilhado
+	retired   bool // Substituído por SetDispatch; para quando o último socket sair
+}
+
+func newDispatcher(config DispatchConfig) *dispatcher {
+	defaults := DefaultDispatchConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+
+	d := &dispatcher{config: config, done: make(chan struct{})}
+	switch config.Mode {
+	case DispatchOrdered:
+		d.lanes = []chan func(){make(chan func(), config.QueueSize)}
+		d.express = make(chan func(), config.QueueSize)
+		go d.work(d.express, d.lanes[0])
+	case DispatchPerKey:
+		d.lanes = make([]chan func(), config.Workers)
+		for i := range d.lanes {
+			d.lanes[i] = make(chan func(), config.QueueSize)
+			go d.work(nil, d.lanes[i])
+		}
+	case DispatchPool:
+		// Uma fila única consumida por todos os workers
+		d.lanes = []chan func(){make(chan func(), config.QueueSize)}
+		d.express = make(chan func(), config.QueueSize)
+		for i := 0; i < config.Workers; i++ {
+			go d.work(d.express, d.lanes[0])
+		}
+	}
+	return d
+}
+
+func (d *dispatcher) key(meta MessageMetadata) string {
+	if d.config.KeyFunc != nil {
+		return d.config.KeyFunc(meta)
+	}
+	return meta.Event
+}
+
+// submit enfileira a tarefa respeitando a política de saturação.
+func (d *dispatcher) submit(meta MessageMetadata, task func()) error {
+	select {
+	case <-d.done:
+		return ErrConnectionClosed
+	default:
+	}
+
+	if len(d.lanes) == 0 {
+		d.running.Add(1)
+		go func() {
+			defer d.finish()
+			task()
+		}()
+		return nil
+	}
+
+	lane := d.lanes[0]
+	if d.express != nil && (meta.Priority == PriorityControl || meta.Priority == PriorityHigh) {
+		lane = d.express
+	} else if len(d.lanes) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(d.key(meta)))
+		lane = d.lanes[h.Sum32()%uint32(len(d.lanes))]
+	}
+
+	d.queued.Add(1)
+	if d.config.Policy == SaturationBlock {
+		select {
+		case lane <- task:
+			return nil
+		case <-d.done:
+			d.queued.Add(-1)
+			return ErrConnectionClosed
+		}
+	}
+
+	select {
+	case lane <- task:
+		return nil
+	default:
+		d.queued.Add(-1)
+		d.rejected.Add(1)
+		return ErrDispatchSaturated
+	}
+}
+
+// work atende uma fila até o dispatcher parar, esvaziando o que sobrou. A
+// fila expressa, quando existe, é atendida antes.
+func (d *dispatcher) work(express, lane chan func()) {
+	for {
+		select {
+		case task := <-express:
+			d.run(task)
+			continue
+		default:
+		}
+
+		select {
+		case task := <-express:
+			d.run(task)
+		case task := <-lane:
+			d.run(task)
+		case <-d.done:
+			for {
+				select {
+				case task := <-express:
+					d.run(task)
+				case task := <-lane:
+					d.run(task)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *dispatcher) run(task func()) {
+	d.queued.Add(-1)
+	d.running.Add(1)
+	defer d.finish()
+	task()
+}
+
+func (d *dispatcher) finish() {
+	d.running.Add(-1)
+	d.processed.Add(1)
+}
+
+func (d *dispatcher) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+}
+
+// acquire registra mais um socket usando o pool compartilhado.
+func (d *dispatcher) acquire() {
+	d.usersLock.Lock()
+	defer d.usersLock.Unlock()
+	d.users++
+}
+
+// release retira o socket do pool e, se o pool já foi substituído, para os
+// workers quando o último socket sai.
+func (d *dispatcher) release() {
+	d.usersLock.Lock()
+	defer d.usersLock.Unlock()
+	d.users--
+	if d.retired && d.users == 0 {
+		d.stop()
+	}
+}
+
+// retire marca o pool como substituído: ele para agora se nenhum socket o
+// usa, ou quando o último deles fechar.
+func (d *dispatcher) retire() {
+	d.usersLock.Lock()
+	defer d.usersLock.Unlock()
+	d.retired = true
+	if d.users == 0 {
+		d.stop()
+	}
+}
+
+func (d *dispatcher) stats() DispatchStats {
+	return DispatchStats{
+		Queued:    d.queued.Load(),
+		Running:   d.running.Load(),
+		Processed: d.processed.Load(),
+		Rejected:  d.rejected.Load(),
+	}
+}
+
+// SetDispatch define como as mensagens deste socket chegam aos handlers.
+// Deve ser chamado antes de Listen.
+func (s *Socket) SetDispatch(config DispatchConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dispatchConfig = config
+}
+
+// sharePool faz o socket usar o pool do servidor em vez de um dispatcher próprio.
+func (s *Socket) sharePool(pool *dispatcher) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dispatcher = pool
+}
+
+// DispatchStats retorna as métricas da fila de handlers do socket.
+func (s *Socket) DispatchStats() DispatchStats {
+	s.lock.Lock()
+	d := s.dispatcher
+	s.lock.Unlock()
+	if d == nil {
+		return DispatchStats{}
+	}
+	return d.stats()
+}
+
+// dispatch executa a tarefa pelo dispatcher, acompanhada pelo desligamento.
+// Se a fila estiver saturada, aplica a política configurada. Depois que o
+// desligamento começa, nada mais é despachado e o ack recebe ErrShuttingDown.
+// Retorna false quando a tarefa foi recusada e não vai rodar.
+func (s *Socket) dispatch(meta MessageMetadata, fn func()) bool {
+	s.drainLock.Lock()
+	if s.draining.Load() {
+		s.drainLock.Unlock()
+		if meta.AckID != "" {
+			s.reply(meta.AckID, nil, ErrShuttingDown)
+		}
+		return false
+	}
+	s.inflight.Add(1)
+	s.drainLock.Unlock()
+
+	err := s.dispatcher.submit(meta, func() {
+		defer s.inflight.Done()
+		fn()
+	})
+	if err == nil {
+		return true
+	}
+	s.inflight.Done()
+
+	if errors.Is(err, ErrDispatchSaturated) && s.dispatcher.config.Policy == SaturationDisconnect {
+		s.setCloseReason(err)
+		s.Close(websocket.CloseTryAgainLater, err.Error())
+		return false
+	}
+	s.reportError(fmt.Errorf("mensagem '%s' descartada: %w", meta.Event, err))
+	if meta.AckID != "" {
+		s.reply(meta.AckID, nil, err)
+	}
+	return false
+}
+
+// SetDispatch define como as mensagens dos próximos sockets chegam aos handlers.
+// No DispatchPool o servidor mantém um único pool para todos os sockets; o
+// pool anterior continua atendendo os sockets já conectados até eles fecharem.
+func (s *Server) SetDispatch(config DispatchConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dispatchConfig = config
+	if s.pool != nil {
+		s.pool.retire()
+		s.pool = nil
+	}
+	if config.Mode == DispatchPool {
+		s.pool = newDispatcher(config)
+	}
+}
+
+// DispatchStats soma as métricas das filas de handlers de todos os sockets.
+func (s *Server) DispatchStats() DispatchStats {
+	s.lock.Lock()
+	pool := s.pool
+	sockets := make([]*Socket, 0, len(s.clients))
+	for _, socket := range s.clients {
+		sockets = append(sockets, socket)
+	}
+	s.lock.Unlock()
+
+	if pool != nil {
+		return pool.stats()
+	}
+	var stats DispatchStats
+	for _, socket := range sockets {
+		stats = stats.add(socket.DispatchStats())
+	}
+	return stats
+}
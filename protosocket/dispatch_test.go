@@ -0,0 +1,265 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDispatcherModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DispatchConfig
+		keys   []string
+		// parallel é quantas tarefas bloqueadas precisam rodar ao mesmo tempo
+		parallel int
+		// ordered indica se a ordem de submissão é preservada por chave
+		ordered bool
+	}{
+		{"ordenado", DispatchConfig{Mode: DispatchOrdered}, []string{"a", "b"}, 1, true},
+		{"por chave", DispatchConfig{Mode: DispatchPerKey, Workers: 4}, []string{"a", "b"}, 2, true},
+		{"pool", DispatchConfig{Mode: DispatchPool, Workers: 3}, []string{"a", "a", "a"}, 3, false},
+		{"concorrente", DispatchConfig{Mode: DispatchConcurrent}, []string{"a", "a", "a", "a"}, 4, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDispatcher(tt.config)
+			defer d.stop()
+
+			// Uma tarefa bloqueada por chave: só as que rodam em paralelo começam
+			started := make(chan string, len(tt.keys))
+			release := make(chan struct{})
+			for _, key := range tt.keys {
+				if err := d.submit(MessageMetadata{Event: key}, func() {
+					started <- key
+					<-release
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for range tt.parallel {
+				select {
+				case <-started:
+				case <-time.After(time.Second):
+					t.Fatalf("menos de %d tarefas em paralelo", tt.parallel)
+				}
+			}
+			select {
+			case key := <-started:
+				t.Fatalf("tarefa %q rodou em paralelo além do esperado", key)
+			case <-time.After(20 * time.Millisecond):
+			}
+			close(release)
+
+			if !tt.ordered {
+				return
+			}
+			var lock sync.Mutex
+			var order []int
+			var wg sync.WaitGroup
+			for i := range 20 {
+				wg.Add(1)
+				d.submit(MessageMetadata{Event: "a"}, func() {
+					defer wg.Done()
+					lock.Lock()
+					order = append(order, i)
+					lock.Unlock()
+				})
+			}
+			wg.Wait()
+			if !slices.IsSorted(order) {
+				t.Errorf("ordem = %v", order)
+			}
+		})
+	}
+}
+
+func TestDispatcherSaturation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SaturationPolicy
+		err    error
+	}{
+		{"bloqueia", SaturationBlock, nil},
+		{"recusa", SaturationReject, ErrDispatchSaturated},
+		{"desconecta", SaturationDisconnect, ErrDispatchSaturated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDispatcher(DispatchConfig{Mode: DispatchOrdered, QueueSize: 1, Policy: tt.policy})
+			defer d.stop()
+
+			started, release := make(chan struct{}), make(chan struct{})
+			d.submit(MessageMetadata{}, func() {
+				close(started)
+				<-release
+			})
+			<-started
+			if err := d.submit(MessageMetadata{}, func() {}); err != nil {
+				t.Fatalf("fila com espaço recusou: %v", err)
+			}
+
+			submitted := make(chan error, 1)
+			if tt.err == nil {
+				go func() { submitted <- d.submit(MessageMetadata{}, func() {}) }()
+				select {
+				case err := <-submitted:
+					t.Fatalf("submit não bloqueou: %v", err)
+				case <-time.After(20 * time.Millisecond):
+				}
+			} else {
+				submitted <- d.submit(MessageMetadata{}, func() {})
+			}
+			close(release)
+
+			if err := <-submitted; !errors.Is(err, tt.err) {
+				t.Errorf("erro = %v, esperado %v", err, tt.err)
+			}
+			var rejected uint64
+			if tt.err != nil {
+				rejected = 1
+			}
+			if got := d.stats().Rejected; got != rejected {
+				t.Errorf("recusadas = %d, esperado %d", got, rejected)
+			}
+		})
+	}
+
+	d := newDispatcher(DefaultDispatchConfig())
+	d.stop()
+	if err := d.submit(MessageMetadata{}, func() {}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("submit depois de parar = %v, esperado %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestSocketSaturationPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SaturationPolicy
+		code   int
+	}{
+		{"recusa devolve o erro no ack", SaturationReject, 0},
+		{"desconecta o socket", SaturationDisconnect, websocket.CloseTryAgainLater},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+			server, started := blockingServer(release)
+			server.SetDispatch(DispatchConfig{Mode: DispatchOrdered, QueueSize: 1, Policy: tt.policy})
+			client, _ := socketPair(t, server)
+
+			// A primeira ocupa o worker e a segunda, a fila
+			go client.EmitWithAck(context.Background(), "chat", &ChatMessage{Content: "1"})
+			<-started
+			if err := client.Emit("chat", &ChatMessage{Content: "2"}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "3"})
+			if tt.code == 0 {
+				var remoteErr *RemoteError
+				if !errors.As(err, &remoteErr) || remoteErr.Message != ErrDispatchSaturated.Error() {
+					t.Errorf("erro = %v, esperado %v", err, ErrDispatchSaturated)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("erro = %v, esperado %v", err, ErrConnectionClosed)
+			}
+			<-client.done
+			if code, _ := CloseStatus(client.CloseReason()); code != tt.code {
+				t.Errorf("código = %d, esperado %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestServerDispatchPool(t *testing.T) {
+	release := make(chan struct{})
+	server, started := blockingServer(release)
+	server.SetDispatch(DispatchConfig{Mode: DispatchPool, Workers: 2})
+	first, _ := socketPair(t, server)
+	second, _ := socketPair(t, server)
+
+	// Os dois sockets usam o mesmo pool de workers
+	replies := make(chan proto.Message, 2)
+	for _, client := range []*Socket{first, second} {
+		go func(client *Socket) {
+			reply, _ := client.EmitWithAck(context.Background(), "chat", &ChatMessage{Content: "oi"})
+			replies <- reply
+		}(client)
+	}
+	<-started
+	<-started
+	if stats := server.DispatchStats(); stats.Running != 2 {
+		t.Errorf("em execução = %d, esperado 2", stats.Running)
+	}
+	close(release)
+	for range 2 {
+		if reply := <-replies; reply == nil {
+			t.Error("ack sem resposta")
+		}
+	}
+}
+
+func TestServerSetDispatchKeepsConnectedPool(t *testing.T) {
+	tests := []struct {
+		name string
+		next DispatchConfig
+	}{
+		{"novo pool", DispatchConfig{Mode: DispatchPool, Workers: 1}},
+		{"volta ao ordenado", DispatchConfig{Mode: DispatchOrdered}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			close(release)
+			server, _ := blockingServer(release)
+			server.SetDispatch(DispatchConfig{Mode: DispatchPool, Workers: 2})
+			server.lock.Lock()
+			old := server.pool
+			server.lock.Unlock()
+			first, remote := socketPair(t, server)
+
+			// Trocar o modo não derruba os sockets que usam o pool anterior
+			server.SetDispatch(tt.next)
+			second, _ := socketPair(t, server)
+			for _, client := range []*Socket{first, second} {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				_, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "oi"})
+				cancel()
+				if err != nil {
+					t.Fatalf("erro = %v, esperado resposta", err)
+				}
+			}
+			select {
+			case <-old.done:
+				t.Fatal("pool anterior parou com socket conectado")
+			default:
+			}
+
+			// Quando o último socket do pool anterior fecha, os workers param
+			first.Conn.Close()
+			<-remote.done
+			select {
+			case <-old.done:
+			case <-time.After(time.Second):
+				t.Error("pool anterior continua ativo sem sockets")
+			}
+		})
+	}
+}
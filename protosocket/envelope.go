@@ -0,0 +1,135 @@
+package protosocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtocolVersion é a versão do envelope emitida por esta biblioteca.
+const ProtocolVersion uint32 = 1
+
+var ErrUnsupportedVersion = errors.New("versão do envelope não suportada")
+
+// EmitOption ajusta o envelope antes do envio.
+type EmitOption func(*Message)
+
+// WithHeader adiciona um cabeçalho ao envelope.
+func WithHeader(key, value string) EmitOption {
+	return func(m *Message) {
+		if m.Headers == nil {
+			m.Headers = make(map[string]string)
+		}
+		m.Headers[key] = value
+	}
+}
+
+// newEnvelope serializa o payload e preenche os campos comuns do envelope.
+func newEnvelope(event string, data proto.Message, senderID string, sequence uint64, opts ...EmitOption) (*Message, error) {
+	var payload []byte
+	if data != nil {
+		var err error
+		payload, err = proto.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao serializar mensagem: %w", err)
+		}
+	}
+
+	msg := &Message{
+		Version:   ProtocolVersion,
+		Event:     event,
+		Data:      payload,
+		DataType:  dataTypeOf(data),
+		MessageId: uuid.New().String(),
+		Sequence:  sequence,
+		Timestamp: time.Now().UnixMilli(),
+		SenderId:  senderID,
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return msg, nil
+}
+
+// newReply monta o envelope de resposta de um EmitWithAck.
+func newReply(ackID, senderID string, data proto.Message, handlerErr error) *Message {
+	msg := &Message{
+		Version:   ProtocolVersion,
+		MessageId: uuid.New().String(),
+		Timestamp: time.Now().UnixMilli(),
+		SenderId:  senderID,
+		AckId:     ackID,
+		IsReply:   true,
+	}
+
+	var invalid *ValidationError
+	if handlerErr != nil {
+		msg.Error = handlerErr.Error()
+		// Recusas de validação seguem estruturadas para o remetente
+		if errors.As(handlerErr, &invalid) {
+			data = invalid
+		} else {
+			data = nil
+		}
+	}
+	if data != nil {
+		b, err := proto.Marshal(data)
+		if err != nil {
+			msg.Error = err.Error()
+		} else {
+			msg.Data = b
+			msg.DataType = dataTypeOf(data)
+		}
+	}
+	return msg
+}
+
+// decodeEnvelope desserializa o envelope e recusa versões mais novas que a suportada.
+func decodeEnvelope(b []byte) (*Message, error) {
+	var msg Message
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, checkVersion(&msg)
+}
+
+func checkVersion(msg *Message) error {
+	if msg.Version > ProtocolVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, msg.Version)
+	}
+	return nil
+}
+
+// HasFlag indica se o envelope tem o flag informado.
+func (m *Message) HasFlag(flag EnvelopeFlag) bool {
+	return m.GetFlags()&uint32(flag) != 0
+}
+
+// SetFlag liga o flag informado no envelope.
+func (m *Message) SetFlag(flag EnvelopeFlag) {
+	m.Flags |= uint32(flag)
+}
+
+// SentAt retorna o horário de envio registrado pelo remetente.
+func (m *Message) SentAt() time.Time {
+	return time.UnixMilli(m.GetTimestamp())
+}
+
+// metadata extrai os dados do envelope expostos aos middlewares.
+func (m *Message) metadata() MessageMetadata {
+	return MessageMetadata{
+		Event:     m.Event,
+		AckID:     m.AckId,
+		DataType:  m.DataType,
+		SenderID:  m.SenderId,
+		Sequence:  m.Sequence,
+		MessageID: m.MessageId,
+		Timestamp: m.SentAt(),
+		Headers:   m.Headers,
+		Version:   m.Version,
+		Priority:  m.Priority,
+	}
+}
@@ -0,0 +1,141 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewEnvelope(t *testing.T) {
+	msg, err := newEnvelope("chat", &ChatMessage{Content: "oi"}, "a", 7, WithHeader("trace", "1"), WithHeader("lang", "pt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newEnvelope("chat", nil, "a", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Version != ProtocolVersion || msg.SenderId != "a" || msg.Sequence != 7 {
+		t.Errorf("envelope = %v", msg)
+	}
+	if msg.DataType != dataTypeOf(&ChatMessage{}) || other.DataType != "" || other.Data != nil {
+		t.Errorf("tipos = %q e %q", msg.DataType, other.DataType)
+	}
+	if msg.MessageId == "" || msg.MessageId == other.MessageId {
+		t.Errorf("ids = %q e %q", msg.MessageId, other.MessageId)
+	}
+	if msg.Headers["trace"] != "1" || msg.Headers["lang"] != "pt" {
+		t.Errorf("cabeçalhos = %v", msg.Headers)
+	}
+	if time.Since(msg.SentAt()) > time.Second {
+		t.Errorf("horário de envio = %v", msg.SentAt())
+	}
+}
+
+func TestNewReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     proto.Message
+		err      error
+		dataType string
+		errText  string
+	}{
+		{"com resposta", &ChatMessage{Content: "oi"}, nil, dataTypeOf(&ChatMessage{}), ""},
+		{"sem resposta", nil, nil, "", ""},
+		{"com erro", &ChatMessage{Content: "oi"}, errors.New("falhou"), "", "falhou"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := newReply("ack", "a", tt.data, tt.err)
+			if !reply.IsReply || reply.AckId != "ack" || reply.Version != ProtocolVersion {
+				t.Errorf("resposta = %v", reply)
+			}
+			if reply.DataType != tt.dataType || reply.Error != tt.errText {
+				t.Errorf("tipo = %q, erro = %q; esperado %q, %q", reply.DataType, reply.Error, tt.dataType, tt.errText)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint32
+		err     error
+	}{
+		{"versão atual", ProtocolVersion, nil},
+		{"remetente sem versão", 0, nil},
+		{"versão mais nova", ProtocolVersion + 1, ErrUnsupportedVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := proto.Marshal(&Message{Version: tt.version, Event: "chat"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := decodeEnvelope(b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if err == nil && msg.Event != "chat" {
+				t.Errorf("evento = %q", msg.Event)
+			}
+		})
+	}
+
+	if _, err := decodeEnvelope([]byte{0xff}); err == nil {
+		t.Error("envelope inválido decodificado sem erro")
+	}
+}
+
+func TestEnvelopeFlags(t *testing.T) {
+	var msg Message
+	msg.SetFlag(EnvelopeFlag_FLAG_COMPRESSED)
+	if !msg.HasFlag(EnvelopeFlag_FLAG_COMPRESSED) || msg.HasFlag(EnvelopeFlag_FLAG_ENCRYPTED) {
+		t.Errorf("flags = %b", msg.Flags)
+	}
+	msg.SetFlag(EnvelopeFlag_FLAG_ENCRYPTED)
+	if !msg.HasFlag(EnvelopeFlag_FLAG_COMPRESSED) || !msg.HasFlag(EnvelopeFlag_FLAG_ENCRYPTED) {
+		t.Errorf("flags = %b", msg.Flags)
+	}
+}
+
+func TestClientServerEnvelope(t *testing.T) {
+	server := NewServer()
+	received := make(chan MessageMetadata, 1)
+	server.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg proto.Message) error {
+			received <- MetadataFromContext(ctx)
+			return next(ctx, msg)
+		}
+	})
+	server.On("chat", func(proto.Message, *Socket) {})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	defer client.Close()
+	if err := client.Emit("chat", &ChatMessage{Content: "oi"}, WithHeader("trace", "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case meta := <-received:
+		if meta.SenderID != client.ID || meta.Version != ProtocolVersion || meta.MessageID == "" {
+			t.Errorf("metadados = %+v", meta)
+		}
+		if meta.Headers["trace"] != "1" || meta.Sequence == 0 {
+			t.Errorf("cabeçalhos = %v, sequência = %d", meta.Headers, meta.Sequence)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mensagem do client não chegou ao servidor")
+	}
+}
@@ -0,0 +1,277 @@
+package protosocket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// FeatureFragmentation indica, no hello, que a ponta sabe remontar fragmentos.
+const FeatureFragmentation = "fragmentation"
+
+// fragmentOverhead reserva espaço para os campos do envelope de cada fragmento.
+const fragmentOverhead = 256
+
+var (
+	ErrInvalidFragment   = errors.New("fragmento inválido")
+	ErrReassemblyLimit   = errors.New("limite de memória da remontagem excedido")
+	ErrReassemblyTimeout = errors.New("remontagem da mensagem expirou")
+)
+
+// FragmentConfig controla a divisão de envelopes grandes em vários frames.
+type FragmentConfig struct {
+	// FrameSize é o maior envelope enviado em um único frame; zero desativa.
+	FrameSize int
+	// ReassemblyTimeout descarta mensagens cujos fragmentos param de chegar.
+	ReassemblyTimeout time.Duration
+	// MaxReassemblyBytes limita a memória somada das mensagens em remontagem.
+	MaxReassemblyBytes int64
+	// MaxFragments limita a quantidade de fragmentos de uma mensagem; zero não limita.
+	MaxFragments uint32
+}
+
+// DefaultFragmentConfig divide envelopes maiores que 64 KiB. A memória da
+// remontagem não passa do MaxDecompressedSize padrão, o limite de uma mensagem.
+func DefaultFragmentConfig() FragmentConfig {
+	return FragmentConfig{
+		FrameSize:          64 << 10,
+		ReassemblyTimeout:  30 * time.Second,
+		MaxReassemblyBytes: DefaultCompressionConfig().MaxDecompressedSize,
+		MaxFragments:       4096,
+	}
+}
+
+// encodeFrames serializa o envelope com o codec e, se ele passar do tamanho do
+// frame e o outro lado souber remontar, devolve os fragmentos no lugar dos dados.
+func encodeFrames(msg *Message, codec Codec, config FragmentConfig, remote *Hello) ([]byte, [][]byte, error) {
+	data, err := codec.Encode(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.FrameSize <= 0 || len(data) <= config.FrameSize || !hasFeature(remote, FeatureFragmentation) {
+		return data, nil, nil
+	}
+
+	chunk := max(config.FrameSize-fragmentOverhead, fragmentOverhead)
+	count := (len(data) + chunk - 1) / chunk
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*chunk, len(data))
+		b, err := codec.Encode(&Message{
+			Version:       ProtocolVersion,
+			MessageId:     msg.MessageId,
+			SenderId:      msg.SenderId,
+			Flags:         uint32(EnvelopeFlag_FLAG_FRAGMENT),
+			FragmentIndex: uint32(i),
+			FragmentCount: uint32(count),
+			Data:          data[i*chunk : end],
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		fragments = append(fragments, b)
+	}
+	return nil, fragments, nil
+}
+
+// fragmentSlotSize é o custo de cada posição reservada para um fragmento,
+// cobrado antes de qualquer dado chegar.
+const fragmentSlotSize = int64(unsafe.Sizeof([]byte(nil)))
+
+type partialMessage struct {
+	parts    [][]byte
+	received uint32
+	size     int64 // Posições e dados, cobrados em MaxReassemblyBytes
+	data     int64 // Só os dados, cobrados no limite por mensagem
+	timer    *time.Timer
+}
+
+// reassembler junta os fragmentos recebidos de uma conexão.
+type reassembler struct {
+	lock    sync.Mutex
+	config  FragmentConfig
+	codec   Codec
+	partial map[string]*partialMessage
+	size    int64
+	// discarded lembra as mensagens abandonadas para ignorar o resto dos fragmentos
+	discarded map[string]time.Time
+	// onExpire recebe o erro das mensagens descartadas por timeout
+	onExpire func(err error)
+}
+
+func newReassembler(codec Codec, onExpire func(err error)) *reassembler {
+	return &reassembler{
+		config:    DefaultFragmentConfig(),
+		codec:     codec,
+		partial:   make(map[string]*partialMessage),
+		discarded: make(map[string]time.Time),
+		onExpire:  onExpire,
+	}
+}
+
+func (r *reassembler) setConfig(config FragmentConfig) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.config = config
+}
+
+// receive devolve o envelope original quando o último fragmento chega e nil
+// enquanto a mensagem estiver incompleta. Envelopes sem FLAG_FRAGMENT passam
+// direto. limit é o maior tamanho de uma mensagem remontada; zero não limita.
+func (r *reassembler) receive(msg *Message, limit int64) (*Message, error) {
+	if !msg.HasFlag(EnvelopeFlag_FLAG_FRAGMENT) {
+		return msg, nil
+	}
+	data, err := r.add(msg, limit)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return r.codec.Decode(data)
+}
+
+func (r *reassembler) add(frag *Message, limit int64) ([]byte, error) {
+	id := frag.MessageId
+	if id == "" || frag.FragmentCount == 0 || frag.FragmentIndex >= frag.FragmentCount {
+		return nil, fmt.Errorf("%w: %d de %d", ErrInvalidFragment, frag.FragmentIndex, frag.FragmentCount)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.partial[id]
+	if !ok {
+		if r.isDiscarded(id) {
+			return nil, nil
+		}
+		if r.config.MaxFragments > 0 && frag.FragmentCount > r.config.MaxFragments {
+			return nil, fmt.Errorf("%w: %d fragmentos na mensagem %s", ErrReassemblyLimit, frag.FragmentCount, id)
+		}
+		// As posições dos fragmentos contam no limite antes de serem alocadas
+		slots := int64(frag.FragmentCount) * fragmentSlotSize
+		if r.config.MaxReassemblyBytes > 0 && r.size+slots > r.config.MaxReassemblyBytes {
+			return nil, fmt.Errorf("%w: mensagem %s", ErrReassemblyLimit, id)
+		}
+		p = &partialMessage{parts: make([][]byte, frag.FragmentCount), size: slots}
+		r.size += slots
+		if r.config.ReassemblyTimeout > 0 {
+			p.timer = time.AfterFunc(r.config.ReassemblyTimeout, func() { r.expire(id) })
+		}
+		r.partial[id] = p
+	}
+
+	if uint32(len(p.parts)) != frag.FragmentCount {
+		r.discard(id, p)
+		return nil, fmt.Errorf("%w: total de fragmentos divergente na mensagem %s", ErrInvalidFragment, id)
+	}
+	if p.parts[frag.FragmentIndex] != nil {
+		// Fragmento repetido
+		return nil, nil
+	}
+
+	size := int64(len(frag.Data))
+	if limit > 0 && p.data+size > limit {
+		r.discard(id, p)
+		return nil, fmt.Errorf("%w: mensagem %s passa de %d bytes", ErrReassemblyLimit, id, limit)
+	}
+	if r.config.MaxReassemblyBytes > 0 && r.size+size > r.config.MaxReassemblyBytes {
+		r.discard(id, p)
+		return nil, fmt.Errorf("%w: mensagem %s", ErrReassemblyLimit, id)
+	}
+
+	p.parts[frag.FragmentIndex] = frag.Data
+	p.received++
+	p.size += size
+	p.data += size
+	r.size += size
+	if p.received < frag.FragmentCount {
+		return nil, nil
+	}
+
+	r.drop(id, p)
+	return bytes.Join(p.parts, nil), nil
+}
+
+// drop remove a mensagem parcial. Deve ser chamado com o lock.
+func (r *reassembler) drop(id string, p *partialMessage) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	r.size -= p.size
+	delete(r.partial, id)
+}
+
+// discard abandona a mensagem e ignora os fragmentos dela que ainda chegarem.
+// Deve ser chamado com o lock.
+func (r *reassembler) discard(id string, p *partialMessage) {
+	r.drop(id, p)
+	r.discarded[id] = time.Now()
+}
+
+// isDiscarded indica se a mensagem foi abandonada, esquecendo as que já
+// passaram do timeout. Deve ser chamado com o lock.
+func (r *reassembler) isDiscarded(id string) bool {
+	for discardedID, at := range r.discarded {
+		if time.Since(at) > r.config.ReassemblyTimeout {
+			delete(r.discarded, discardedID)
+		}
+	}
+	_, ok := r.discarded[id]
+	return ok
+}
+
+func (r *reassembler) expire(id string) {
+	r.lock.Lock()
+	p, ok := r.partial[id]
+	if ok {
+		r.discard(id, p)
+	}
+	r.lock.Unlock()
+
+	if ok && r.onExpire != nil {
+		r.onExpire(fmt.Errorf("%w: mensagem %s com %d de %d fragmentos", ErrReassemblyTimeout,
+			id, p.received, len(p.parts)))
+	}
+}
+
+// stop descarta as mensagens incompletas quando a conexão termina.
+func (r *reassembler) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, p := range r.partial {
+		r.drop(id, p)
+	}
+	clear(r.discarded)
+}
+
+// SetFragmentation define a fragmentação das próximas conexões.
+func (s *Server) SetFragmentation(config FragmentConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fragmentation = config
+}
+
+// SetFragmentation define o tamanho dos frames enviados e os limites da remontagem.
+func (s *Socket) SetFragmentation(config FragmentConfig) {
+	s.lock.Lock()
+	s.fragmentation = config
+	s.lock.Unlock()
+	s.reassembly.setConfig(config)
+}
+
+// SetFragmentation define o tamanho dos frames enviados e os limites da remontagem.
+func (c *Client) SetFragmentation(config FragmentConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fragmentation = config
+	c.reassembly.setConfig(config)
+}
+
+// SetFragmentation define a fragmentação dos próximos links.
+func (p *Peer) SetFragmentation(config FragmentConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.fragmentation = config
+}
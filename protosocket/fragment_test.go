@@ -0,0 +1,367 @@
+package protosocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var fragmentRemote = &Hello{Version: ProtocolVersion, Features: []string{FeatureFragmentation}}
+
+// splitMessage fragmenta um envelope com dados de size bytes em frames de frameSize.
+func splitMessage(t *testing.T, size, frameSize int) (*Message, []*Message) {
+	t.Helper()
+	msg := &Message{
+		Version:   ProtocolVersion,
+		Event:     "upload",
+		MessageId: "msg-1",
+		SenderId:  "a",
+		Data:      bytes.Repeat([]byte("0123456789"), size/10),
+	}
+	config := DefaultFragmentConfig()
+	config.FrameSize = frameSize
+	_, frames, err := encodeFrames(msg, protobufCodec{}, config, fragmentRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments := make([]*Message, 0, len(frames))
+	for _, frame := range frames {
+		frag, err := protobufCodec{}.Decode(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fragments = append(fragments, frag)
+	}
+	return msg, fragments
+}
+
+func TestEncodeFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		frameSize int
+		remote    *Hello
+		fragments int
+	}{
+		{"cabe em um frame", 100, 1024, fragmentRemote, 0},
+		{"fragmentação desativada", 10000, 0, fragmentRemote, 0},
+		{"outro lado sem o recurso", 10000, 1024, &Hello{Version: ProtocolVersion}, 0},
+		{"outro lado sem hello", 10000, 1024, nil, 0},
+		{"fragmentado", 10000, 1024, fragmentRemote, 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{MessageId: "msg-1", Data: make([]byte, tt.size)}
+			config := DefaultFragmentConfig()
+			config.FrameSize = tt.frameSize
+			data, frames, err := encodeFrames(msg, protobufCodec{}, config, tt.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != tt.fragments {
+				t.Fatalf("fragmentos = %d, esperado %d", len(frames), tt.fragments)
+			}
+			if tt.fragments == 0 && data == nil {
+				t.Fatal("envelope inteiro não foi serializado")
+			}
+			for _, frame := range frames {
+				if len(frame) > tt.frameSize {
+					t.Errorf("frame de %d bytes passa de %d", len(frame), tt.frameSize)
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerOrder(t *testing.T) {
+	msg, fragments := splitMessage(t, 10000, 1024)
+	n := len(fragments)
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"em ordem", sequence(0, n)},
+		{"invertidos", reversed(sequence(0, n))},
+		{"intercalados", append(evens(n), odds(n)...)},
+		{"último primeiro", append([]int{n - 1}, sequence(0, n-1)...)},
+		{"com repetidos", append([]int{0, 0, 3, 3}, sequence(1, n)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(protobufCodec{}, nil)
+			var got *Message
+			for i, index := range tt.order {
+				out, err := r.receive(fragments[index], 0)
+				if err != nil {
+					t.Fatalf("fragmento %d: %v", index, err)
+				}
+				if out != nil {
+					if got != nil {
+						t.Fatal("mensagem remontada duas vezes")
+					}
+					if i != len(tt.order)-1 && !containsAll(tt.order[:i+1], n) {
+						t.Fatal("mensagem remontada antes do último fragmento")
+					}
+					got = out
+				}
+			}
+			if !proto.Equal(got, msg) {
+				t.Fatal("mensagem remontada difere da original")
+			}
+			if r.size != 0 || len(r.partial) != 0 {
+				t.Errorf("memória não liberada: size=%d parciais=%d", r.size, len(r.partial))
+			}
+		})
+	}
+}
+
+func TestReassemblerPassthrough(t *testing.T) {
+	r := newReassembler(protobufCodec{}, nil)
+	msg := &Message{Event: "chat", MessageId: "msg-1"}
+	out, err := r.receive(msg, 0)
+	if err != nil || out != msg {
+		t.Fatalf("envelope sem FLAG_FRAGMENT alterado: %v, %v", out, err)
+	}
+}
+
+func TestReassemblerHostile(t *testing.T) {
+	fragment := func(id string, index, count uint32, size int) *Message {
+		return &Message{
+			MessageId:     id,
+			Flags:         uint32(EnvelopeFlag_FLAG_FRAGMENT),
+			FragmentIndex: index,
+			FragmentCount: count,
+			Data:          make([]byte, size),
+		}
+	}
+	limits := FragmentConfig{ReassemblyTimeout: time.Minute, MaxReassemblyBytes: 64 << 10, MaxFragments: 16}
+
+	tests := []struct {
+		name      string
+		config    FragmentConfig
+		fragments []*Message
+		err       error
+		// limit é o tamanho máximo de uma mensagem; zero não limita
+		limit int64
+	}{
+		{"sem id", limits, []*Message{fragment("", 0, 2, 10)}, ErrInvalidFragment, 0},
+		{"total zero", limits, []*Message{fragment("m", 0, 0, 10)}, ErrInvalidFragment, 0},
+		{"índice além do total", limits, []*Message{fragment("m", 2, 2, 10)}, ErrInvalidFragment, 0},
+		{"fragmentos demais", limits, []*Message{fragment("m", 0, 17, 10)}, ErrReassemblyLimit, 0},
+		{
+			name:      "total gigante sem limite de fragmentos",
+			config:    FragmentConfig{ReassemblyTimeout: time.Minute, MaxReassemblyBytes: 64 << 20},
+			fragments: []*Message{fragment("m", 0, 1<<31, 10)},
+			err:       ErrReassemblyLimit,
+		},
+		{
+			name:      "total divergente",
+			config:    limits,
+			fragments: []*Message{fragment("m", 0, 4, 10), fragment("m", 1, 8, 10)},
+			err:       ErrInvalidFragment,
+		},
+		{
+			name:      "dados além do limite",
+			config:    limits,
+			fragments: []*Message{fragment("m", 0, 2, 32<<10), fragment("m", 1, 2, 32<<10)},
+			err:       ErrReassemblyLimit,
+		},
+		{
+			name:      "mensagem além do limite por mensagem",
+			config:    limits,
+			fragments: []*Message{fragment("m", 0, 3, 10<<10), fragment("m", 1, 3, 10<<10)},
+			err:       ErrReassemblyLimit,
+			limit:     16 << 10,
+		},
+		{
+			name:   "limite somado entre mensagens",
+			config: limits,
+			fragments: []*Message{
+				fragment("a", 0, 2, 40<<10),
+				fragment("b", 0, 2, 40<<10),
+			},
+			err: ErrReassemblyLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(protobufCodec{}, nil)
+			r.setConfig(tt.config)
+
+			var err error
+			for _, frag := range tt.fragments {
+				if _, err = r.receive(frag, tt.limit); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if r.size > tt.config.MaxReassemblyBytes {
+				t.Errorf("memória em remontagem %d passa do limite %d", r.size, tt.config.MaxReassemblyBytes)
+			}
+
+			r.stop()
+			if r.size != 0 {
+				t.Errorf("memória não liberada: %d", r.size)
+			}
+		})
+	}
+}
+
+func TestDefaultFragmentConfig(t *testing.T) {
+	// Remontar não pode guardar mais do que uma mensagem pode ter
+	fragments, compression := DefaultFragmentConfig(), DefaultCompressionConfig()
+	if fragments.MaxReassemblyBytes > compression.MaxDecompressedSize {
+		t.Errorf("MaxReassemblyBytes = %d, acima do MaxDecompressedSize %d",
+			fragments.MaxReassemblyBytes, compression.MaxDecompressedSize)
+	}
+}
+
+func TestReassemblerDiscarded(t *testing.T) {
+	r := newReassembler(protobufCodec{}, nil)
+	_, fragments := splitMessage(t, 4000, 1024)
+
+	bad := proto.Clone(fragments[1]).(*Message)
+	bad.FragmentCount++
+	if _, err := r.receive(fragments[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.receive(bad, 0); !errors.Is(err, ErrInvalidFragment) {
+		t.Fatalf("erro = %v, esperado %v", err, ErrInvalidFragment)
+	}
+	// O resto da mensagem abandonada é ignorado em silêncio
+	for _, frag := range fragments[1:] {
+		out, err := r.receive(frag, 0)
+		if out != nil || err != nil {
+			t.Fatalf("fragmento de mensagem abandonada aceito: %v, %v", out, err)
+		}
+	}
+	if r.size != 0 || len(r.partial) != 0 {
+		t.Errorf("memória não liberada: size=%d parciais=%d", r.size, len(r.partial))
+	}
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+	expired := make(chan error, 1)
+	r := newReassembler(protobufCodec{}, func(err error) { expired <- err })
+	config := DefaultFragmentConfig()
+	config.ReassemblyTimeout = 20 * time.Millisecond
+	r.setConfig(config)
+
+	_, fragments := splitMessage(t, 4000, 1024)
+	if _, err := r.receive(fragments[0], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-expired:
+		if !errors.Is(err, ErrReassemblyTimeout) {
+			t.Fatalf("erro = %v, esperado %v", err, ErrReassemblyTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("remontagem não expirou")
+	}
+
+	r.lock.Lock()
+	size, partial := r.size, len(r.partial)
+	r.lock.Unlock()
+	if size != 0 || partial != 0 {
+		t.Errorf("memória não liberada: size=%d parciais=%d", size, partial)
+	}
+
+	// O prazo volta a ser longo para a mensagem continuar lembrada como descartada
+	r.setConfig(DefaultFragmentConfig())
+	for _, frag := range fragments[1:] {
+		if out, err := r.receive(frag, 0); out != nil || err != nil {
+			t.Fatalf("fragmento depois do timeout aceito: %v, %v", out, err)
+		}
+	}
+}
+
+func sequence(from, to int) []int {
+	s := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+func reversed(s []int) []int {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	return s
+}
+
+func evens(n int) []int {
+	var s []int
+	for i := 0; i < n; i += 2 {
+		s = append(s, i)
+	}
+	return s
+}
+
+func odds(n int) []int {
+	var s []int
+	for i := 1; i < n; i += 2 {
+		s = append(s, i)
+	}
+	return s
+}
+
+// containsAll indica se todos os índices de 0 a n-1 aparecem em order.
+func containsAll(order []int, n int) bool {
+	seen := make(map[int]bool)
+	for _, i := range order {
+		seen[i] = true
+	}
+	return len(seen) == n
+}
+
+func TestClientServerFragmentation(t *testing.T) {
+	config := DefaultFragmentConfig()
+	config.FrameSize = 1024
+	server := NewServer()
+	server.SetFragmentation(config)
+	received := make(chan []byte, 1)
+	server.On("binary", func(msg proto.Message, _ *Socket) {
+		received <- msg.(*BinaryMessage).Content
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	defer client.Close()
+	client.SetFragmentation(config)
+	// Só fragmenta depois que o hello anunciar o recurso
+	deadline := time.Now().Add(time.Second)
+	for !client.HasRemoteFeature(FeatureFragmentation) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Dados aleatórios não encolhem com a compressão e precisam de vários frames
+	content := make([]byte, 10000)
+	rand.Read(content)
+	if err := client.Emit("binary", &BinaryMessage{Filename: "a.bin", Content: content}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, content) {
+			t.Error("conteúdo remontado difere do enviado")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mensagem fragmentada não chegou")
+	}
+}
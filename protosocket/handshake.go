@@ -0,0 +1,250 @@
+package protosocket
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// SubprotocolPrefix identifica os subprotocolos desta biblioteca.
+	SubprotocolPrefix = "protosocket."
+	// SubprotocolV1 é o envelope protobuf binário da versão 1.
+	SubprotocolV1 = SubprotocolPrefix + "v1"
+	// SubprotocolV1JSON é o envelope da versão 1 em protojson, em frames de texto.
+	SubprotocolV1JSON = SubprotocolV1 + "+json"
+
+	// MinProtocolVersion é a versão mais antiga aceita no hello.
+	MinProtocolVersion uint32 = 1
+
+	// HelloEvent é o evento reservado da troca de hello.
+	HelloEvent = "$hello"
+
+	// CloseIncompatibleProtocol é o código de fechamento usado quando os dois
+	// lados não têm versão do protocolo em comum.
+	CloseIncompatibleProtocol = 4000
+)
+
+// Recursos anunciados no hello.
+const (
+	FeatureAck       = "ack"
+	FeatureHeaders   = "headers"
+	FeatureHeartbeat = "heartbeat"
+)
+
+// SupportedSubprotocols lista os subprotocolos aceitos, em ordem de preferência.
+// Cresce com RegisterCodec; o pacote lê a lista por supportedSubprotocols.
+var SupportedSubprotocols = []string{SubprotocolV1, SubprotocolV1JSON}
+
+// localFeatures são os recursos anunciados por esta ponta.
+var localFeatures = []string{FeatureAck, FeatureHeaders, FeatureHeartbeat, FeatureGzip, FeatureFlate, FeatureFragmentation, FeatureSequencing, FeatureDelivery, FeatureValidation}
+
+var ErrIncompatibleProtocol = errors.New("versão do protocolo incompatível")
+
+// subprotocolVersion extrai a versão de um subprotocolo como "protosocket.v2+json".
+func subprotocolVersion(protocol string) (uint32, bool) {
+	name, ok := strings.CutPrefix(protocol, SubprotocolPrefix+"v")
+	if !ok {
+		return 0, false
+	}
+	name, _, _ = strings.Cut(name, "+")
+	version, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(version), true
+}
+
+// negotiateSubprotocol escolhe o subprotocolo suportado preferido entre os pedidos.
+// Quem não pede nenhum subprotocolo da biblioteca é tratado como cliente legado
+// e recebe "" sem erro.
+func negotiateSubprotocol(offered []string) (string, error) {
+	supported := supportedSubprotocols()
+	for _, preferred := range supported {
+		for _, protocol := range offered {
+			if protocol == preferred {
+				return protocol, nil
+			}
+		}
+	}
+	for _, protocol := range offered {
+		if strings.HasPrefix(protocol, SubprotocolPrefix) {
+			return "", fmt.Errorf("%w: pedido %s, suportados %s", ErrIncompatibleProtocol,
+				strings.Join(offered, ", "), strings.Join(supported, ", "))
+		}
+	}
+	return "", nil
+}
+
+// rejectConn encerra uma conexão recém-aberta sem versão em comum.
+func rejectConn(conn *websocket.Conn, err error) {
+	msg := websocket.FormatCloseMessage(CloseIncompatibleProtocol, err.Error())
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// newDialer devolve um dialer que pede os subprotocolos suportados.
+func newDialer() *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = supportedSubprotocols()
+	// Apenas oferece o permessage-deflate; quem decide é o servidor
+	dialer.EnableCompression = true
+	return &dialer
+}
+
+// newHello monta o envelope de hello desta ponta, com a versão do subprotocolo
+// negociado. A sequência do hello é a última enviada antes dele, usada pelo
+// outro lado como ponto de partida da entrega em ordem.
+func newHello(id, protocol string, sequence uint64) (*Message, error) {
+	version, ok := subprotocolVersion(protocol)
+	if !ok {
+		version = ProtocolVersion
+	}
+	return newEnvelope(HelloEvent, &Hello{
+		Version:  version,
+		Features: localFeatures,
+		Id:       id,
+	}, id, sequence)
+}
+
+// checkHello valida o hello recebido do outro lado contra o subprotocolo negociado.
+func checkHello(msg *Message, protocol string) (*Hello, error) {
+	var hello Hello
+	if err := proto.Unmarshal(msg.Data, &hello); err != nil {
+		return nil, fmt.Errorf("hello inválido: %w", err)
+	}
+	if hello.Version < MinProtocolVersion || hello.Version > ProtocolVersion {
+		return nil, fmt.Errorf("%w: remoto %d, suportadas %d a %d", ErrIncompatibleProtocol,
+			hello.Version, MinProtocolVersion, ProtocolVersion)
+	}
+	if version, ok := subprotocolVersion(protocol); ok && hello.Version != version {
+		return nil, fmt.Errorf("%w: hello na versão %d, subprotocolo %s", ErrIncompatibleProtocol,
+			hello.Version, protocol)
+	}
+	return &hello, nil
+}
+
+func hasFeature(hello *Hello, feature string) bool {
+	return slices.Contains(hello.GetFeatures(), feature)
+}
+
+// sendHello anuncia a versão e os recursos quando um subprotocolo foi negociado.
+func (s *Socket) sendHello() {
+	if s.Conn.Subprotocol() == "" {
+		return
+	}
+	hello, err := newHello(s.ID, s.Conn.Subprotocol(), atomic.LoadUint64(&s.sequence))
+	if err == nil {
+		err = s.send(hello)
+	}
+	if err != nil {
+		s.reportError(fmt.Errorf("erro ao enviar hello: %w", err))
+	}
+}
+
+// handleHello guarda o hello remoto ou fecha a conexão se a versão for incompatível.
+func (s *Socket) handleHello(msg *Message) {
+	hello, err := checkHello(msg, s.Conn.Subprotocol())
+	if err != nil {
+		s.setCloseReason(err)
+		s.Close(CloseIncompatibleProtocol, err.Error())
+		return
+	}
+	s.lock.Lock()
+	s.remote = hello
+	s.lock.Unlock()
+}
+
+// Subprotocol retorna o subprotocolo negociado; vazio para clientes legados.
+func (s *Socket) Subprotocol() string {
+	return s.Conn.Subprotocol()
+}
+
+// RemoteFeatures retorna os recursos anunciados pelo outro lado no hello.
+func (s *Socket) RemoteFeatures() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.remote.GetFeatures()
+}
+
+// HasRemoteFeature indica se o outro lado anunciou o recurso no hello.
+func (s *Socket) HasRemoteFeature(feature string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return hasFeature(s.remote, feature)
+}
+
+// sendHello vai direto para a conexão, sem passar pelo buffer de reconexão.
+func (c *Client) sendHello() {
+	protocol := c.Subprotocol()
+	if protocol == "" {
+		return
+	}
+	hello, err := newHello(c.ID, protocol, c.helloSequence())
+	if err == nil {
+		err = c.write(hello)
+	}
+	if err != nil {
+		c.reportError(fmt.Errorf("erro ao enviar hello: %w", err))
+	}
+}
+
+func (c *Client) handleHello(msg *Message) {
+	hello, err := checkHello(msg, c.Subprotocol())
+	if err != nil {
+		c.closeWithCode(CloseIncompatibleProtocol, err)
+		return
+	}
+	c.lock.Lock()
+	c.remote = hello
+	c.lock.Unlock()
+	c.sequencer.reset(msg.Sequence)
+}
+
+// helloSequence é a última sequência enviada antes da conexão atual. Depois de
+// uma reconexão, o buffer ainda não enviado conta como novo.
+func (c *Client) helloSequence() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sequence := atomic.LoadUint64(&c.sequence)
+	for _, envelope := range c.outbox {
+		if envelope.Sequence > 0 && envelope.Sequence <= sequence {
+			sequence = envelope.Sequence - 1
+		}
+	}
+	return sequence
+}
+
+// Subprotocol retorna o subprotocolo negociado; vazio quando o servidor é legado.
+func (c *Client) Subprotocol() string {
+	conn, _, _ := c.connection()
+	return conn.Subprotocol()
+}
+
+// RemoteID retorna o ID anunciado pelo outro lado no hello.
+func (c *Client) RemoteID() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remote.GetId()
+}
+
+// RemoteFeatures retorna os recursos anunciados pelo outro lado no hello.
+func (c *Client) RemoteFeatures() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remote.GetFeatures()
+}
+
+// HasRemoteFeature indica se o outro lado anunciou o recurso no hello.
+func (c *Client) HasRemoteFeature(feature string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return hasFeature(c.remote, feature)
+}
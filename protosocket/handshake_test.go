@@ -0,0 +1,186 @@
+package protosocket
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSubprotocolVersion(t *testing.T) {
+	tests := []struct {
+		protocol string
+		version  uint32
+		ok       bool
+	}{
+		{SubprotocolV1, 1, true},
+		{"protosocket.v2+json", 2, true},
+		{"protosocket.vx", 0, false},
+		{"bearer.token", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			version, ok := subprotocolVersion(tt.protocol)
+			if version != tt.version || ok != tt.ok {
+				t.Errorf("versão = %d, %v; esperado %d, %v", version, ok, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNegotiateSubprotocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  []string
+		protocol string
+		err      error
+	}{
+		{"versão suportada", []string{SubprotocolV1}, SubprotocolV1, nil},
+		{"cliente legado", nil, "", nil},
+		{"outros subprotocolos", []string{"bearer.abc"}, "", nil},
+		{"suportada entre outras", []string{"protosocket.v9", "bearer.abc", SubprotocolV1}, SubprotocolV1, nil},
+		{"só versões desconhecidas", []string{"protosocket.v9"}, "", ErrIncompatibleProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol, err := negotiateSubprotocol(tt.offered)
+			if protocol != tt.protocol || !errors.Is(err, tt.err) {
+				t.Errorf("negociado %q (%v), esperado %q (%v)", protocol, err, tt.protocol, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckHello(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint32
+		protocol string
+		err      error
+	}{
+		{"versão do subprotocolo", 1, SubprotocolV1, nil},
+		{"sem subprotocolo", 1, "", nil},
+		{"versão zero", 0, SubprotocolV1, ErrIncompatibleProtocol},
+		{"versão mais nova", ProtocolVersion + 1, "", ErrIncompatibleProtocol},
+		{"diferente do subprotocolo", 1, "protosocket.v2", ErrIncompatibleProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := newEnvelope(HelloEvent, &Hello{Version: tt.version, Id: "a"}, "a", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hello, err := checkHello(msg, tt.protocol)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if err == nil && hello.GetId() != "a" {
+				t.Errorf("hello = %v", hello)
+			}
+		})
+	}
+}
+
+func TestServerHello(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+		// version é a versão do hello enviado pelo cliente; zero não envia hello
+		version uint32
+		code    int
+	}{
+		{"hello compatível", []string{SubprotocolV1}, ProtocolVersion, 0},
+		{"hello em outra versão", []string{SubprotocolV1}, ProtocolVersion + 1, CloseIncompatibleProtocol},
+		{"subprotocolo desconhecido", []string{"protosocket.v9"}, 0, CloseIncompatibleProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			connected := make(chan *Socket, 1)
+			server.OnConnection(func(socket *Socket) { connected <- socket })
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			dialer := websocket.Dialer{Subprotocols: tt.protocols}
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+
+			if tt.version != 0 {
+				// O servidor anuncia o próprio hello logo após o upgrade
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg, err := decodeEnvelope(data)
+				if err != nil || msg.Event != HelloEvent {
+					t.Fatalf("primeira mensagem = %v (%v), esperado hello", msg, err)
+				}
+
+				hello, err := newEnvelope(HelloEvent, &Hello{Version: tt.version, Id: "cliente", Features: []string{FeatureAck}}, "cliente", 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := proto.Marshal(hello)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.code == 0 {
+				socket := <-connected
+				deadline := time.Now().Add(time.Second)
+				for !socket.HasRemoteFeature(FeatureAck) && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				if !slices.Equal(socket.RemoteFeatures(), []string{FeatureAck}) || socket.Subprotocol() != SubprotocolV1 {
+					t.Errorf("recursos = %v, subprotocolo = %q", socket.RemoteFeatures(), socket.Subprotocol())
+				}
+				return
+			}
+
+			if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, tt.code) {
+				t.Errorf("leitura = %v, esperado fechamento %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestClientHello(t *testing.T) {
+	server := NewServer()
+	connected := make(chan *Socket, 1)
+	server.OnConnection(func(socket *Socket) { connected <- socket })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	defer client.Close()
+	socket := <-connected
+
+	deadline := time.Now().Add(time.Second)
+	for client.RemoteID() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if client.RemoteID() != socket.ID || client.Subprotocol() != SubprotocolV1 {
+		t.Errorf("RemoteID = %q, subprotocolo = %q; esperado %q", client.RemoteID(), client.Subprotocol(), socket.ID)
+	}
+	if !client.HasRemoteFeature(FeatureHeartbeat) {
+		t.Errorf("recursos do servidor = %v", client.RemoteFeatures())
+	}
+}
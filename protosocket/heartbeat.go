@@ -0,0 +1,146 @@
+package protosocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrHeartbeatTimeout = errors.New("heartbeat sem resposta")
+
+// HeartbeatConfig define o intervalo dos pings e quanto tempo esperar por um pong.
+// Interval zero desativa o heartbeat.
+type HeartbeatConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// DefaultHeartbeatConfig retorna a configuração usada por Server, Client e Peer.
+func DefaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		Interval: 25 * time.Second,
+		Timeout:  60 * time.Second,
+	}
+}
+
+// heartbeat envia pings periódicos, mede o RTT pelos pongs e detecta conexões mortas.
+type heartbeat struct {
+	conn      *websocket.Conn
+	config    HeartbeatConfig
+	rtt       atomic.Int64
+	lastPong  atomic.Int64
+	done      chan struct{}
+	stopOnce  sync.Once
+	failOnce  sync.Once
+	onTimeout func()
+}
+
+func newHeartbeat(conn *websocket.Conn, config HeartbeatConfig, onTimeout func()) *heartbeat {
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * config.Interval
+	}
+
+	h := &heartbeat{
+		conn:      conn,
+		config:    config,
+		done:      make(chan struct{}),
+		onTimeout: onTimeout,
+	}
+	h.lastPong.Store(time.Now().UnixNano())
+
+	conn.SetReadDeadline(h.deadline(time.Now()))
+	conn.SetPongHandler(h.handlePong)
+	conn.SetPingHandler(h.handlePing)
+	return h
+}
+
+// handlePong registra o RTT e estende o prazo de leitura.
+func (h *heartbeat) handlePong(appData string) error {
+	now := time.Now()
+	h.lastPong.Store(now.UnixNano())
+
+	if len(appData) == 8 {
+		sent := int64(binary.BigEndian.Uint64([]byte(appData)))
+		h.rtt.Store(now.UnixNano() - sent)
+	}
+
+	return h.conn.SetReadDeadline(h.deadline(now))
+}
+
+// deadline dá uma folga de um intervalo ao prazo de leitura, para que a
+// verificação periódica detecte a falha antes do erro de leitura.
+func (h *heartbeat) deadline(now time.Time) time.Time {
+	return now.Add(h.config.Timeout + h.config.Interval)
+}
+
+// handlePing responde o ping do outro lado; receber um ping também prova que a conexão está viva.
+func (h *heartbeat) handlePing(appData string) error {
+	now := time.Now()
+	h.lastPong.Store(now.UnixNano())
+	h.conn.SetReadDeadline(h.deadline(now))
+
+	err := h.conn.WriteControl(websocket.PongMessage, []byte(appData), now.Add(time.Second))
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
+	return err
+}
+
+func (h *heartbeat) run() {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(time.Unix(0, h.lastPong.Load())) > h.config.Timeout {
+				h.fail()
+				h.conn.Close()
+				return
+			}
+
+			payload := make([]byte, 8)
+			binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+			// WriteControl pode ser chamado em paralelo com a goroutine de escrita
+			if err := h.conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// fail notifica a perda do heartbeat uma única vez, seja pela verificação
+// periódica ou pelo prazo de leitura estourado.
+func (h *heartbeat) fail() {
+	h.failOnce.Do(func() {
+		if h.onTimeout != nil {
+			h.onTimeout()
+		}
+	})
+}
+
+// handleReadError notifica a perda do heartbeat quando a leitura falhou por prazo.
+func (h *heartbeat) handleReadError(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		h.fail()
+	}
+}
+
+func (h *heartbeat) stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// RTT retorna o último tempo de ida e volta medido.
+func (h *heartbeat) RTT() time.Duration {
+	return time.Duration(h.rtt.Load())
+}
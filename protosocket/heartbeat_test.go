@@ -0,0 +1,90 @@
+package protosocket
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name string
+		// reads indica se o cliente lê a conexão, respondendo aos pings
+		reads  bool
+		missed bool
+	}{
+		{"cliente responde", true, false},
+		{"cliente mudo", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetHeartbeat(HeartbeatConfig{Interval: 20 * time.Millisecond, Timeout: 60 * time.Millisecond})
+			missed := make(chan struct{}, 1)
+			connected := make(chan *Socket, 1)
+			server.OnConnection(func(socket *Socket) {
+				socket.OnHeartbeatMissed(func(*Socket) { missed <- struct{}{} })
+				connected <- socket
+			})
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			closed := make(chan struct{})
+			if tt.reads {
+				go func() {
+					defer close(closed)
+					for {
+						if _, _, err := conn.ReadMessage(); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			socket := <-connected
+
+			select {
+			case <-missed:
+				if !tt.missed {
+					t.Fatal("heartbeat perdido com o cliente respondendo")
+				}
+			case <-time.After(300 * time.Millisecond):
+				if tt.missed {
+					t.Fatal("heartbeat perdido não foi detectado")
+				}
+			}
+
+			if tt.missed {
+				// O servidor encerra a conexão morta
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						var netErr net.Error
+						if errors.As(err, &netErr) && netErr.Timeout() {
+							t.Fatal("conexão não foi encerrada")
+						}
+						return
+					}
+				}
+			}
+			if socket.RTT() <= 0 {
+				t.Error("RTT não foi medido")
+			}
+			select {
+			case <-closed:
+				t.Error("conexão viva foi encerrada")
+			default:
+			}
+		})
+	}
+}
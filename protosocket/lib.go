@@ -1,6 +1,7 @@
 package protosocket
 
 import (
+	"context"
 	"log"
 	"net/http"
 )
@@ -9,16 +10,16 @@ type ServerConfig struct {
 	Path string
 }
 
-
-
 type SocketServer interface {
 	OnConnection(func(*Socket))
 	Start(port string) error
+	Shutdown(ctx context.Context) error
 }
 
 type socketServer struct {
 	*Server
-	config ServerConfig
+	config     ServerConfig
+	httpServer *http.Server
 }
 
 func New(config ...ServerConfig) SocketServer {
@@ -42,5 +43,9 @@ func (s *socketServer) Start(port string) error {
 
 	http.Handle(s.config.Path, s.Server)
 	log.Printf("Servidor WebSocket iniciado em %s%s", port, s.config.Path)
-	return http.ListenAndServe(port, nil)
+	httpServer := &http.Server{Addr: port}
+	s.lock.Lock()
+	s.httpServer = httpServer
+	s.lock.Unlock()
+	return ignoreServerClosed(httpServer.ListenAndServe())
 }
@@ -0,0 +1,168 @@
+package protosocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// CloseStatus extrai o código e o motivo de um frame de fechamento.
+// Retorna -1 quando o erro não veio de um fechamento do protocolo WebSocket.
+func CloseStatus(err error) (int, string) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code, closeErr.Text
+	}
+	return -1, ""
+}
+
+// setCloseReason guarda o primeiro motivo de encerramento; os erros de leitura
+// que vêm depois são consequência dele.
+func (s *Socket) setCloseReason(reason error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closeReason == nil {
+		s.closeReason = reason
+	}
+}
+
+// CloseReason retorna o motivo pelo qual a conexão foi encerrada.
+func (s *Socket) CloseReason() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closeReason
+}
+
+// OnError registra um callback para erros que não encerram a conexão, como
+// falhas de decodificação e erros retornados pelos handlers.
+func (s *Socket) OnError(callback func(socket *Socket, err error)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onError = callback
+}
+
+// reportError entrega o erro ao callback registrado ou, sem callback, ao log.
+func (s *Socket) reportError(err error) {
+	s.lock.Lock()
+	callback := s.onError
+	s.lock.Unlock()
+
+	if callback != nil {
+		callback(s, err)
+		return
+	}
+	log.Printf("Erro no socket %s: %v\n", s.ID, err)
+}
+
+// OnDisconnect registra um callback chamado quando um socket é encerrado, com o motivo.
+func (s *Server) OnDisconnect(callback func(socket *Socket, reason error)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onDisconnect = callback
+}
+
+// OnError registra um callback para erros do servidor e dos seus sockets.
+// O socket é nil quando o erro acontece antes do upgrade.
+func (s *Server) OnError(callback func(socket *Socket, err error)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onError = callback
+}
+
+func (s *Server) reportError(socket *Socket, err error) {
+	s.lock.Lock()
+	callback := s.onError
+	s.lock.Unlock()
+
+	if callback != nil {
+		callback(socket, err)
+		return
+	}
+	log.Println(err)
+}
+
+func (c *Client) setCloseReason(reason error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closeReason == nil {
+		c.closeReason = reason
+	}
+}
+
+// closeWithCode registra o motivo e envia o frame de fechamento; a conexão
+// termina quando o outro lado responder ou o loop de leitura falhar.
+func (c *Client) closeWithCode(code int, reason error) {
+	c.setCloseReason(reason)
+	conn, _, _ := c.connection()
+	msg := websocket.FormatCloseMessage(code, reason.Error())
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// CloseReason retorna o motivo pelo qual a conexão foi encerrada.
+func (c *Client) CloseReason() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closeReason
+}
+
+// OnDisconnect registra um callback chamado quando a conexão é encerrada, com o motivo.
+func (c *Client) OnDisconnect(callback func(client *Client, reason error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onDisconnect = callback
+}
+
+// OnError registra um callback para erros que não encerram a conexão.
+func (c *Client) OnError(callback func(client *Client, err error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onError = callback
+}
+
+func (c *Client) reportError(err error) {
+	c.lock.Lock()
+	callback := c.onError
+	c.lock.Unlock()
+
+	if callback != nil {
+		callback(c, err)
+		return
+	}
+	c.logger.Error("erro no client", zap.String("clientID", c.ID), zap.Error(err))
+}
+
+// OnConnection registra um callback chamado quando um link com outro peer é estabelecido.
+func (p *Peer) OnConnection(callback func(clientID string)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onConnection = callback
+}
+
+// OnDisconnect registra um callback chamado quando um link com outro peer cai, com o motivo.
+func (p *Peer) OnDisconnect(callback func(clientID string, reason error)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onDisconnect = callback
+}
+
+// OnError registra um callback para erros dos links do peer.
+func (p *Peer) OnError(callback func(clientID string, err error)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onError = callback
+}
+
+func (p *Peer) reportError(clientID string, err error) {
+	p.lock.RLock()
+	callback := p.onError
+	p.lock.RUnlock()
+
+	if callback != nil {
+		callback(clientID, err)
+		return
+	}
+	p.logger.Error("erro no peer", zap.String("clientID", clientID), zap.Error(err))
+}
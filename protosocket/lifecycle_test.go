@@ -0,0 +1,134 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCloseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		reason string
+	}{
+		{"fechamento normal", &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "tchau"}, websocket.CloseNormalClosure, "tchau"},
+		{"fechamento embrulhado", errors.Join(errors.New("leitura"), &websocket.CloseError{Code: websocket.CloseGoingAway}), websocket.CloseGoingAway, ""},
+		{"outro erro", ErrHeartbeatTimeout, -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := CloseStatus(tt.err)
+			if code != tt.code || reason != tt.reason {
+				t.Errorf("CloseStatus = %d %q, esperado %d %q", code, reason, tt.code, tt.reason)
+			}
+		})
+	}
+}
+
+func TestServerOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		// disconnect derruba a conexão do lado do cliente ou do servidor
+		disconnect func(conn *websocket.Conn, server *Server)
+		reason     error
+		code       int
+	}{
+		{
+			name: "cliente fecha",
+			disconnect: func(conn *websocket.Conn, _ *Server) {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "tchau"))
+			},
+			code: websocket.CloseNormalClosure,
+		},
+		{
+			name:       "heartbeat perdido",
+			disconnect: func(*websocket.Conn, *Server) {},
+			reason:     ErrHeartbeatTimeout,
+			code:       -1,
+		},
+		{
+			name: "desligamento",
+			disconnect: func(conn *websocket.Conn, server *Server) {
+				// Lê a conexão para responder ao frame de fechamento
+				go func() {
+					for {
+						if _, _, err := conn.ReadMessage(); err != nil {
+							return
+						}
+					}
+				}()
+				server.Shutdown(context.Background())
+			},
+			reason: ErrShuttingDown,
+			code:   -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetHeartbeat(HeartbeatConfig{Interval: 20 * time.Millisecond, Timeout: 60 * time.Millisecond})
+			reasons := make(chan error, 1)
+			server.OnDisconnect(func(socket *Socket, reason error) { reasons <- reason })
+			connected := make(chan struct{})
+			server.OnConnection(func(*Socket) { close(connected) })
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			<-connected
+			tt.disconnect(conn, server)
+
+			select {
+			case reason := <-reasons:
+				if tt.reason != nil && !errors.Is(reason, tt.reason) {
+					t.Errorf("motivo = %v, esperado %v", reason, tt.reason)
+				}
+				if code, _ := CloseStatus(reason); code != tt.code {
+					t.Errorf("código = %d, esperado %d (%v)", code, tt.code, reason)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("OnDisconnect não foi chamado")
+			}
+		})
+	}
+}
+
+func TestServerOnError(t *testing.T) {
+	failure := errors.New("falhou")
+	server := NewServer()
+	errs := make(chan error, 1)
+	server.OnError(func(socket *Socket, err error) { errs <- err })
+	server.OnWithAck("chat", func(proto.Message, *Socket) (proto.Message, error) {
+		return nil, failure
+	})
+	client, _ := socketPair(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "oi"}); err == nil {
+		t.Fatal("erro do handler não voltou no ack")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, failure) {
+			t.Errorf("erro = %v, esperado %v", err, failure)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnError não foi chamado")
+	}
+}
@@ -1,98 +1,547 @@
 package protosocket
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 )
 
+// NackEvent é o evento reservado com que o receptor pede o reenvio das
+// sequências que não chegaram.
+const NackEvent = "$nack"
+
+// FeatureSequencing indica, no hello, que a ponta guarda os envelopes enviados
+// e os reenvia quando recebe um NACK.
+const FeatureSequencing = "sequencing"
+
+var (
+	ErrSequenceGap  = errors.New("sequências perdidas")
+	ErrLateSequence = errors.New("sequência entregue fora de ordem depois de pulada")
+)
+
+// SequencerConfig controla a entrega em ordem dos envelopes recebidos pelo
+// Client e o buffer de reenvio dos envelopes enviados.
+type SequencerConfig struct {
+	// MaxBuffer limita, por remetente, os envelopes guardados à espera de uma
+	// lacuna; além dele a lacuna é pulada.
+	MaxBuffer int
+	// MaxWait é quanto uma lacuna espera pelo reenvio antes de ser pulada.
+	MaxWait time.Duration
+	// NackDelay é quanto uma lacuna espera antes do NACK, para que envelopes
+	// apenas trocados de ordem no envio não provoquem reenvios.
+	NackDelay time.Duration
+	// RetransmitBuffer é quantos envelopes enviados ficam guardados para
+	// atender NACKs; zero desativa o reenvio.
+	RetransmitBuffer int
+}
+
+// DefaultSequencerConfig espera até 5s por uma lacuna, pede o reenvio depois
+// de 100ms e guarda os últimos 1024 envelopes enviados.
+func DefaultSequencerConfig() SequencerConfig {
+	return SequencerConfig{
+		MaxBuffer:        1000,
+		MaxWait:          5 * time.Second,
+		NackDelay:        100 * time.Millisecond,
+		RetransmitBuffer: 1024,
+	}
+}
+
+// skippedLimit é quantas sequências puladas são lembradas por remetente
+// quando MaxBuffer não tem limite.
+const skippedLimit = 1024
+
+// MessageSequencer entrega os envelopes de cada remetente na ordem da
+// sequência. Envelopes adiantados esperam no buffer, as sequências que faltam
+// são pedidas com NACK e, depois de maxWaitTime, a lacuna é pulada. Um
+// envelope que chega depois de pulado ainda é entregue, fora de ordem.
+//
+// Os handlers nunca rodam com o lock: as mensagens prontas vão para ready e
+// são entregues por flush, uma chamada por vez.
 type MessageSequencer struct {
 	lastSeq     map[string]uint64 // Por remetente
-	buffer      map[string][]SequencedMessage
+	buffer      map[string][]*Message
+	nacked      map[string]uint64   // Maior sequência já pedida por remetente
+	nacking     map[string]bool     // Remetentes com NACK agendado
+	timers      map[string]uint64   // Geração do timer ativo por remetente
+	skipped     map[string][]uint64 // Sequências puladas, em ordem, por remetente
+	base        uint64              // Sequência anterior à primeira, vinda do hello
+	generation  uint64
+	ready       []*Message
+	gaps        []error
 	lock        sync.RWMutex
+	delivering  sync.Mutex
 	maxBuffer   int
 	maxWaitTime time.Duration
+	nackDelay   time.Duration
+
+	deliver func(*Message)
+	nack    func([]uint64)
+	onGap   func(error)
 }
 
 func NewMessageSequencer() *MessageSequencer {
+	config := DefaultSequencerConfig()
 	return &MessageSequencer{
 		lastSeq:     make(map[string]uint64),
-		buffer:      make(map[string][]SequencedMessage),
-		maxBuffer:   1000,
-		maxWaitTime: 5 * time.Second,
+		buffer:      make(map[string][]*Message),
+		nacked:      make(map[string]uint64),
+		nacking:     make(map[string]bool),
+		timers:      make(map[string]uint64),
+		skipped:     make(map[string][]uint64),
+		maxBuffer:   config.MaxBuffer,
+		maxWaitTime: config.MaxWait,
+		nackDelay:   config.NackDelay,
 	}
 }
 
-// EmitSequenced envia uma mensagem com garantia de ordem
-func (c *Client) EmitSequenced(event string, msg proto.Message) error {
-	data, err := proto.Marshal(msg)
-	if err != nil {
-		return err
+func (s *MessageSequencer) setConfig(config SequencerConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxBuffer = config.MaxBuffer
+	s.maxWaitTime = config.MaxWait
+	s.nackDelay = config.NackDelay
+}
+
+// reset descarta o estado de uma conexão anterior. base é a última sequência
+// enviada pelo outro lado antes desta conexão.
+func (s *MessageSequencer) reset(base uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	clear(s.lastSeq)
+	clear(s.buffer)
+	clear(s.nacked)
+	clear(s.nacking)
+	clear(s.timers)
+	clear(s.skipped)
+	s.base = base
+}
+
+func (s *MessageSequencer) last(senderID string) uint64 {
+	if seq, ok := s.lastSeq[senderID]; ok {
+		return seq
 	}
+	return s.base
+}
+
+// process entrega o envelope se ele for o próximo do remetente ou o guarda até
+// a lacuna ser preenchida. Duplicatas são descartadas.
+func (s *MessageSequencer) process(msg *Message) {
+	s.enqueue(msg)
+	s.flush()
+}
 
-	wrapper := &SequencedMessage{
-		Event:     event,
-		Data:      data,
-		Sequence:  atomic.AddUint64(&c.sequence, 1),
-		Timestamp: time.Now().Unix(),
-		SenderId:  c.ID,
+func (s *MessageSequencer) enqueue(msg *Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	senderID := msg.SenderId
+	lastSeq := s.last(senderID)
+	if msg.Sequence <= lastSeq {
+		if s.takeSkipped(senderID, msg.Sequence) {
+			s.ready = append(s.ready, msg)
+			s.gaps = append(s.gaps, fmt.Errorf("%w: %d de '%s'", ErrLateSequence, msg.Sequence, senderID))
+		}
+		return
 	}
 
-	wrapperData, err := proto.Marshal(wrapper)
-	if err != nil {
-		return err
+	// Se é a próxima mensagem esperada
+	if msg.Sequence == lastSeq+1 && len(s.buffer[senderID]) == 0 {
+		s.lastSeq[senderID] = msg.Sequence
+		s.ready = append(s.ready, msg)
+		return
+	}
+
+	// Guarda no buffer em ordem, ignorando repetidas
+	messages := s.buffer[senderID]
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].Sequence >= msg.Sequence
+	})
+	if i < len(messages) && messages[i].Sequence == msg.Sequence {
+		return
+	}
+	s.buffer[senderID] = append(messages[:i], append([]*Message{msg}, messages[i:]...)...)
+
+	s.tryDeliverBuffered(senderID)
+	for s.maxBuffer > 0 && len(s.buffer[senderID]) > s.maxBuffer {
+		s.skipGap(senderID)
 	}
+	s.wait(senderID)
+}
 
-	return c.conn.WriteMessage(websocket.BinaryMessage, wrapperData)
+// pass entrega um envelope sem sequência, na vez dele entre os já prontos.
+func (s *MessageSequencer) pass(msg *Message) {
+	s.lock.Lock()
+	s.ready = append(s.ready, msg)
+	s.lock.Unlock()
+	s.flush()
 }
 
-func (c *Client) tryDeliverBuffered(senderID string) {
-	messages := c.sequencer.buffer[senderID]
-	lastSeq := c.sequencer.lastSeq[senderID]
+// flush entrega as mensagens prontas e avisa das lacunas puladas, fora do
+// lock e na ordem em que ficaram prontas.
+func (s *MessageSequencer) flush() {
+	s.delivering.Lock()
+	defer s.delivering.Unlock()
+	for {
+		s.lock.Lock()
+		ready, gaps := s.ready, s.gaps
+		s.ready, s.gaps = nil, nil
+		s.lock.Unlock()
+		if len(ready) == 0 && len(gaps) == 0 {
+			return
+		}
 
-	// Ordena mensagens por sequência
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Sequence < messages[j].Sequence
-	})
+		if s.onGap != nil {
+			for _, err := range gaps {
+				s.onGap(err)
+			}
+		}
+		for _, msg := range ready {
+			s.deliver(msg)
+		}
+	}
+}
 
-	// Entrega mensagens em ordem
-	for i, msg := range messages {
-		if msg.Sequence == lastSeq+1 {
-			c.deliverMessage(&messages[i])
-			c.sequencer.lastSeq[senderID] = msg.Sequence
-			lastSeq = msg.Sequence
-		} else {
+// tryDeliverBuffered libera as mensagens consecutivas do início do buffer e
+// mantém as demais. Deve ser chamado com o lock.
+func (s *MessageSequencer) tryDeliverBuffered(senderID string) {
+	messages := s.buffer[senderID]
+	lastSeq := s.last(senderID)
+
+	delivered := 0
+	for _, msg := range messages {
+		if msg.Sequence != lastSeq+1 {
 			break
 		}
+		s.ready = append(s.ready, msg)
+		lastSeq = msg.Sequence
+		delivered++
+	}
+	s.lastSeq[senderID] = lastSeq
+
+	// Remove apenas as mensagens entregues do buffer
+	if delivered == len(messages) {
+		delete(s.buffer, senderID)
+	} else {
+		s.buffer[senderID] = messages[delivered:]
+	}
+}
+
+// skipGap desiste das sequências que faltam antes da primeira do buffer e as
+// lembra para entregar as que ainda chegarem. Deve ser chamado com o lock.
+func (s *MessageSequencer) skipGap(senderID string) {
+	messages := s.buffer[senderID]
+	if len(messages) == 0 {
+		return
+	}
+	first := s.last(senderID) + 1
+	next := messages[0].Sequence
+	s.lastSeq[senderID] = next - 1
+	delete(s.timers, senderID)
+
+	limit := uint64(skippedLimit)
+	if s.maxBuffer > 0 {
+		limit = uint64(s.maxBuffer)
+	}
+	skipped := s.skipped[senderID]
+	for seq := max(first, next-min(next-first, limit)); seq < next; seq++ {
+		skipped = append(skipped, seq)
+	}
+	if uint64(len(skipped)) > limit {
+		skipped = skipped[uint64(len(skipped))-limit:]
+	}
+	s.skipped[senderID] = skipped
+
+	s.gaps = append(s.gaps, fmt.Errorf("%w: %d a %d de '%s'", ErrSequenceGap, first, next-1, senderID))
+	s.tryDeliverBuffered(senderID)
+}
+
+// takeSkipped indica se a sequência foi pulada e a esquece, para que uma
+// cópia repetida não seja entregue de novo. Deve ser chamado com o lock.
+func (s *MessageSequencer) takeSkipped(senderID string, seq uint64) bool {
+	skipped := s.skipped[senderID]
+	i := sort.Search(len(skipped), func(i int) bool { return skipped[i] >= seq })
+	if i == len(skipped) || skipped[i] != seq {
+		return false
+	}
+	s.skipped[senderID] = append(skipped[:i], skipped[i+1:]...)
+	return true
+}
+
+// wait agenda o NACK das sequências que faltam e, na primeira vez que a lacuna
+// aparece, o prazo para pulá-la. Deve ser chamado com o lock.
+func (s *MessageSequencer) wait(senderID string) {
+	if len(s.buffer[senderID]) == 0 {
+		delete(s.timers, senderID)
+		return
+	}
+
+	if !s.nacking[senderID] {
+		s.nacking[senderID] = true
+		time.AfterFunc(s.nackDelay, func() {
+			s.requestMissing(senderID)
+		})
+	}
+
+	if _, ok := s.timers[senderID]; ok {
+		return
+	}
+	s.generation++
+	generation := s.generation
+	s.timers[senderID] = generation
+	time.AfterFunc(s.maxWaitTime, func() {
+		s.expire(senderID, generation)
+	})
+}
+
+// requestMissing pede as sequências que continuam faltando depois de nackDelay.
+func (s *MessageSequencer) requestMissing(senderID string) {
+	s.lock.Lock()
+	delete(s.nacking, senderID)
+	missing := s.missing(senderID)
+	s.lock.Unlock()
+
+	if len(missing) > 0 && s.nack != nil {
+		s.nack(missing)
+	}
+}
+
+// missing lista as lacunas do buffer ainda não pedidas, até maxBuffer delas.
+// Deve ser chamado com o lock.
+func (s *MessageSequencer) missing(senderID string) []uint64 {
+	messages := s.buffer[senderID]
+	if len(messages) == 0 {
+		return nil
+	}
+
+	from := max(s.last(senderID), s.nacked[senderID]) + 1
+	until := messages[len(messages)-1].Sequence
+	var missing []uint64
+	seq, i := from, 0
+	for ; seq < until && (s.maxBuffer <= 0 || len(missing) < s.maxBuffer); seq++ {
+		for i < len(messages) && messages[i].Sequence < seq {
+			i++
+		}
+		if i < len(messages) && messages[i].Sequence == seq {
+			continue
+		}
+		missing = append(missing, seq)
 	}
+	if seq > from {
+		s.nacked[senderID] = seq - 1
+	}
+	return missing
+}
+
+// expire pula a lacuna que não foi preenchida dentro de maxWaitTime.
+func (s *MessageSequencer) expire(senderID string, generation uint64) {
+	s.lock.Lock()
+	if s.timers[senderID] != generation {
+		s.lock.Unlock()
+		return
+	}
+	s.skipGap(senderID)
+	s.wait(senderID)
+	s.lock.Unlock()
+
+	s.flush()
+}
+
+// retransmitBuffer guarda cópias dos últimos envelopes enviados, pela
+// sequência, para atender NACKs.
+type retransmitBuffer struct {
+	lock     sync.Mutex
+	size     int
+	messages map[uint64]*Message
+	order    []uint64
+}
+
+func newRetransmitBuffer(size int) *retransmitBuffer {
+	return &retransmitBuffer{
+		size:     size,
+		messages: make(map[uint64]*Message),
+	}
+}
+
+func (r *retransmitBuffer) setSize(size int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.size = size
+	r.evict()
+}
+
+// store guarda uma cópia do envelope antes da compressão. Respostas e
+// envelopes sem sequência não são guardados.
+func (r *retransmitBuffer) store(msg *Message) {
+	if msg.Sequence == 0 || msg.IsReply {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.size <= 0 {
+		return
+	}
+	if _, ok := r.messages[msg.Sequence]; ok {
+		return
+	}
+	r.messages[msg.Sequence] = proto.Clone(msg).(*Message)
+	r.order = append(r.order, msg.Sequence)
+	r.evict()
+}
+
+// evict descarta os envelopes mais antigos além do tamanho. Deve ser chamado com o lock.
+func (r *retransmitBuffer) evict() {
+	for len(r.order) > max(r.size, 0) {
+		delete(r.messages, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// get devolve cópias dos envelopes pedidos que ainda estão guardados.
+func (r *retransmitBuffer) get(sequences []uint64) []*Message {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var messages []*Message
+	for _, seq := range sequences {
+		if msg, ok := r.messages[seq]; ok {
+			messages = append(messages, proto.Clone(msg).(*Message))
+		}
+	}
+	return messages
+}
+
+// EmitSequenced envia uma mensagem com garantia de ordem
+func (c *Client) EmitSequenced(event string, msg proto.Message) error {
+	// Todo envelope já carrega a sequência do remetente
+	return c.Emit(event, msg)
+}
+
+// SetSequencing define os limites da entrega em ordem e do buffer de reenvio.
+func (c *Client) SetSequencing(config SequencerConfig) {
+	c.sequencer.setConfig(config)
+	c.retransmit.setSize(config.RetransmitBuffer)
+}
+
+// SetSequencing define o buffer de reenvio usado para atender os NACKs do cliente.
+func (s *Socket) SetSequencing(config SequencerConfig) {
+	s.retransmit.setSize(config.RetransmitBuffer)
+}
 
-	// Remove mensagens entregues do buffer
-	c.sequencer.buffer[senderID] = messages[len(messages):]
+// SetSequencing define o buffer de reenvio das próximas conexões.
+func (s *Server) SetSequencing(config SequencerConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sequencing = config
 }
 
-func (c *Client) deliverMessage(msg *SequencedMessage) error {
-	var protoMsg proto.Message
-	switch msg.Event {
-	case "chat":
-		protoMsg = &ChatMessage{}
-	case "binary":
-		protoMsg = &BinaryMessage{}
-	default:
-		return fmt.Errorf("evento não suportado: %s", msg.Event)
+// processMessageInternal entrega o envelope em ordem quando o servidor atende
+// NACKs; com servidores legados a entrega segue a ordem de chegada.
+// Todos os envelopes passam pelo sequenciador para que os handlers rodem um
+// de cada vez, mesmo quando uma lacuna expira fora da leitura.
+func (c *Client) processMessageInternal(msg *Message) {
+	if msg.Sequence == 0 || !c.HasRemoteFeature(FeatureSequencing) {
+		c.sequencer.pass(msg)
+		return
 	}
+	c.sequencer.process(msg)
+}
 
-	if err := proto.Unmarshal(msg.Data, protoMsg); err != nil {
-		return err
+// deliverMessage decodifica o payload e chama o handler do evento, respondendo
+// ao ack quando pedido.
+func (c *Client) deliverMessage(wrapper *Message) {
+	if c.dropExpired(wrapper) {
+		if wrapper.AckId != "" {
+			c.reply(wrapper.AckId, nil, ErrMessageExpired)
+		}
+		return
 	}
 
-	if handler, exists := c.handlers[msg.Event]; exists {
-		handler(protoMsg, c)
+	handler, ok := c.handlers[wrapper.Event]
+	ackHandler, ackOk := c.ackHandlers[wrapper.Event]
+	if !ok && !ackOk {
+		if wrapper.AckId != "" {
+			c.reply(wrapper.AckId, nil, ErrNoHandler)
+		}
+		return
 	}
 
-	return nil
+	payload, err := DefaultRegistry.Decode(wrapper.Event, wrapper.DataType, wrapper.Data)
+	if err != nil {
+		c.reportError(fmt.Errorf("erro ao decodificar payload: %w", err))
+		if wrapper.AckId != "" {
+			c.reply(wrapper.AckId, nil, err)
+		}
+		return
+	}
+
+	c.lock.Lock()
+	middlewares := c.middlewares
+	c.lock.Unlock()
+
+	// O handler final guarda a resposta para que ela volte pelo ack
+	var resp proto.Message
+	final := func(ctx context.Context, msg proto.Message) error {
+		if ackOk && (wrapper.AckId != "" || !ok) {
+			var err error
+			resp, err = ackHandler(msg, c)
+			return err
+		}
+		handler(msg, c)
+		return nil
+	}
+
+	ctx := context.WithValue(c.ctx, clientContextKey, c)
+	ctx = context.WithValue(ctx, metadataContextKey, wrapper.metadata())
+
+	err = chain(middlewares, final)(ctx, payload)
+	if err != nil {
+		c.reportError(fmt.Errorf("erro no handler de '%s': %w", wrapper.Event, err))
+	}
+	// Handlers sem resposta confirmam apenas o processamento
+	if wrapper.AckId != "" {
+		c.reply(wrapper.AckId, resp, err)
+	}
+}
+
+// sendNack pede ao servidor o reenvio das sequências que faltam.
+func (c *Client) sendNack(sequences []uint64) {
+	nack, err := newEnvelope(NackEvent, &Nack{Sequences: sequences}, c.ID, 0)
+	if err == nil {
+		err = c.write(nack)
+	}
+	if err != nil {
+		c.reportError(fmt.Errorf("erro ao enviar nack: %w", err))
+	}
+}
+
+// handleNack reenvia os envelopes pedidos que ainda estão no buffer de reenvio.
+func (c *Client) handleNack(msg *Message) {
+	var nack Nack
+	if err := proto.Unmarshal(msg.Data, &nack); err != nil {
+		c.reportError(fmt.Errorf("nack inválido: %w", err))
+		return
+	}
+	for _, envelope := range c.retransmit.get(nack.Sequences) {
+		if err := c.send(envelope); err != nil && !errors.Is(err, ErrMessageExpired) {
+			c.reportError(fmt.Errorf("erro ao reenviar sequência %d: %w", envelope.Sequence, err))
+		}
+	}
+}
+
+// handleNack reenvia os envelopes pedidos que ainda estão no buffer de reenvio.
+func (s *Socket) handleNack(msg *Message) {
+	var nack Nack
+	if err := proto.Unmarshal(msg.Data, &nack); err != nil {
+		s.reportError(fmt.Errorf("nack inválido: %w", err))
+		return
+	}
+	for _, envelope := range s.retransmit.get(nack.Sequences) {
+		if err := s.send(envelope); err != nil && !errors.Is(err, ErrMessageExpired) {
+			s.reportError(fmt.Errorf("erro ao reenviar sequência %d: %w", envelope.Sequence, err))
+		}
+	}
 }
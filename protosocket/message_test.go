@@ -0,0 +1,316 @@
+package protosocket
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequencerRecorder guarda o que o MessageSequencer entregou, pediu e pulou.
+type sequencerRecorder struct {
+	lock      sync.Mutex
+	delivered []uint64
+	nacks     [][]uint64
+	gaps      []error
+	changed   chan struct{}
+}
+
+func newTestSequencer(config SequencerConfig) (*MessageSequencer, *sequencerRecorder) {
+	rec := &sequencerRecorder{changed: make(chan struct{}, 64)}
+	seq := NewMessageSequencer()
+	seq.setConfig(config)
+	seq.deliver = func(msg *Message) {
+		rec.lock.Lock()
+		rec.delivered = append(rec.delivered, msg.Sequence)
+		rec.lock.Unlock()
+		rec.notify()
+	}
+	seq.nack = func(sequences []uint64) {
+		rec.lock.Lock()
+		rec.nacks = append(rec.nacks, sequences)
+		rec.lock.Unlock()
+		rec.notify()
+	}
+	seq.onGap = func(err error) {
+		rec.lock.Lock()
+		rec.gaps = append(rec.gaps, err)
+		rec.lock.Unlock()
+		rec.notify()
+	}
+	return seq, rec
+}
+
+func (r *sequencerRecorder) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor espera até que cond seja verdadeira ou o prazo acabe.
+func (r *sequencerRecorder) waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		r.lock.Lock()
+		ok := cond()
+		r.lock.Unlock()
+		if ok {
+			return
+		}
+		select {
+		case <-r.changed:
+		case <-deadline:
+			t.Fatalf("condição não atingida em %v: entregues=%v nacks=%v gaps=%v", timeout, r.delivered, r.nacks, r.gaps)
+		}
+	}
+}
+
+func envelopeSeq(sender string, seq uint64) *Message {
+	return &Message{Event: "test", SenderId: sender, Sequence: seq}
+}
+
+func TestMessageSequencerOrder(t *testing.T) {
+	// Prazos longos: só a ordem de chegada decide a entrega.
+	config := SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: time.Hour}
+
+	tests := []struct {
+		name      string
+		config    SequencerConfig
+		arrivals  []uint64
+		delivered []uint64
+		gaps      int
+	}{
+		{"em ordem", config, []uint64{1, 2, 3}, []uint64{1, 2, 3}, 0},
+		{"trocados", config, []uint64{2, 1, 3}, []uint64{1, 2, 3}, 0},
+		{"lacuna preenchida", config, []uint64{1, 3, 4, 2}, []uint64{1, 2, 3, 4}, 0},
+		{"invertidos", config, []uint64{4, 3, 2, 1}, []uint64{1, 2, 3, 4}, 0},
+		{"duplicata entregue", config, []uint64{1, 1, 2, 2}, []uint64{1, 2}, 0},
+		{"duplicata no buffer", config, []uint64{1, 3, 3, 2}, []uint64{1, 2, 3}, 0},
+		{"lacuna aberta", config, []uint64{1, 3, 4}, []uint64{1}, 0},
+		{
+			name:      "buffer cheio pula a lacuna",
+			config:    SequencerConfig{MaxBuffer: 2, MaxWait: time.Hour, NackDelay: time.Hour},
+			arrivals:  []uint64{1, 3, 4, 5},
+			delivered: []uint64{1, 3, 4, 5},
+			gaps:      1,
+		},
+		{
+			name:      "atrasada depois de pulada",
+			config:    SequencerConfig{MaxBuffer: 2, MaxWait: time.Hour, NackDelay: time.Hour},
+			arrivals:  []uint64{1, 3, 4, 5, 2, 2},
+			delivered: []uint64{1, 3, 4, 5, 2},
+			gaps:      2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, rec := newTestSequencer(tt.config)
+			for _, n := range tt.arrivals {
+				seq.process(envelopeSeq("a", n))
+			}
+
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			if !slices.Equal(rec.delivered, tt.delivered) {
+				t.Errorf("entregues = %v, esperado %v", rec.delivered, tt.delivered)
+			}
+			if len(rec.gaps) != tt.gaps {
+				t.Errorf("lacunas = %v, esperado %d", rec.gaps, tt.gaps)
+			}
+		})
+	}
+}
+
+func TestMessageSequencerSenders(t *testing.T) {
+	seq, rec := newTestSequencer(SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: time.Hour})
+	seq.process(envelopeSeq("a", 2))
+	seq.process(envelopeSeq("b", 1))
+	seq.process(envelopeSeq("a", 1))
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if want := []uint64{1, 1, 2}; !slices.Equal(rec.delivered, want) {
+		t.Errorf("entregues = %v, esperado %v", rec.delivered, want)
+	}
+}
+
+func TestMessageSequencerBase(t *testing.T) {
+	seq, rec := newTestSequencer(SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: time.Hour})
+	seq.reset(10)
+	for _, n := range []uint64{9, 10, 12, 11} {
+		seq.process(envelopeSeq("a", n))
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if want := []uint64{11, 12}; !slices.Equal(rec.delivered, want) {
+		t.Errorf("entregues = %v, esperado %v", rec.delivered, want)
+	}
+}
+
+func TestMessageSequencerTimers(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    SequencerConfig
+		arrivals  []uint64
+		nacks     [][]uint64
+		delivered []uint64
+		gaps      []error
+	}{
+		{
+			name:      "nack das sequências que faltam",
+			config:    SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: 10 * time.Millisecond},
+			arrivals:  []uint64{1, 3, 5},
+			nacks:     [][]uint64{{2, 4}},
+			delivered: []uint64{1},
+		},
+		{
+			name:      "nack limitado a maxBuffer",
+			config:    SequencerConfig{MaxBuffer: 3, MaxWait: time.Hour, NackDelay: 10 * time.Millisecond},
+			arrivals:  []uint64{1, 10},
+			nacks:     [][]uint64{{2, 3, 4}},
+			delivered: []uint64{1},
+		},
+		{
+			name:      "lacuna expirada é pulada",
+			config:    SequencerConfig{MaxBuffer: 1000, MaxWait: 20 * time.Millisecond, NackDelay: time.Hour},
+			arrivals:  []uint64{1, 3, 4},
+			delivered: []uint64{1, 3, 4},
+			gaps:      []error{ErrSequenceGap},
+		},
+		{
+			name:      "lacunas expiradas em sequência",
+			config:    SequencerConfig{MaxBuffer: 1000, MaxWait: 20 * time.Millisecond, NackDelay: time.Hour},
+			arrivals:  []uint64{2, 4},
+			delivered: []uint64{2, 4},
+			gaps:      []error{ErrSequenceGap, ErrSequenceGap},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, rec := newTestSequencer(tt.config)
+			for _, n := range tt.arrivals {
+				seq.process(envelopeSeq("a", n))
+			}
+
+			rec.waitFor(t, time.Second, func() bool {
+				return len(rec.nacks) >= len(tt.nacks) && len(rec.delivered) >= len(tt.delivered) && len(rec.gaps) >= len(tt.gaps)
+			})
+
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			if !slices.EqualFunc(rec.nacks, tt.nacks, slices.Equal) {
+				t.Errorf("nacks = %v, esperado %v", rec.nacks, tt.nacks)
+			}
+			if !slices.Equal(rec.delivered, tt.delivered) {
+				t.Errorf("entregues = %v, esperado %v", rec.delivered, tt.delivered)
+			}
+			if len(rec.gaps) != len(tt.gaps) {
+				t.Fatalf("lacunas = %v, esperado %v", rec.gaps, tt.gaps)
+			}
+			for i, err := range tt.gaps {
+				if !errors.Is(rec.gaps[i], err) {
+					t.Errorf("lacuna %d = %v, esperado %v", i, rec.gaps[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageSequencerNoNackWhenFilled(t *testing.T) {
+	seq, rec := newTestSequencer(SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: 20 * time.Millisecond})
+	seq.process(envelopeSeq("a", 2))
+	seq.process(envelopeSeq("a", 1))
+	time.Sleep(60 * time.Millisecond)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if len(rec.nacks) != 0 {
+		t.Errorf("nacks = %v, esperado nenhum para envelopes apenas trocados", rec.nacks)
+	}
+}
+
+func TestMessageSequencerLateAfterTimeout(t *testing.T) {
+	seq, rec := newTestSequencer(SequencerConfig{MaxBuffer: 1000, MaxWait: 20 * time.Millisecond, NackDelay: time.Hour})
+	seq.process(envelopeSeq("a", 1))
+	seq.process(envelopeSeq("a", 3))
+	rec.waitFor(t, time.Second, func() bool { return len(rec.delivered) == 2 })
+
+	seq.process(envelopeSeq("a", 2))
+	seq.process(envelopeSeq("a", 2))
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if want := []uint64{1, 3, 2}; !slices.Equal(rec.delivered, want) {
+		t.Errorf("entregues = %v, esperado %v", rec.delivered, want)
+	}
+	if len(rec.gaps) != 2 || !errors.Is(rec.gaps[0], ErrSequenceGap) || !errors.Is(rec.gaps[1], ErrLateSequence) {
+		t.Errorf("lacunas = %v, esperado pulo e depois atraso", rec.gaps)
+	}
+}
+
+func TestMessageSequencerHandlerWithoutLock(t *testing.T) {
+	seq, rec := newTestSequencer(SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: time.Hour})
+	deliver := seq.deliver
+	seq.deliver = func(msg *Message) {
+		// Um handler que mexe na configuração não pode travar a entrega.
+		seq.setConfig(SequencerConfig{MaxBuffer: 1000, MaxWait: time.Hour, NackDelay: time.Hour})
+		deliver(msg)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		seq.process(envelopeSeq("a", 2))
+		seq.process(envelopeSeq("a", 1))
+		seq.pass(&Message{Event: "test", SenderId: "a"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler rodou com o lock do sequenciador")
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if want := []uint64{1, 2, 0}; !slices.Equal(rec.delivered, want) {
+		t.Errorf("entregues = %v, esperado %v", rec.delivered, want)
+	}
+}
+
+func TestRetransmitBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		stored []*Message
+		asked  []uint64
+		got    []uint64
+	}{
+		{"guarda e devolve", 4, []*Message{envelopeSeq("a", 1), envelopeSeq("a", 2)}, []uint64{1, 2, 3}, []uint64{1, 2}},
+		{"descarta os antigos", 2, []*Message{envelopeSeq("a", 1), envelopeSeq("a", 2), envelopeSeq("a", 3)}, []uint64{1, 2, 3}, []uint64{2, 3}},
+		{"ignora respostas", 4, []*Message{{Sequence: 1, IsReply: true}}, []uint64{1}, nil},
+		{"ignora sem sequência", 4, []*Message{{Event: "test"}}, []uint64{0}, nil},
+		{"desativado", 0, []*Message{envelopeSeq("a", 1)}, []uint64{1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := newRetransmitBuffer(tt.size)
+			for _, msg := range tt.stored {
+				buffer.store(msg)
+			}
+			var got []uint64
+			for _, msg := range buffer.get(tt.asked) {
+				got = append(got, msg.Sequence)
+			}
+			if !slices.Equal(got, tt.got) {
+				t.Errorf("devolvidos = %v, esperado %v", got, tt.got)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ type MetricsCollector struct {
 	messagesReceived uint64
 	bytesTransferred uint64
 	errors           uint64
+	expired          uint64
 	latencies        []time.Duration
 }
 
@@ -2,12 +2,66 @@ package protosocket
 
 import (
 	"context"
-	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+type contextKey string
+
+const (
+	socketContextKey   contextKey = "socket"
+	clientContextKey   contextKey = "client"
+	metadataContextKey contextKey = "metadata"
+)
+
+// MessageMetadata descreve o envelope da mensagem em processamento.
+type MessageMetadata struct {
+	Event     string
+	AckID     string
+	DataType  string
+	SenderID  string
+	Sequence  uint64
+	MessageID string
+	Timestamp time.Time
+	Headers   map[string]string
+	Version   uint32
+	Priority  Priority
+}
+
+// SocketFromContext retorna o Socket que recebeu a mensagem, se houver.
+func SocketFromContext(ctx context.Context) *Socket {
+	socket, _ := ctx.Value(socketContextKey).(*Socket)
+	return socket
+}
+
+// ClientFromContext retorna o Client que recebeu a mensagem, se houver.
+func ClientFromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(clientContextKey).(*Client)
+	return client
+}
+
+// MetadataFromContext retorna os dados do envelope da mensagem em processamento.
+func MetadataFromContext(ctx context.Context) MessageMetadata {
+	meta, _ := ctx.Value(metadataContextKey).(MessageMetadata)
+	return meta
+}
+
+// EventFromContext retorna o nome do evento em processamento.
+func EventFromContext(ctx context.Context) string {
+	return MetadataFromContext(ctx).Event
+}
+
+// chain aplica os middlewares em ordem, de forma que o primeiro seja o mais externo.
+func chain(middlewares []Middleware, final HandlerFunc) HandlerFunc {
+	h := final
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
 type SocketMiddleware struct {
 	middlewares []Middleware
 	socket      *Socket
@@ -21,6 +75,14 @@ func NewMiddleware(socket *Socket) *SocketMiddleware {
 
 func (m *SocketMiddleware) Use(middleware ...Middleware) {
 	m.middlewares = append(m.middlewares, middleware...)
+	if m.socket != nil {
+		m.socket.Use(middleware...)
+	}
+}
+
+// Then monta a cadeia de middlewares em volta do handler final.
+func (m *SocketMiddleware) Then(final HandlerFunc) HandlerFunc {
+	return chain(m.middlewares, final)
 }
 
 // Exemplo de middleware de logging
@@ -30,14 +92,15 @@ func LoggingMiddleware() Middleware {
 			logger := GetLogger()
 
 			logger.Info("processando mensagem",
-				zap.String("type", fmt.Sprintf("%T", msg)),
-				zap.Any("context", ctx))
+				zap.String("event", EventFromContext(ctx)),
+				zap.String("type", dataTypeOf(msg)))
 
 			err := next(ctx, msg)
 			if err != nil {
 				logger.Error("erro no processamento",
 					zap.Error(err),
-					zap.String("type", fmt.Sprintf("%T", msg)))
+					zap.String("event", EventFromContext(ctx)),
+					zap.String("type", dataTypeOf(msg)))
 			}
 
 			return err
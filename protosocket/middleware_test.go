@@ -0,0 +1,127 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// orderRecorder registra a ordem em que middlewares e handler rodam.
+type orderRecorder struct {
+	lock  sync.Mutex
+	steps []string
+}
+
+func (r *orderRecorder) add(step string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+func (r *orderRecorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	steps := r.steps
+	r.steps = nil
+	return steps
+}
+
+func (r *orderRecorder) middleware(name string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg proto.Message) error {
+			r.add(name + ":antes")
+			err := next(ctx, msg)
+			r.add(name + ":depois")
+			return err
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var r orderRecorder
+	handle := chain([]Middleware{r.middleware("a"), r.middleware("b")}, func(context.Context, proto.Message) error {
+		r.add("handler")
+		return nil
+	})
+	if err := handle(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a:antes", "b:antes", "handler", "b:depois", "a:depois"}
+	if got := r.take(); !slices.Equal(got, want) {
+		t.Errorf("ordem = %v, esperado %v", got, want)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var r orderRecorder
+	denied := errors.New("negado")
+	server := NewServer()
+	server.Use(r.middleware("servidor1"), r.middleware("servidor2"))
+	server.OnConnection(func(socket *Socket) {
+		socket.Use(r.middleware("socket"), func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg proto.Message) error {
+				// Um middleware pode interromper a cadeia antes do handler
+				if msg.(*ChatMessage).Content == "bloquear" {
+					return denied
+				}
+				if SocketFromContext(ctx) != socket || EventFromContext(ctx) != "chat" {
+					return errors.New("contexto sem socket ou evento")
+				}
+				return next(ctx, msg)
+			}
+		})
+	})
+	server.OnWithAck("chat", func(data proto.Message, socket *Socket) (proto.Message, error) {
+		r.add("handler")
+		return &ChatMessage{Content: "ok"}, nil
+	})
+	client, _ := socketPair(t, server)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+		steps   []string
+	}{
+		{
+			name:    "servidor antes do socket",
+			content: "oi",
+			steps: []string{
+				"servidor1:antes", "servidor2:antes", "socket:antes", "handler",
+				"socket:depois", "servidor2:depois", "servidor1:depois",
+			},
+		},
+		{
+			name:    "cadeia interrompida",
+			content: "bloquear",
+			err:     denied.Error(),
+			steps: []string{
+				"servidor1:antes", "servidor2:antes", "socket:antes",
+				"socket:depois", "servidor2:depois", "servidor1:depois",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: tt.content})
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			var remoteErr *RemoteError
+			if tt.err != "" && (!errors.As(err, &remoteErr) || remoteErr.Message != tt.err) {
+				t.Fatalf("erro = %v, esperado %q", err, tt.err)
+			}
+			if got := r.take(); !slices.Equal(got, tt.steps) {
+				t.Errorf("ordem = %v, esperado %v", got, tt.steps)
+			}
+		})
+	}
+}
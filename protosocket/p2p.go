@@ -14,17 +14,27 @@ import (
 )
 
 type Peer struct {
-	ID          string
-	Port        int
-	ServiceInfo *ServiceInfo
-	upgrader    websocket.Upgrader
-	clients     map[string]*Client
-	lock        sync.RWMutex
-	handlers    map[string]func(proto.Message, string)
-	discovery   *ServiceDiscovery
-	telemetry   *Telemetry
-	startServer func() error
-	logger      *zap.Logger
+	ID            string
+	Port          int
+	ServiceInfo   *ServiceInfo
+	upgrader      websocket.Upgrader
+	clients       map[string]*Client
+	lock          sync.RWMutex
+	handlers      map[string]func(proto.Message, string)
+	discovery     *ServiceDiscovery
+	telemetry     *Telemetry
+	startServer   func() error
+	logger        *zap.Logger
+	heartbeat     HeartbeatConfig
+	compression   CompressionConfig
+	fragmentation FragmentConfig
+	onMissed      func(clientID string)
+	httpServer    *http.Server
+	shuttingDown  bool
+	onConnection  func(clientID string)
+	onDisconnect  func(clientID string, reason error)
+	onError       func(clientID string, err error)
+	files         *FileTransfers
 }
 
 type ServiceDiscovery struct {
@@ -52,9 +62,15 @@ func NewPeer(port int, serviceName, serviceType string) *Peer {
 		discovery: &ServiceDiscovery{
 			services: make(map[string]*ServiceInfo),
 		},
-		logger: GetLogger(),
+		logger:        GetLogger(),
+		heartbeat:     DefaultHeartbeatConfig(),
+		compression:   DefaultCompressionConfig(),
+		fragmentation: DefaultFragmentConfig(),
 	}
 
+	peer.files = NewFileTransfers(DefaultFileTransferConfig())
+	peer.files.sender = peer.ID
+
 	// Registra handlers de descoberta
 	peer.On("service.discover", peer.handleServiceDiscover)
 	peer.On("service.announce", peer.handleServiceAnnounce)
@@ -102,9 +118,73 @@ func (p *Peer) ConnectToService(service *ServiceInfo) error {
 	return fmt.Errorf("host não encontrado para o serviço %s", service.Id)
 }
 
+// SetHeartbeat define o heartbeat dos próximos links; Interval zero desativa.
+func (p *Peer) SetHeartbeat(config HeartbeatConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.heartbeat = config
+}
+
+// OnHeartbeatMissed registra um callback chamado quando um peer para de responder aos pings.
+func (p *Peer) OnHeartbeatMissed(callback func(clientID string)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onMissed = callback
+}
+
+// track registra o link e o remove do Peer quando a conexão cair.
+func (p *Peer) track(client *Client) {
+	client.OnHeartbeatMissed(func(c *Client) {
+		p.lock.RLock()
+		callback := p.onMissed
+		p.lock.RUnlock()
+		if callback != nil {
+			callback(c.ID)
+		}
+	})
+	client.OnError(func(c *Client, err error) {
+		p.reportError(c.ID, err)
+	})
+	client.HandleFileTransfers(p.files)
+	client.lock.Lock()
+	client.onClosed = func(c *Client) {
+		p.lock.Lock()
+		delete(p.clients, c.ID)
+		onDisconnect := p.onDisconnect
+		p.lock.Unlock()
+		if onDisconnect != nil {
+			onDisconnect(c.ID, c.CloseReason())
+		}
+	}
+	client.lock.Unlock()
+
+	p.lock.Lock()
+	p.clients[client.ID] = client
+	onConnection := p.onConnection
+	p.lock.Unlock()
+
+	if onConnection != nil {
+		go onConnection(client.ID)
+	}
+}
+
 // Conecta a outro peer
 func (p *Peer) Connect(addr string) error {
-	client := NewClient(fmt.Sprintf("ws://%s/ws", addr))
+	conn, _, err := newDialer().Dial(fmt.Sprintf("ws://%s/ws", addr), http.Header{})
+	if err != nil {
+		return err
+	}
+
+	p.lock.RLock()
+	heartbeat := p.heartbeat
+	compression := p.compression
+	fragmentation := p.fragmentation
+	p.lock.RUnlock()
+	opts := defaultClientOptions()
+	opts.heartbeat = heartbeat
+	client := newClient(conn, opts)
+	client.SetCompression(compression)
+	client.SetFragmentation(fragmentation)
 
 	p.logger.Info("conectando ao peer",
 		zap.String("addr", addr),
@@ -125,10 +205,7 @@ func (p *Peer) Connect(addr string) error {
 		}
 	})
 
-	p.lock.Lock()
-	p.clients[client.ID] = client
-	p.lock.Unlock()
-
+	p.track(client)
 	go client.listen()
 
 	p.logger.Info("conexão estabelecida",
@@ -146,7 +223,11 @@ func (p *Peer) On(event string, handler func(proto.Message, string)) {
 // Envia mensagem para todos os peers conectados
 func (p *Peer) Broadcast(event string, msg proto.Message) {
 	p.lock.RLock()
-	defer p.lock.RUnlock()
+	clients := make([]*Client, 0, len(p.clients))
+	for _, client := range p.clients {
+		clients = append(clients, client)
+	}
+	p.lock.RUnlock()
 
 	senderID := ""
 	if cm, ok := msg.(*ChatMessage); ok {
@@ -154,28 +235,52 @@ func (p *Peer) Broadcast(event string, msg proto.Message) {
 		event = "chat" // Força evento "chat" para mensagens de texto
 	}
 
-	for _, client := range p.clients {
+	for _, client := range clients {
 		if client.ID != senderID {
 			if err := client.Emit(event, msg); err != nil {
-				log.Printf("Erro ao enviar para peer %s: %v\n", client.ID, err)
+				p.reportError(client.ID, fmt.Errorf("erro ao enviar para peer: %w", err))
 			}
 		}
 	}
 }
 
 func (p *Peer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := p.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Erro ao fazer upgrade:", err)
+	p.lock.RLock()
+	shuttingDown := p.shuttingDown
+	p.lock.RUnlock()
+	if shuttingDown {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	client := &Client{
-		ID:       uuid.New().String()[:8],
-		conn:     conn,
-		handlers: make(map[string]func(proto.Message, *Client)),
+	protocol, protoErr := negotiateSubprotocol(websocket.Subprotocols(r))
+	var responseHeader http.Header
+	if protocol != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	conn, err := p.upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		p.reportError("", fmt.Errorf("erro ao fazer upgrade: %w", err))
+		return
+	}
+	if protoErr != nil {
+		rejectConn(conn, protoErr)
+		p.reportError("", protoErr)
+		return
 	}
 
+	p.lock.RLock()
+	heartbeat := p.heartbeat
+	compression := p.compression
+	fragmentation := p.fragmentation
+	p.lock.RUnlock()
+	opts := defaultClientOptions()
+	opts.heartbeat = heartbeat
+	client := newClient(conn, opts)
+	client.SetCompression(compression)
+	client.SetFragmentation(fragmentation)
+
 	// Configura os handlers para o novo cliente
 	for event, handler := range p.handlers {
 		finalHandler := handler // Captura o handler atual
@@ -186,10 +291,7 @@ func (p *Peer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	p.lock.Lock()
-	p.clients[client.ID] = client
-	p.lock.Unlock()
-
+	p.track(client)
 	go client.listen()
 }
 
@@ -199,7 +301,11 @@ func (p *Peer) Start() error {
 		return p.startServer()
 	}
 	http.HandleFunc("/ws", p.handleWebSocket)
-	return http.ListenAndServe(fmt.Sprintf(":%d", p.Port), nil)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", p.Port)}
+	p.lock.Lock()
+	p.httpServer = server
+	p.lock.Unlock()
+	return ignoreServerClosed(server.ListenAndServe())
 }
 
 // Modifique o método SendBinary para incluir um tipo específico
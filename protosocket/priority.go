@@ -0,0 +1,61 @@
+package protosocket
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Prioridades aceitas por WithPriority.
+const (
+	// PriorityControl é reservada ao tráfego do protocolo, como acks e hello,
+	// e sempre sai antes das demais.
+	PriorityControl = Priority_PRIORITY_CONTROL
+	PriorityHigh    = Priority_PRIORITY_HIGH
+	PriorityNormal  = Priority_PRIORITY_NORMAL
+	// PriorityBulk é a fila de transferências grandes, como as de arquivos.
+	PriorityBulk = Priority_PRIORITY_BULK
+)
+
+// laneSchedule é a ordem de atendimento das filas depois da de controle, e
+// laneWeights quantos frames cada uma envia por rodada quando todas têm
+// tráfego. Assim PriorityBulk anda devagar, mas nunca para.
+var (
+	laneSchedule = [...]Priority{PriorityHigh, PriorityNormal, PriorityBulk}
+	laneWeights  = [...]int{
+		PriorityNormal: 4,
+		PriorityHigh:   8,
+		PriorityBulk:   1,
+	}
+)
+
+const laneCount = len(laneWeights)
+
+// WithPriority escolhe a fila de envio do envelope. Só a ordem dentro de uma
+// mesma prioridade é preservada.
+func WithPriority(priority Priority) EmitOption {
+	return func(m *Message) {
+		m.Priority = priority
+	}
+}
+
+// lane devolve a fila de envio do envelope. Respostas e eventos reservados vão
+// na de controle para não esperar atrás de transferências grandes.
+func (m *Message) lane() Priority {
+	if m.IsReply || strings.HasPrefix(m.Event, "$") {
+		return PriorityControl
+	}
+	if int(m.Priority) >= laneCount {
+		return PriorityNormal
+	}
+	return m.Priority
+}
+
+// sequenced dá ao envelope a próxima sequência do remetente. Só a prioridade
+// normal é sequenciada: as outras passam na frente de propósito e seriam
+// seguradas pela entrega em ordem do outro lado.
+func sequenced(counter *uint64, envelope *Message) *Message {
+	if envelope.lane() == PriorityNormal {
+		envelope.Sequence = atomic.AddUint64(counter, 1)
+	}
+	return envelope
+}
@@ -0,0 +1,274 @@
+package protosocket
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestWritePump cria uma fila de escrita sem conexão nem goroutine de
+// escrita, para exercitar apenas o agendamento.
+func newTestWritePump(queueSize int, policy OverflowPolicy) *writePump {
+	return &writePump{
+		queueSize: queueSize,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		policy:    policy,
+	}
+}
+
+// laneFrame identifica o frame pela fila e por um número de ordem.
+func laneFrame(lane Priority, n byte) outboundFrame {
+	return outboundFrame{data: []byte{byte(lane), n}, lane: lane}
+}
+
+func TestMessageLane(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+		lane Priority
+	}{
+		{"padrão", &Message{Event: "chat"}, PriorityNormal},
+		{"alta", &Message{Event: "chat", Priority: PriorityHigh}, PriorityHigh},
+		{"bulk", &Message{Event: "chat", Priority: PriorityBulk}, PriorityBulk},
+		{"controle pedido pelo usuário", &Message{Event: "chat", Priority: PriorityControl}, PriorityControl},
+		{"resposta", &Message{Event: "chat", IsReply: true, Priority: PriorityBulk}, PriorityControl},
+		{"evento reservado", &Message{Event: NackEvent}, PriorityControl},
+		{"prioridade desconhecida", &Message{Event: "chat", Priority: Priority(42)}, PriorityNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.lane(); got != tt.lane {
+				t.Errorf("lane = %v, esperado %v", got, tt.lane)
+			}
+		})
+	}
+}
+
+func TestSequencedLanes(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *Message
+		sequence uint64
+	}{
+		{"normal é sequenciada", &Message{Event: "chat"}, 1},
+		{"alta não é sequenciada", &Message{Event: "chat", Priority: PriorityHigh}, 0},
+		{"bulk não é sequenciada", &Message{Event: "chat", Priority: PriorityBulk}, 0},
+		{"resposta não é sequenciada", &Message{Event: "chat", IsReply: true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter uint64
+			if got := sequenced(&counter, tt.msg).Sequence; got != tt.sequence {
+				t.Errorf("sequência = %d, esperado %d", got, tt.sequence)
+			}
+		})
+	}
+}
+
+func TestWritePumpSchedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		queued     map[Priority]int
+		holdNormal bool
+		take       int
+		lanes      []Priority
+	}{
+		{
+			name:   "controle primeiro",
+			queued: map[Priority]int{PriorityBulk: 1, PriorityHigh: 1, PriorityNormal: 1, PriorityControl: 2},
+			take:   5,
+			lanes:  []Priority{PriorityControl, PriorityControl, PriorityHigh, PriorityNormal, PriorityBulk},
+		},
+		{
+			name:   "rodízio ponderado",
+			queued: map[Priority]int{PriorityHigh: 20, PriorityNormal: 20, PriorityBulk: 20},
+			take:   13,
+			lanes: slices.Concat(
+				repeatLane(PriorityHigh, 8),
+				repeatLane(PriorityNormal, 4),
+				[]Priority{PriorityBulk},
+			),
+		},
+		{
+			name:   "bulk sozinho não para",
+			queued: map[Priority]int{PriorityBulk: 5},
+			take:   5,
+			lanes:  repeatLane(PriorityBulk, 5),
+		},
+		{
+			name:   "bulk anda com as outras cheias",
+			queued: map[Priority]int{PriorityHigh: 30, PriorityBulk: 3},
+			take:   27,
+			lanes: slices.Concat(
+				repeatLane(PriorityHigh, 8), []Priority{PriorityBulk},
+				repeatLane(PriorityHigh, 8), []Priority{PriorityBulk},
+				repeatLane(PriorityHigh, 8), []Priority{PriorityBulk},
+			),
+		},
+		{
+			name:       "normal segurada atrás de fragmentada",
+			queued:     map[Priority]int{PriorityNormal: 2, PriorityBulk: 2, PriorityControl: 1},
+			holdNormal: true,
+			take:       4,
+			lanes:      []Priority{PriorityControl, PriorityBulk, PriorityBulk},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWritePump(100, DropNewest)
+			for _, lane := range []Priority{PriorityControl, PriorityHigh, PriorityNormal, PriorityBulk} {
+				for i := 0; i < tt.queued[lane]; i++ {
+					if err := w.push(laneFrame(lane, byte(i))); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var lanes []Priority
+			next := make(map[Priority]byte)
+			for i := 0; i < tt.take; i++ {
+				frame, ok := w.next(tt.holdNormal)
+				if !ok {
+					break
+				}
+				lanes = append(lanes, frame.lane)
+				// A ordem dentro de cada fila é preservada
+				if frame.data[1] != next[frame.lane] {
+					t.Fatalf("fila %v fora de ordem: %d, esperado %d", frame.lane, frame.data[1], next[frame.lane])
+				}
+				next[frame.lane]++
+			}
+			if !slices.Equal(lanes, tt.lanes) {
+				t.Errorf("filas = %v, esperado %v", lanes, tt.lanes)
+			}
+		})
+	}
+}
+
+func TestWritePumpLaneOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		lane   Priority
+		pushes int
+		err    error
+		kept   []byte
+	}{
+		{"descarta a mais antiga", DropOldest, PriorityNormal, 3, nil, []byte{1, 2}},
+		{"recusa a nova", DropNewest, PriorityNormal, 3, ErrQueueFull, []byte{0, 1}},
+		{"controle nunca descarta", DropOldest, PriorityControl, 3, ErrQueueFull, []byte{0, 1}},
+		{"bulk descarta a mais antiga", DropOldest, PriorityBulk, 4, nil, []byte{2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWritePump(2, tt.policy)
+			// Uma fila cheia não afeta as outras
+			if err := w.push(laneFrame(PriorityHigh, 0)); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for i := 0; i < tt.pushes; i++ {
+				if e := w.push(laneFrame(tt.lane, byte(i))); e != nil {
+					err = e
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+
+			var kept []byte
+			for _, frame := range w.lanes[tt.lane] {
+				kept = append(kept, frame.data[1])
+			}
+			if !slices.Equal(kept, tt.kept) {
+				t.Errorf("fila = %v, esperado %v", kept, tt.kept)
+			}
+			if len(w.lanes[PriorityHigh]) != 1 {
+				t.Errorf("fila alta com %d frames, esperado 1", len(w.lanes[PriorityHigh]))
+			}
+		})
+	}
+}
+
+func TestDispatcherExpress(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DispatchConfig
+		order  []string
+	}{
+		{
+			name:   "ordenado",
+			config: DispatchConfig{Mode: DispatchOrdered},
+			order:  []string{"bloqueio", "controle", "alta", "normal-1", "bulk", "normal-2"},
+		},
+		{
+			name:   "pool com um worker",
+			config: DispatchConfig{Mode: DispatchPool, Workers: 1},
+			order:  []string{"bloqueio", "controle", "alta", "normal-1", "bulk", "normal-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDispatcher(tt.config)
+			defer d.stop()
+
+			var lock sync.Mutex
+			var order []string
+			done := make(chan struct{})
+			record := func(name string) func() {
+				return func() {
+					lock.Lock()
+					order = append(order, name)
+					n := len(order)
+					lock.Unlock()
+					if n == len(tt.order) {
+						close(done)
+					}
+				}
+			}
+
+			// Segura o worker até todas as tarefas estarem na fila
+			started, release := make(chan struct{}), make(chan struct{})
+			d.submit(MessageMetadata{}, func() {
+				close(started)
+				<-release
+				record("bloqueio")()
+			})
+			<-started
+			d.submit(MessageMetadata{Priority: PriorityNormal}, record("normal-1"))
+			d.submit(MessageMetadata{Priority: PriorityBulk}, record("bulk"))
+			d.submit(MessageMetadata{Priority: PriorityControl}, record("controle"))
+			d.submit(MessageMetadata{Priority: PriorityHigh}, record("alta"))
+			d.submit(MessageMetadata{Priority: PriorityNormal}, record("normal-2"))
+			close(release)
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("tarefas não terminaram")
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if !slices.Equal(order, tt.order) {
+				t.Errorf("ordem = %v, esperado %v", order, tt.order)
+			}
+		})
+	}
+}
+
+func repeatLane(lane Priority, n int) []Priority {
+	lanes := make([]Priority, n)
+	for i := range lanes {
+		lanes[i] = lane
+	}
+	return lanes
+}
@@ -1,7 +1,15 @@
 package protosocket
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mendes113/protosocket/protosocket/types"
+	"go.uber.org/zap"
 )
 
 type ReconnectConfig struct {
@@ -22,10 +30,7 @@ func (p *Peer) EnableReconnect(config ReconnectConfig) {
 
 				if err := p.Connect("localhost:8081"); err != nil {
 					attempts++
-					delay = time.Duration(float64(delay) * config.BackoffMultiplier)
-					if delay > config.MaxDelay {
-						delay = config.MaxDelay
-					}
+					delay = nextDelay(delay, config.InitialDelay, config.MaxDelay, config.BackoffMultiplier)
 					continue
 				}
 
@@ -36,3 +41,183 @@ func (p *Peer) EnableReconnect(config ReconnectConfig) {
 		}
 	}()
 }
+
+var (
+	ErrReconnectBufferFull = errors.New("buffer de reconexão cheio")
+	ErrReconnectFailed     = errors.New("reconexão esgotou as tentativas")
+)
+
+// DefaultReconnectBuffer é quantos envelopes o Client guarda durante uma queda.
+const DefaultReconnectBuffer = 256
+
+// shouldReconnect indica se a queda deve ser seguida de reconexão. Fechamentos
+// pedidos pela aplicação, pelo servidor ou por protocolo incompatível são definitivos.
+func (c *Client) shouldReconnect(reason error) bool {
+	if c.options.reconnect == nil || len(c.urls) == 0 || c.closing.Err() != nil {
+		return false
+	}
+	if errors.Is(reason, ErrConnectionClosed) || errors.Is(reason, ErrIncompatibleProtocol) {
+		return false
+	}
+	switch code, _ := CloseStatus(reason); code {
+	case websocket.CloseNormalClosure, websocket.ClosePolicyViolation, CloseIncompatibleProtocol:
+		return false
+	}
+	return true
+}
+
+// reconnect tenta restabelecer a conexão com backoff exponencial e jitter,
+// percorrendo as URLs em ordem a cada tentativa. MaxAttempts zero tenta para sempre.
+func (c *Client) reconnect() error {
+	config := *c.options.reconnect
+	c.acks.reopen()
+	c.streams.reopen()
+
+	delay := config.InitialDelay
+	var lastErr error
+	for attempt := 0; config.MaxAttempts <= 0 || attempt < config.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(jitter(delay)):
+		case <-c.closing.Done():
+			return ErrConnectionClosed
+		}
+
+		for _, url := range c.urls {
+			conn, err := c.options.dial(c.closing, url)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			c.logger.Info("reconectado",
+				zap.String("clientID", c.ID),
+				zap.String("url", url),
+				zap.Int("tentativa", attempt+1))
+			return c.resume(conn)
+		}
+
+		delay = nextDelay(delay, config.InitialDelay, config.MaxDelay, config.BackoffMultiplier)
+	}
+	return fmt.Errorf("%w: %w", ErrReconnectFailed, lastErr)
+}
+
+// nextDelay multiplica o atraso mantendo-o entre initial e maxDelay.
+// Multiplicadores menores que 1 valem 1, e sem initial a espera parte do
+// padrão de DefaultRetryConfig, para que valores zerados não virem um laço
+// de discagem sem pausa.
+func nextDelay(delay, initial, maxDelay time.Duration, multiplier float64) time.Duration {
+	if initial <= 0 {
+		initial = DefaultRetryConfig().InitialDelay
+	}
+	delay = max(delay, initial)
+	next := float64(delay) * max(multiplier, 1)
+	switch {
+	case maxDelay > 0 && next >= float64(max(maxDelay, initial)):
+		return max(maxDelay, initial)
+	case next >= math.MaxInt64:
+		return delay
+	}
+	return time.Duration(next)
+}
+
+// jitter sorteia a espera entre metade e o total do atraso, espalhando as
+// reconexões de vários clientes.
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// resume passa a usar a nova conexão e envia o que ficou no buffer.
+func (c *Client) resume(conn *websocket.Conn) error {
+	c.lock.Lock()
+	if c.closing.Err() != nil {
+		c.lock.Unlock()
+		conn.Close()
+		return ErrConnectionClosed
+	}
+	c.attach(conn)
+	c.lock.Unlock()
+
+	c.sequencer.reset(0)
+	c.sendHello()
+	c.flushOutbox()
+	if c.delivery != nil {
+		c.redeliver(true)
+	}
+	return nil
+}
+
+// buffer guarda o envelope até a reconexão. Deve ser chamado com o lock.
+func (c *Client) buffer(envelope *Message) error {
+	if c.state != types.StateReconnecting {
+		return ErrConnectionClosed
+	}
+	limit := c.options.reconnectBuffer
+	if limit <= 0 || len(c.outbox) >= limit {
+		return ErrReconnectBufferFull
+	}
+	c.outbox = append(c.outbox, envelope)
+	return nil
+}
+
+// flushOutbox envia o buffer em ordem. O estado só volta a conectado quando o
+// buffer esvazia, para que envios novos não passem na frente dos antigos.
+func (c *Client) flushOutbox() {
+	for {
+		c.lock.Lock()
+		pending := c.outbox
+		c.outbox = nil
+		if len(pending) == 0 {
+			c.lock.Unlock()
+			c.setState(types.StateConnected)
+			return
+		}
+		c.lock.Unlock()
+
+		for _, envelope := range pending {
+			if err := c.write(envelope); err != nil && !errors.Is(err, ErrMessageExpired) {
+				c.reportError(fmt.Errorf("erro ao reenviar '%s': %w", envelope.Event, err))
+			}
+		}
+	}
+}
+
+// discardOutbox descarta o buffer quando a reconexão desiste.
+func (c *Client) discardOutbox(reason error) {
+	c.lock.Lock()
+	pending := c.outbox
+	c.outbox = nil
+	c.lock.Unlock()
+	if len(pending) > 0 {
+		c.reportError(fmt.Errorf("%d mensagens do buffer de reconexão descartadas: %w", len(pending), reason))
+	}
+}
+
+// State retorna o estado da conexão do Client.
+func (c *Client) State() types.ConnectionState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// OnStateChange registra um callback chamado a cada mudança de estado da
+// conexão. Para acompanhar também o dial inicial, use WithStateHandler.
+func (c *Client) OnStateChange(callback func(state types.ConnectionState)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onStateChange = callback
+}
+
+func (c *Client) setState(state types.ConnectionState) {
+	c.lock.Lock()
+	changed := c.state != state
+	c.state = state
+	callback := c.onStateChange
+	c.lock.Unlock()
+
+	if changed && callback != nil {
+		callback(state)
+	}
+}
@@ -0,0 +1,243 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mendes113/protosocket/protosocket/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// fastReconnect reconecta em poucos milissegundos, sem limite de tentativas.
+var fastReconnect = RetryConfig{InitialDelay: 5 * time.Millisecond, MaxDelay: 10 * time.Millisecond, BackoffMultiplier: 2}
+
+// flakyServer é um servidor que pode recusar novas conexões e derrubar as atuais.
+type flakyServer struct {
+	url      string
+	down     atomic.Bool
+	sockets  chan *Socket
+	received chan string
+}
+
+func newFlakyServer(t *testing.T) *flakyServer {
+	t.Helper()
+	f := &flakyServer{sockets: make(chan *Socket, 4), received: make(chan string, 16)}
+	server := NewServer()
+	server.OnConnection(func(socket *Socket) { f.sockets <- socket })
+	server.On("chat", func(data proto.Message, _ *Socket) { f.received <- data.(*ChatMessage).Content })
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.down.Load() {
+			http.Error(w, "fora do ar", http.StatusServiceUnavailable)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	f.url = "ws" + strings.TrimPrefix(ts.URL, "http")
+	return f
+}
+
+// drop derruba a conexão sem handshake de fechamento, como uma queda de rede.
+func (f *flakyServer) drop(t *testing.T) {
+	t.Helper()
+	select {
+	case socket := <-f.sockets:
+		socket.Conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("nenhum socket conectado")
+	}
+}
+
+func waitState(t *testing.T, client *Client, state types.ConnectionState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for client.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("estado = %v, esperado %v", client.State(), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShouldReconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason error
+		want   bool
+	}{
+		{"queda de rede", &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, true},
+		{"servidor reiniciando", &websocket.CloseError{Code: websocket.CloseServiceRestart}, true},
+		{"fechamento normal", &websocket.CloseError{Code: websocket.CloseNormalClosure}, false},
+		{"violação de política", &websocket.CloseError{Code: websocket.ClosePolicyViolation}, false},
+		{"protocolo incompatível", &websocket.CloseError{Code: CloseIncompatibleProtocol}, false},
+		{"fechado pela aplicação", ErrConnectionClosed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closing, markClosing := context.WithCancel(context.Background())
+			defer markClosing()
+			c := &Client{options: clientOptions{reconnect: &fastReconnect}, urls: []string{"ws://a"}, closing: closing}
+			if got := c.shouldReconnect(tt.reason); got != tt.want {
+				t.Errorf("reconecta = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+
+	c := &Client{urls: []string{"ws://a"}, closing: context.Background()}
+	if c.shouldReconnect(&websocket.CloseError{Code: websocket.CloseAbnormalClosure}) {
+		t.Error("reconectou sem WithReconnect")
+	}
+}
+
+func TestNextDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		initial    time.Duration
+		maxDelay   time.Duration
+		multiplier float64
+		want       time.Duration
+	}{
+		{"dobra o atraso", 10 * time.Millisecond, 10 * time.Millisecond, time.Second, 2, 20 * time.Millisecond},
+		{"limitado ao máximo", 600 * time.Millisecond, 10 * time.Millisecond, time.Second, 2, time.Second},
+		{"multiplicador menor que 1", 40 * time.Millisecond, 10 * time.Millisecond, time.Second, 0.5, 40 * time.Millisecond},
+		{"multiplicador zerado", 40 * time.Millisecond, 10 * time.Millisecond, time.Second, 0, 40 * time.Millisecond},
+		{"atraso abaixo do inicial", 0, 10 * time.Millisecond, time.Second, 2, 20 * time.Millisecond},
+		{"sem atraso inicial usa o padrão", 0, 0, 0, 2, 2 * DefaultRetryConfig().InitialDelay},
+		{"máximo menor que o inicial", 10 * time.Millisecond, 50 * time.Millisecond, 20 * time.Millisecond, 2, 50 * time.Millisecond},
+		{"sem máximo não estoura", time.Duration(math.MaxInt64 / 2), time.Millisecond, 0, 4, time.Duration(math.MaxInt64 / 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDelay(tt.delay, tt.initial, tt.maxDelay, tt.multiplier); got != tt.want {
+				t.Errorf("atraso = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialFallback(t *testing.T) {
+	primary, fallback := newFlakyServer(t), newFlakyServer(t)
+	primary.down.Store(true)
+
+	var lock sync.Mutex
+	var states []types.ConnectionState
+	client, err := Dial(context.Background(), primary.url, WithFallbackURLs(fallback.url), WithStateHandler(func(state types.ConnectionState) {
+		lock.Lock()
+		states = append(states, state)
+		lock.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case <-fallback.sockets:
+	case <-time.After(time.Second):
+		t.Fatal("cliente não conectou na URL alternativa")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(states) != 2 || states[0] != types.StateConnecting || states[1] != types.StateConnected {
+		t.Errorf("estados = %v", states)
+	}
+
+	fallback.down.Store(true)
+	if _, err := Dial(context.Background(), primary.url, WithFallbackURLs(fallback.url)); err == nil {
+		t.Error("Dial conectou com todas as URLs fora do ar")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	primary, fallback := newFlakyServer(t), newFlakyServer(t)
+	client, err := Dial(context.Background(), primary.url,
+		WithFallbackURLs(fallback.url), WithReconnect(fastReconnect), WithReconnectBuffer(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Com as duas URLs fora do ar o cliente fica reconectando e guarda os envios
+	primary.down.Store(true)
+	fallback.down.Store(true)
+	primary.drop(t)
+	waitState(t, client, types.StateReconnecting)
+	for _, content := range []string{"1", "2"} {
+		if err := client.Emit("chat", &ChatMessage{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Emit("chat", &ChatMessage{Content: "3"}); !errors.Is(err, ErrReconnectBufferFull) {
+		t.Errorf("erro = %v, esperado %v", err, ErrReconnectBufferFull)
+	}
+
+	// A URL alternativa volta primeiro e recebe o buffer em ordem
+	fallback.down.Store(false)
+	waitState(t, client, types.StateConnected)
+	for _, want := range []string{"1", "2"} {
+		select {
+		case got := <-fallback.received:
+			if got != want {
+				t.Errorf("mensagem = %q, esperado %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("mensagem %q do buffer não chegou", want)
+		}
+	}
+	if err := client.Emit("chat", &ChatMessage{Content: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-fallback.received; got != "4" {
+		t.Errorf("mensagem = %q, esperado 4", got)
+	}
+}
+
+func TestClientReconnectGivesUp(t *testing.T) {
+	server := newFlakyServer(t)
+	config := fastReconnect
+	config.MaxAttempts = 2
+	client, err := Dial(context.Background(), server.url, WithReconnect(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 16)
+	client.OnError(func(_ *Client, err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	server.down.Store(true)
+	server.drop(t)
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("cliente não desistiu da reconexão")
+	}
+	if client.State() != types.StateDisconnected {
+		t.Errorf("estado = %v, esperado desconectado", client.State())
+	}
+	for {
+		select {
+		case err := <-errs:
+			if errors.Is(err, ErrReconnectFailed) {
+				return
+			}
+		default:
+			t.Fatal("OnError não recebeu ErrReconnectFailed")
+		}
+	}
+}
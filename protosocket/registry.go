@@ -0,0 +1,99 @@
+package protosocket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var (
+	ErrUnknownEvent = errors.New("evento desconhecido")
+	ErrTypeMismatch = errors.New("tipo da mensagem não corresponde ao evento")
+)
+
+// EventRegistry associa nomes de evento aos tipos protobuf usados para decodificá-los.
+type EventRegistry struct {
+	types map[string]protoreflect.MessageType
+	lock  sync.RWMutex
+}
+
+// DefaultRegistry é o registro usado por Socket, Client e Peer.
+var DefaultRegistry = NewEventRegistry()
+
+// NewEventRegistry cria um registro já com os eventos nativos da biblioteca.
+func NewEventRegistry() *EventRegistry {
+	r := &EventRegistry{
+		types: make(map[string]protoreflect.MessageType),
+	}
+	r.Register("chat", &ChatMessage{})
+	r.Register("binary", &BinaryMessage{})
+	r.Register(HelloEvent, &Hello{})
+	r.Register(NackEvent, &Nack{})
+	r.Register(DeliveredEvent, &Delivered{})
+	r.Register(ValidationEvent, &ValidationError{})
+	return r
+}
+
+// RegisterEvent associa um evento a um tipo no DefaultRegistry.
+func RegisterEvent(event string, prototype proto.Message) {
+	DefaultRegistry.Register(event, prototype)
+}
+
+// Register associa um evento ao tipo da mensagem de exemplo.
+func (r *EventRegistry) Register(event string, prototype proto.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.types[event] = prototype.ProtoReflect().Type()
+}
+
+// registerIfAbsent só registra o evento se ele ainda não tiver um tipo.
+func (r *EventRegistry) registerIfAbsent(event string, prototype proto.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.types[event]; !ok {
+		r.types[event] = prototype.ProtoReflect().Type()
+	}
+}
+
+// Decode cria a mensagem do evento e desserializa data nela.
+func (r *EventRegistry) Decode(event, dataType string, data []byte) (proto.Message, error) {
+	mt, err := r.messageType(event, dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// messageType resolve o tipo do payload. Eventos registrados têm prioridade;
+// sem registro, usa o nome do tipo enviado no envelope e resolve pelo protoregistry.
+func (r *EventRegistry) messageType(event, dataType string) (protoreflect.MessageType, error) {
+	r.lock.RLock()
+	mt, ok := r.types[event]
+	r.lock.RUnlock()
+
+	if ok {
+		if dataType != "" && string(mt.Descriptor().FullName()) != dataType {
+			return nil, fmt.Errorf("%w: %s esperava %s, recebeu %s",
+				ErrTypeMismatch, event, mt.Descriptor().FullName(), dataType)
+		}
+		return mt, nil
+	}
+	if dataType == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, event)
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(dataType))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrUnknownEvent, event, dataType)
+	}
+	return mt, nil
+}
@@ -0,0 +1,68 @@
+package protosocket
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEventRegistryDecode(t *testing.T) {
+	chat, err := proto.Marshal(&ChatMessage{Content: "oi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatType := dataTypeOf(&ChatMessage{})
+
+	tests := []struct {
+		name     string
+		event    string
+		dataType string
+		data     []byte
+		err      error
+		content  string
+	}{
+		{"evento registrado", "chat", "", chat, nil, "oi"},
+		{"evento registrado com o tipo certo", "chat", chatType, chat, nil, "oi"},
+		{"evento registrado com outro tipo", "chat", dataTypeOf(&BinaryMessage{}), chat, ErrTypeMismatch, ""},
+		{"evento desconhecido sem tipo", "desconhecido", "", chat, ErrUnknownEvent, ""},
+		{"evento desconhecido com tipo conhecido", "desconhecido", chatType, chat, nil, "oi"},
+		{"evento desconhecido com tipo desconhecido", "desconhecido", "nada.Existe", chat, ErrUnknownEvent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewEventRegistry().Decode(tt.event, tt.dataType, tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if tt.content == "" {
+				return
+			}
+			if got, ok := msg.(*ChatMessage); !ok || got.Content != tt.content {
+				t.Errorf("mensagem = %v, esperado %q", msg, tt.content)
+			}
+		})
+	}
+}
+
+func TestEventRegistryRegister(t *testing.T) {
+	r := NewEventRegistry()
+	r.Register("arquivo", &BinaryMessage{})
+	// registerIfAbsent não substitui um tipo já registrado
+	r.registerIfAbsent("arquivo", &ChatMessage{})
+	r.registerIfAbsent("texto", &ChatMessage{})
+
+	data, err := proto.Marshal(&BinaryMessage{Filename: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := r.Decode("arquivo", "", data); err != nil {
+		t.Fatal(err)
+	} else if _, ok := msg.(*BinaryMessage); !ok {
+		t.Errorf("arquivo decodificado como %T", msg)
+	}
+	if _, err := r.Decode("texto", dataTypeOf(&ChatMessage{}), nil); err != nil {
+		t.Errorf("texto: %v", err)
+	}
+}
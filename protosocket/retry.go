@@ -11,6 +11,16 @@ type RetryConfig struct {
 	BackoffMultiplier float64
 }
 
+// DefaultRetryConfig tenta três vezes, dobrando a espera de 1s até 5s.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:       3,
+		InitialDelay:      time.Second,
+		MaxDelay:          5 * time.Second,
+		BackoffMultiplier: 2.0,
+	}
+}
+
 func WithRetry(config RetryConfig, operation func() error) error {
 	var err error
 	delay := config.InitialDelay
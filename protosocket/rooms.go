@@ -0,0 +1,275 @@
+package protosocket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultNamespace é o namespace usado quando o cliente não informa nenhum.
+const DefaultNamespace = "/"
+
+// NamespaceQueryParam é o parâmetro de query usado no handshake para escolher o namespace.
+const NamespaceQueryParam = "ns"
+
+// Namespace agrupa sockets, handlers e salas sob um mesmo nome.
+type Namespace struct {
+	Name         string
+	sockets      map[string]*Socket
+	rooms        map[string]map[string]*Socket
+	handlers     map[string]func(proto.Message, *Socket)
+	onConnection func(socket *Socket)
+	lock         sync.RWMutex
+}
+
+func newNamespace(name string) *Namespace {
+	return &Namespace{
+		Name:     name,
+		sockets:  make(map[string]*Socket),
+		rooms:    make(map[string]map[string]*Socket),
+		handlers: make(map[string]func(proto.Message, *Socket)),
+	}
+}
+
+// On registra um handler que vale apenas para os sockets deste namespace.
+func (n *Namespace) On(event string, handler func(proto.Message, *Socket)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.handlers[event] = handler
+}
+
+// OnConnection registra um callback chamado quando um socket entra no namespace.
+func (n *Namespace) OnConnection(callback func(socket *Socket)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.onConnection = callback
+}
+
+// To seleciona os sockets das salas informadas.
+func (n *Namespace) To(rooms ...string) *BroadcastOperator {
+	return (&BroadcastOperator{ns: n}).To(rooms...)
+}
+
+// Except seleciona todos os sockets do namespace, exceto os das salas informadas.
+func (n *Namespace) Except(rooms ...string) *BroadcastOperator {
+	return (&BroadcastOperator{ns: n}).Except(rooms...)
+}
+
+// Emit envia a mensagem para todos os sockets do namespace.
+func (n *Namespace) Emit(event string, msg proto.Message, opts ...EmitOption) error {
+	return (&BroadcastOperator{ns: n}).Emit(event, msg, opts...)
+}
+
+// Sockets retorna os IDs dos sockets presentes na sala.
+func (n *Namespace) Sockets(room string) []string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	ids := make([]string, 0, len(n.rooms[room]))
+	for id := range n.rooms[room] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (n *Namespace) add(socket *Socket) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.sockets[socket.ID] = socket
+}
+
+func (n *Namespace) remove(socket *Socket) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.sockets, socket.ID)
+}
+
+func (n *Namespace) join(socket *Socket, room string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	members, ok := n.rooms[room]
+	if !ok {
+		members = make(map[string]*Socket)
+		n.rooms[room] = members
+	}
+	members[socket.ID] = socket
+}
+
+func (n *Namespace) leave(socket *Socket, room string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	members, ok := n.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, socket.ID)
+	// Salas vazias são removidas para não acumular canais abandonados
+	if len(members) == 0 {
+		delete(n.rooms, room)
+	}
+}
+
+// BroadcastOperator monta o conjunto de destinatários de um envio em massa.
+type BroadcastOperator struct {
+	ns     *Namespace
+	rooms  []string
+	except []string
+}
+
+// To adiciona salas ao conjunto de destino.
+func (b *BroadcastOperator) To(rooms ...string) *BroadcastOperator {
+	return &BroadcastOperator{
+		ns:     b.ns,
+		rooms:  append(append([]string{}, b.rooms...), rooms...),
+		except: b.except,
+	}
+}
+
+// Except exclui do envio os sockets das salas informadas.
+// Como todo socket entra automaticamente na sala com o próprio ID, também aceita IDs de socket.
+func (b *BroadcastOperator) Except(rooms ...string) *BroadcastOperator {
+	return &BroadcastOperator{
+		ns:     b.ns,
+		rooms:  b.rooms,
+		except: append(append([]string{}, b.except...), rooms...),
+	}
+}
+
+// Emit envia a mensagem para os sockets selecionados.
+func (b *BroadcastOperator) Emit(event string, msg proto.Message, opts ...EmitOption) error {
+	var errs []error
+	for _, socket := range b.targets() {
+		if err := socket.Emit(event, msg, opts...); err != nil {
+			errs = append(errs, fmt.Errorf("socket %s: %w", socket.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// targets resolve os destinatários sem manter o lock durante o envio.
+func (b *BroadcastOperator) targets() []*Socket {
+	b.ns.lock.RLock()
+	defer b.ns.lock.RUnlock()
+
+	excluded := make(map[string]struct{})
+	for _, room := range b.except {
+		for id := range b.ns.rooms[room] {
+			excluded[id] = struct{}{}
+		}
+	}
+
+	selected := make(map[string]*Socket)
+	if len(b.rooms) == 0 {
+		for id, socket := range b.ns.sockets {
+			selected[id] = socket
+		}
+	} else {
+		for _, room := range b.rooms {
+			for id, socket := range b.ns.rooms[room] {
+				selected[id] = socket
+			}
+		}
+	}
+
+	targets := make([]*Socket, 0, len(selected))
+	for id, socket := range selected {
+		if _, skip := excluded[id]; !skip {
+			targets = append(targets, socket)
+		}
+	}
+	return targets
+}
+
+// Join coloca o socket nas salas informadas. Depois que o socket sai de
+// todas as salas ao desconectar, Join não tem efeito.
+func (s *Socket) Join(rooms ...string) {
+	s.roomLock.Lock()
+	defer s.roomLock.Unlock()
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	for _, room := range rooms {
+		s.rooms[room] = struct{}{}
+	}
+	ns := s.namespace
+	s.lock.Unlock()
+
+	if ns != nil {
+		for _, room := range rooms {
+			ns.join(s, room)
+		}
+	}
+}
+
+// Leave remove o socket da sala informada.
+func (s *Socket) Leave(room string) {
+	s.roomLock.Lock()
+	defer s.roomLock.Unlock()
+	s.leave(room)
+}
+
+// leave remove o socket da sala. Deve ser chamado com o roomLock.
+func (s *Socket) leave(room string) {
+	s.lock.Lock()
+	delete(s.rooms, room)
+	ns := s.namespace
+	s.lock.Unlock()
+
+	if ns != nil {
+		ns.leave(s, room)
+	}
+}
+
+// Rooms retorna as salas em que o socket está.
+func (s *Socket) Rooms() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rooms := make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// Namespace retorna o namespace ao qual o socket pertence, ou nil se for avulso.
+func (s *Socket) Namespace() *Namespace {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.namespace
+}
+
+// To envia para as salas informadas, excluindo o próprio socket.
+func (s *Socket) To(rooms ...string) *BroadcastOperator {
+	ns := s.Namespace()
+	if ns == nil {
+		ns = newNamespace("")
+	}
+	return ns.To(rooms...).Except(s.ID)
+}
+
+// leaveAll remove o socket de todas as salas e do namespace e impede que um
+// Join atrasado o coloque de volta.
+func (s *Socket) leaveAll() {
+	s.roomLock.Lock()
+	defer s.roomLock.Unlock()
+
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+
+	for _, room := range s.Rooms() {
+		s.leave(room)
+	}
+
+	if ns := s.Namespace(); ns != nil {
+		ns.remove(s)
+	}
+}
@@ -0,0 +1,142 @@
+package protosocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// roomFixture monta um namespace com sockets sem conexão nas salas informadas.
+func roomFixture(members map[string][]string) (*Namespace, map[string]*Socket) {
+	ns := newNamespace("/teste")
+	sockets := make(map[string]*Socket)
+	for id, rooms := range members {
+		// Sem conexão: as salas não dependem dela
+		socket := &Socket{ID: id, rooms: make(map[string]struct{}), namespace: ns}
+		ns.add(socket)
+		socket.Join(append([]string{id}, rooms...)...)
+		sockets[id] = socket
+	}
+	return ns, sockets
+}
+
+func targetIDs(b *BroadcastOperator) []string {
+	var ids []string
+	for _, socket := range b.targets() {
+		ids = append(ids, socket.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestBroadcastTargets(t *testing.T) {
+	members := map[string][]string{
+		"a": {"sala1"},
+		"b": {"sala1", "sala2"},
+		"c": {"sala2"},
+		"d": nil,
+	}
+
+	tests := []struct {
+		name   string
+		target func(ns *Namespace, sockets map[string]*Socket) *BroadcastOperator
+		want   []string
+	}{
+		{"todo o namespace", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return &BroadcastOperator{ns: ns}
+		}, []string{"a", "b", "c", "d"}},
+		{"uma sala", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.To("sala1")
+		}, []string{"a", "b"}},
+		{"união de salas sem repetir", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.To("sala1").To("sala2")
+		}, []string{"a", "b", "c"}},
+		{"sala inexistente", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.To("nada")
+		}, nil},
+		{"exceto uma sala", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.Except("sala2")
+		}, []string{"a", "d"}},
+		{"sala exceto outra", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.To("sala1").Except("sala2")
+		}, []string{"a"}},
+		{"exceto pelo ID do socket", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			return ns.Except("a", "d")
+		}, []string{"b", "c"}},
+		{"socket.To exclui o próprio socket", func(_ *Namespace, sockets map[string]*Socket) *BroadcastOperator {
+			return sockets["b"].To("sala1", "sala2")
+		}, []string{"a", "c"}},
+		{"To não altera o operador original", func(ns *Namespace, _ map[string]*Socket) *BroadcastOperator {
+			base := ns.To("sala1")
+			base.To("sala2")
+			return base
+		}, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, sockets := roomFixture(members)
+			if got := targetIDs(tt.target(ns, sockets)); !slices.Equal(got, tt.want) {
+				t.Errorf("destinatários = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketLeave(t *testing.T) {
+	ns, sockets := roomFixture(map[string][]string{"a": {"sala"}, "b": {"sala"}})
+
+	sockets["a"].Leave("sala")
+	if got := ns.Sockets("sala"); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("sala = %v, esperado [b]", got)
+	}
+	if slices.Contains(sockets["a"].Rooms(), "sala") {
+		t.Error("socket continua listando a sala que deixou")
+	}
+
+	// A sala vazia é removida do namespace
+	sockets["b"].Leave("sala")
+	ns.lock.RLock()
+	_, exists := ns.rooms["sala"]
+	ns.lock.RUnlock()
+	if exists {
+		t.Error("sala vazia não foi removida")
+	}
+
+	sockets["a"].leaveAll()
+	if got := targetIDs(&BroadcastOperator{ns: ns}); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("namespace = %v, esperado [b] depois de leaveAll", got)
+	}
+	if got := targetIDs(ns.To("a")); got != nil {
+		t.Errorf("sala do próprio ID = %v, esperado vazia depois de leaveAll", got)
+	}
+
+	// Um Join atrasado, vindo de um handler ainda em execução, não tem efeito
+	sockets["a"].Join("sala")
+	if got := ns.Sockets("sala"); len(got) != 0 {
+		t.Errorf("sala = %v, esperado vazia depois de Join pós-desconexão", got)
+	}
+	if rooms := sockets["a"].Rooms(); len(rooms) != 0 {
+		t.Errorf("salas do socket = %v, esperado nenhuma", rooms)
+	}
+}
+
+func TestServerNamespaces(t *testing.T) {
+	server := NewServer()
+	if server.Of("/chat") != server.Of("/chat") {
+		t.Error("Of criou dois namespaces com o mesmo nome")
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// Um namespace desconhecido é recusado antes do upgrade
+	resp, err := http.Get(ts.URL + "?" + NamespaceQueryParam + "=/nada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, esperado %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,160 @@
+package protosocket
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// RPCConn é a conexão usada pelos clientes gerados pelo protoc-gen-protosocket.
+// Tanto *Client quanto *Socket a implementam.
+type RPCConn interface {
+	EmitWithAck(ctx context.Context, event string, msg proto.Message, opts ...EmitOption) (proto.Message, error)
+	OpenStream(ctx context.Context, event string, opts ...StreamOption) (*Stream, error)
+}
+
+// RPCServer recebe os handlers registrados pelo código gerado. Tanto *Server
+// quanto *Socket o implementam.
+type RPCServer interface {
+	OnWithAck(event string, handler AckHandler)
+	OnStream(event string, handler func(stream *Stream, socket *Socket))
+}
+
+// Invoke chama um método unário: envia in com EmitWithAck e copia a resposta em out.
+func Invoke(ctx context.Context, conn RPCConn, method string, in, out proto.Message, opts ...EmitOption) error {
+	resp, err := conn.EmitWithAck(ctx, method, in, opts...)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	if got, want := resp.ProtoReflect().Descriptor().FullName(), out.ProtoReflect().Descriptor().FullName(); got != want {
+		return fmt.Errorf("%w: '%s' respondeu %s em vez de %s", ErrTypeMismatch, method, got, want)
+	}
+	proto.Merge(out, resp)
+	return nil
+}
+
+// ClientStream lê as respostas de um método com stream do servidor.
+type ClientStream[T proto.Message] struct {
+	stream *Stream
+}
+
+// InvokeStream chama um método com stream do servidor: abre o stream, envia
+// in e fecha o envio. As respostas são lidas com Recv.
+func InvokeStream[T proto.Message](ctx context.Context, conn RPCConn, method string, in proto.Message, opts ...StreamOption) (*ClientStream[T], error) {
+	stream, err := conn.OpenStream(ctx, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		stream.Cancel()
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &ClientStream[T]{stream: stream}, nil
+}
+
+// Recv aguarda a próxima resposta. Retorna io.EOF quando o servidor terminou.
+func (c *ClientStream[T]) Recv() (T, error) {
+	var zero T
+	msg, err := c.stream.Recv(c.stream.Context())
+	if err != nil {
+		return zero, err
+	}
+	out, ok := msg.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: stream '%s'", ErrTypeMismatch, c.stream.Event())
+	}
+	return out, nil
+}
+
+// Context retorna o contexto do stream.
+func (c *ClientStream[T]) Context() context.Context {
+	return c.stream.Context()
+}
+
+// Cancel encerra a chamada antes de o servidor terminar.
+func (c *ClientStream[T]) Cancel() {
+	c.stream.Cancel()
+}
+
+// ServerStream envia as respostas de um método com stream do servidor.
+type ServerStream[T proto.Message] struct {
+	stream *Stream
+	ctx    context.Context
+}
+
+// Send envia uma resposta, esperando créditos do cliente se necessário.
+func (s *ServerStream[T]) Send(msg T) error {
+	return s.stream.Send(msg)
+}
+
+// Context retorna o contexto da chamada, com o Socket acessível por SocketFromContext.
+func (s *ServerStream[T]) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryHandler adapta a implementação de um método unário para OnWithAck.
+func UnaryHandler[Req, Resp proto.Message](fn func(ctx context.Context, req Req) (Resp, error)) AckHandler {
+	return func(data proto.Message, socket *Socket) (proto.Message, error) {
+		req, ok := data.(Req)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		resp, err := fn(context.WithValue(socket.Context(), socketContextKey, socket), req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// ServerStreamHandler adapta a implementação de um método com stream do
+// servidor para OnStream. O erro devolvido por fn cancela o stream e chega ao
+// cliente no Recv.
+func ServerStreamHandler[Req, Resp proto.Message](fn func(req Req, stream *ServerStream[Resp]) error) func(*Stream, *Socket) {
+	return func(stream *Stream, socket *Socket) {
+		msg, err := stream.Recv(stream.Context())
+		if err != nil {
+			stream.Cancel()
+			return
+		}
+		req, ok := msg.(Req)
+		if !ok {
+			stream.fail(ErrTypeMismatch, true)
+			return
+		}
+
+		ctx := context.WithValue(stream.Context(), socketContextKey, socket)
+		if err := fn(req, &ServerStream[Resp]{stream: stream, ctx: ctx}); err != nil {
+			stream.fail(err, true)
+			return
+		}
+		stream.CloseSend()
+	}
+}
+
+// StreamHandler adapta a implementação de um método com stream do cliente ou
+// bidirecional para OnStream. O erro devolvido por fn cancela o stream.
+func StreamHandler(fn func(stream *Stream) error) func(*Stream, *Socket) {
+	return func(stream *Stream, socket *Socket) {
+		if err := fn(stream); err != nil {
+			stream.fail(err, true)
+			return
+		}
+		stream.CloseSend()
+	}
+}
+
+// OnStream registra, para todos os sockets, o handler dos streams abertos pelo
+// cliente com o evento.
+func (s *Server) OnStream(event string, handler func(stream *Stream, socket *Socket)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streamHandlers[event] = handler
+}
@@ -0,0 +1,107 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer registra, como o código gerado faria, um método unário e um com
+// stream do servidor que repetem a mensagem recebida.
+func echoServer(t *testing.T) *Client {
+	t.Helper()
+	server := NewServer()
+	server.OnWithAck("eco.Eco/Repete", UnaryHandler(func(ctx context.Context, in *ChatMessage) (*ChatMessage, error) {
+		if SocketFromContext(ctx) == nil {
+			return nil, errors.New("sem socket no contexto")
+		}
+		if in.Content == "" {
+			return nil, errors.New("mensagem vazia")
+		}
+		return &ChatMessage{Content: in.Content}, nil
+	}))
+	server.OnWithAck("eco.Eco/Outro", UnaryHandler(func(_ context.Context, in *ChatMessage) (*BinaryMessage, error) {
+		return &BinaryMessage{Filename: in.Content}, nil
+	}))
+	server.OnStream("eco.Eco/Conta", ServerStreamHandler(func(in *ChatMessage, stream *ServerStream[*ChatMessage]) error {
+		for range 3 {
+			if err := stream.Send(&ChatMessage{Content: in.Content}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestInvoke(t *testing.T) {
+	client := echoServer(t)
+	tests := []struct {
+		name    string
+		method  string
+		content string
+		err     error
+	}{
+		{"resposta do tipo esperado", "eco.Eco/Repete", "oi", nil},
+		{"erro do handler", "eco.Eco/Repete", "", &RemoteError{}},
+		{"resposta de outro tipo", "eco.Eco/Outro", "oi", ErrTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			out := new(ChatMessage)
+			err := Invoke(ctx, client, tt.method, &ChatMessage{Content: tt.content}, out)
+			if remote, ok := tt.err.(*RemoteError); ok {
+				if !errors.As(err, &remote) {
+					t.Fatalf("erro = %v, esperado erro remoto", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if err == nil && out.Content != tt.content {
+				t.Errorf("resposta = %q, esperado %q", out.Content, tt.content)
+			}
+		})
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	client := echoServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	stream, err := InvokeStream[*ChatMessage](ctx, client, "eco.Eco/Conta", &ChatMessage{Content: "oi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received int
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Content != "oi" {
+			t.Errorf("resposta = %q", msg.Content)
+		}
+		received++
+	}
+	if received != 3 {
+		t.Errorf("%d respostas, esperado 3", received)
+	}
+}
@@ -33,7 +33,10 @@ func (p *Peer) EnableSecurity(config SecurityConfig) error {
 				},
 			}
 			http.HandleFunc("/ws", p.handleWebSocket)
-			return server.ListenAndServeTLS("", "")
+			p.lock.Lock()
+			p.httpServer = server
+			p.lock.Unlock()
+			return ignoreServerClosed(server.ListenAndServeTLS("", ""))
 		}
 	}
 
@@ -2,6 +2,7 @@
 package protosocket
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -13,11 +14,32 @@ import (
 
 // Server gerencia as conexões WebSocket.
 type Server struct {
-	upgrader     websocket.Upgrader
-	clients      map[string]*Socket
-	lock         sync.Mutex
-	onConnection func(socket *Socket)
-	handlers     map[string]func(proto.Message, *Socket)
+	upgrader       websocket.Upgrader
+	clients        map[string]*Socket
+	lock           sync.Mutex
+	onConnection   func(socket *Socket)
+	handlers       map[string]func(proto.Message, *Socket)
+	ackHandlers    map[string]AckHandler
+	streamHandlers map[string]func(*Stream, *Socket)
+	namespaces     map[string]*Namespace
+	writerConfig   WriterConfig
+	heartbeat      HeartbeatConfig
+	compression    CompressionConfig
+	fragmentation  FragmentConfig
+	sequencing     SequencerConfig
+	streaming      StreamConfig
+	dedup          *dedupWindow
+	validation     *ValidationRules
+	metrics        *MetricsCollector
+	onExpired      func(socket *Socket, envelope *Message)
+	dispatchConfig DispatchConfig
+	pool           *dispatcher
+	shuttingDown   bool
+	active         sync.WaitGroup
+	middlewares    []Middleware
+	auth           Authenticator
+	onDisconnect   func(socket *Socket, reason error)
+	onError        func(socket *Socket, err error)
 }
 
 // NewServer cria uma nova instância do Server.
@@ -27,11 +49,39 @@ func NewServer() *Server {
 			// Em produção, ajuste a checagem de origem conforme necessário.
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
-		clients:  make(map[string]*Socket),
-		handlers: make(map[string]func(proto.Message, *Socket)),
+		clients:        make(map[string]*Socket),
+		handlers:       make(map[string]func(proto.Message, *Socket)),
+		ackHandlers:    make(map[string]AckHandler),
+		streamHandlers: make(map[string]func(*Stream, *Socket)),
+		namespaces: map[string]*Namespace{
+			DefaultNamespace: newNamespace(DefaultNamespace),
+		},
+		writerConfig:   DefaultWriterConfig(),
+		heartbeat:      DefaultHeartbeatConfig(),
+		compression:    DefaultCompressionConfig(),
+		fragmentation:  DefaultFragmentConfig(),
+		sequencing:     DefaultSequencerConfig(),
+		streaming:      DefaultStreamConfig(),
+		dedup:          newDedupWindow(DefaultDeliveryConfig()),
+		metrics:        NewMetricsCollector(),
+		dispatchConfig: DefaultDispatchConfig(),
 	}
 }
 
+// SetHeartbeat define o heartbeat dos próximos sockets; Interval zero desativa.
+func (s *Server) SetHeartbeat(config HeartbeatConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.heartbeat = config
+}
+
+// SetWriterConfig define a fila de envio e a política de overflow dos próximos sockets.
+func (s *Server) SetWriterConfig(config WriterConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writerConfig = config
+}
+
 // OnConnection permite registrar um callback que será chamado quando um novo cliente se conectar.
 func (s *Server) OnConnection(callback func(socket *Socket)) {
 	s.onConnection = callback
@@ -42,45 +92,217 @@ func (s *Server) On(event string, handler func(proto.Message, *Socket)) {
 	s.handlers[event] = handler
 }
 
-// Broadcast envia uma mensagem para todos os clientes conectados.
-func (s *Server) Broadcast(event string, msg proto.Message) {
+// SetAuthenticator exige um token válido no handshake; sem ele o upgrade é recusado com 401.
+func (s *Server) SetAuthenticator(auth Authenticator) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.auth = auth
+}
 
+// Use adiciona middlewares globais, executados antes dos middlewares de cada socket.
+func (s *Server) Use(middleware ...Middleware) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.middlewares = append(s.middlewares, middleware...)
+}
+
+// OnWithAck registra, para todos os sockets, um handler que responde a EmitWithAck.
+func (s *Server) OnWithAck(event string, handler AckHandler) {
+	s.ackHandlers[event] = handler
+}
+
+// Of retorna o namespace com o nome informado, criando-o se necessário.
+func (s *Server) Of(name string) *Namespace {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = newNamespace(name)
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+// To seleciona os sockets das salas informadas no namespace padrão.
+func (s *Server) To(rooms ...string) *BroadcastOperator {
+	return s.Of(DefaultNamespace).To(rooms...)
+}
+
+// Except seleciona todos os sockets do namespace padrão, exceto os das salas informadas.
+func (s *Server) Except(rooms ...string) *BroadcastOperator {
+	return s.Of(DefaultNamespace).Except(rooms...)
+}
+
+// Broadcast envia uma mensagem para todos os clientes conectados.
+func (s *Server) Broadcast(event string, msg proto.Message, opts ...EmitOption) {
+	s.lock.Lock()
+	clients := make([]*Socket, 0, len(s.clients))
 	for _, client := range s.clients {
-		if err := client.Emit(event, msg); err != nil {
-			log.Printf("Erro ao enviar mensagem para %s: %v\n", client.ID, err)
+		clients = append(clients, client)
+	}
+	s.lock.Unlock()
+
+	for _, client := range clients {
+		if err := client.Emit(event, msg, opts...); err != nil {
+			s.reportError(client, fmt.Errorf("erro ao enviar mensagem para %s: %w", client.ID, err))
 		}
 	}
 }
 
 // ServeHTTP implementa o handler HTTP que fará o upgrade para WebSocket.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	nsName := r.URL.Query().Get(NamespaceQueryParam)
+	if nsName == "" {
+		nsName = DefaultNamespace
+	}
+
+	s.lock.Lock()
+	if s.shuttingDown {
+		s.lock.Unlock()
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	ns, ok := s.namespaces[nsName]
+	auth := s.auth
+	s.active.Add(1)
+	s.lock.Unlock()
+	defer s.active.Done()
+
+	if !ok {
+		http.Error(w, "namespace desconhecido", http.StatusNotFound)
+		return
+	}
+
+	var (
+		token          string
+		claims         Claims
+		responseHeader http.Header
+	)
+	if auth != nil {
+		var protocol string
+		token, protocol = tokenFromRequest(r)
+		if token == "" {
+			http.Error(w, ErrMissingToken.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var err error
+		claims, err = auth.Authenticate(token)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// O navegador exige que um dos subprotocolos pedidos seja devolvido
+		if protocol != "" {
+			responseHeader = http.Header{"Sec-Websocket-Protocol": {protocol}}
+		}
+	}
+
+	// O subprotocolo da biblioteca tem precedência sobre o do token
+	protocol, protoErr := negotiateSubprotocol(websocket.Subprotocols(r))
+	if protocol != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		log.Println("Erro ao fazer upgrade da conexão:", err)
+		s.reportError(nil, fmt.Errorf("erro ao fazer upgrade da conexão: %w", err))
+		return
+	}
+	if protoErr != nil {
+		rejectConn(conn, protoErr)
+		s.reportError(nil, protoErr)
 		return
 	}
 
 	socketID := uuid.New().String()
-	socket := NewSocket(conn, socketID)
+	s.lock.Lock()
+	writerConfig := s.writerConfig
+	heartbeat := s.heartbeat
+	compression := s.compression
+	fragmentation := s.fragmentation
+	sequencing := s.sequencing
+	streaming := s.streaming
+	dedup := s.dedup
+	validation := s.validation
+	dispatchConfig := s.dispatchConfig
+	pool := s.pool
+	if pool != nil {
+		pool.acquire()
+	}
+	middlewares := s.middlewares
+	s.lock.Unlock()
+
+	socket := newSocket(conn, socketID, writerConfig)
+	socket.namespace = ns
+	if auth != nil {
+		socket.setAuth(token, claims)
+	}
+	socket.EnableHeartbeat(heartbeat)
+	socket.SetCompression(compression)
+	socket.SetFragmentation(fragmentation)
+	socket.SetSequencing(sequencing)
+	socket.SetStreaming(streaming)
+	socket.shareDedup(dedup)
+	socket.SetValidation(validation)
+	socket.shareMetrics(s.metrics)
+	socket.SetDispatch(dispatchConfig)
+	if pool != nil {
+		socket.sharePool(pool)
+	}
+	socket.Use(middlewares...)
+	socket.OnError(s.reportError)
+	socket.OnExpired(s.reportExpired)
 
 	s.lock.Lock()
 	s.clients[socketID] = socket
 	s.lock.Unlock()
 
-	// Configura os handlers padrão
+	// Todo socket entra na sala com o próprio ID, permitindo To(id) e Except(id)
+	ns.add(socket)
+	socket.Join(socketID)
+
+	// Configura os handlers padrão e, em seguida, os do namespace
 	for event, handler := range s.handlers {
 		socket.On(event, handler)
 	}
+	for event, handler := range s.ackHandlers {
+		socket.OnWithAck(event, handler)
+	}
+	s.lock.Lock()
+	for event, handler := range s.streamHandlers {
+		socket.OnStream(event, handler)
+	}
+	s.lock.Unlock()
+	ns.lock.RLock()
+	for event, handler := range ns.handlers {
+		socket.On(event, handler)
+	}
+	nsOnConnection := ns.onConnection
+	ns.lock.RUnlock()
 
 	if s.onConnection != nil {
 		go s.onConnection(socket)
 	}
+	if nsOnConnection != nil {
+		go nsOnConnection(socket)
+	}
 
 	socket.Listen()
+	if pool != nil {
+		pool.release()
+	}
 
 	s.lock.Lock()
 	delete(s.clients, socketID)
+	onDisconnect := s.onDisconnect
 	s.lock.Unlock()
+
+	if onDisconnect != nil {
+		onDisconnect(socket, socket.CloseReason())
+	} else {
+		log.Printf("Socket %s desconectado: %v\n", socketID, socket.CloseReason())
+	}
 }
@@ -0,0 +1,192 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrShuttingDown = errors.New("servidor em desligamento")
+
+// ShutdownReason é o motivo enviado no frame de fechamento durante o desligamento.
+const ShutdownReason = "servidor encerrando"
+
+// Shutdown para de aceitar conexões, espera os handlers em andamento terminarem,
+// envia o frame de fechamento para cada socket e força o encerramento quando o
+// prazo do contexto acaba.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.shuttingDown = true
+	sockets := make([]*Socket, 0, len(s.clients))
+	for _, socket := range s.clients {
+		sockets = append(sockets, socket)
+	}
+	s.lock.Unlock()
+
+	// O pool compartilhado para mesmo quando o prazo se esgota
+	defer func() {
+		s.lock.Lock()
+		if s.pool != nil {
+			s.pool.stop()
+		}
+		s.lock.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for _, socket := range sockets {
+		wg.Add(1)
+		go func(socket *Socket) {
+			defer wg.Done()
+			socket.shutdown(ctx, websocket.CloseGoingAway, ShutdownReason)
+		}(socket)
+	}
+	wg.Wait()
+
+	// Espera os ServeHTTP em andamento removerem seus sockets
+	return waitGroupContext(ctx, &s.active)
+}
+
+// Close envia o frame de fechamento com o código e o motivo informados após
+// esvaziar a fila de envio. A conexão termina quando o outro lado responder.
+func (s *Socket) Close(code int, reason string) error {
+	s.writer.stop()
+	s.writer.wait()
+	return s.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+}
+
+// shutdown encerra o socket de forma ordenada dentro do prazo do contexto.
+func (s *Socket) shutdown(ctx context.Context, code int, reason string) {
+	// Novas mensagens deixam de ser despachadas; respostas de ack continuam chegando
+	// Sob o drainLock, nenhum dispatch fica entre a checagem e o inflight.Add
+	s.drainLock.Lock()
+	s.draining.Store(true)
+	s.drainLock.Unlock()
+	s.setCloseReason(ErrShuttingDown)
+
+	if err := waitGroupContext(ctx, &s.inflight); err == nil {
+		closed := make(chan struct{})
+		go func() {
+			s.Close(code, reason)
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+			select {
+			case <-s.done:
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	s.Conn.Close()
+}
+
+// Shutdown fecha a conexão do Client de forma ordenada, aguardando o handler
+// em andamento e a resposta ao frame de fechamento até o prazo do contexto.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.markClosing()
+	conn, writer, _ := c.connection()
+	c.setCloseReason(ErrConnectionClosed)
+	closed := make(chan struct{})
+	go func() {
+		writer.stop()
+		writer.wait()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		close(closed)
+	}()
+
+	var err error
+	select {
+	case <-closed:
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	conn.Close()
+	return err
+}
+
+// Shutdown para o servidor HTTP do socketServer e encerra os sockets ativos.
+func (s *socketServer) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	httpServer := s.httpServer
+	s.lock.Unlock()
+
+	var httpErr error
+	if httpServer != nil {
+		httpErr = httpServer.Shutdown(ctx)
+	}
+	return errors.Join(httpErr, s.Server.Shutdown(ctx))
+}
+
+// Shutdown para de aceitar peers e fecha os links existentes de forma ordenada.
+func (p *Peer) Shutdown(ctx context.Context) error {
+	p.lock.Lock()
+	p.shuttingDown = true
+	httpServer := p.httpServer
+	clients := make([]*Client, 0, len(p.clients))
+	for _, client := range p.clients {
+		clients = append(clients, client)
+	}
+	p.lock.Unlock()
+
+	var httpErr error
+	if httpServer != nil {
+		httpErr = httpServer.Shutdown(ctx)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(clients))
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			if err := client.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("cliente %s: %w", client.ID, err)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	return errors.Join(append([]error{httpErr}, errs...)...)
+}
+
+// waitGroupContext espera o WaitGroup ou o fim do contexto, o que vier primeiro.
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ignoreServerClosed trata o fechamento ordenado do servidor HTTP como sucesso.
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
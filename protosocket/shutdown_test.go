@@ -0,0 +1,204 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// blockingServer responde "chat" apenas depois que release for fechado.
+func blockingServer(release <-chan struct{}) (*Server, <-chan struct{}) {
+	started := make(chan struct{}, 8)
+	server := NewServer()
+	server.OnWithAck("chat", func(data proto.Message, socket *Socket) (proto.Message, error) {
+		started <- struct{}{}
+		<-release
+		return &ChatMessage{Content: "ok"}, nil
+	})
+	return server, started
+}
+
+func TestServerShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// release libera o handler em andamento antes do prazo
+		release bool
+		err     error
+	}{
+		{"espera o handler em andamento", true, nil},
+		{"prazo esgotado", false, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer func() {
+				if !tt.release {
+					close(release)
+				}
+			}()
+			server, started := blockingServer(release)
+			server.SetDispatch(DispatchConfig{Mode: DispatchPool, Workers: 2, QueueSize: 8})
+			server.lock.Lock()
+			pool := server.pool
+			server.lock.Unlock()
+			client, remote := socketPair(t, server)
+
+			inflight := make(chan error, 1)
+			go func() {
+				_, err := client.EmitWithAck(context.Background(), "chat", &ChatMessage{Content: "oi"})
+				inflight <- err
+			}()
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- server.Shutdown(ctx) }()
+			for !remote.draining.Load() {
+				time.Sleep(time.Millisecond)
+			}
+
+			// Mensagens novas são recusadas durante o desligamento
+			_, err := client.EmitWithAck(ctx, "chat", &ChatMessage{Content: "nova"})
+			var remoteErr *RemoteError
+			if !errors.As(err, &remoteErr) || remoteErr.Message != ErrShuttingDown.Error() {
+				t.Errorf("erro = %v, esperado %v", err, ErrShuttingDown)
+			}
+
+			if tt.release {
+				close(release)
+				if err := <-inflight; err != nil {
+					t.Errorf("handler em andamento: %v", err)
+				}
+			}
+			if err := <-shutdown; !errors.Is(err, tt.err) {
+				t.Errorf("Shutdown = %v, esperado %v", err, tt.err)
+			}
+			// O pool compartilhado para inclusive com o prazo esgotado
+			select {
+			case <-pool.done:
+			default:
+				t.Error("pool de handlers continua ativo")
+			}
+			select {
+			case <-remote.done:
+			case <-time.After(time.Second):
+				t.Error("conexão não foi encerrada")
+			}
+		})
+	}
+}
+
+func TestServerRejectsAfterShutdown(t *testing.T) {
+	server := NewServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, esperado %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerShutdownUnderLoad(t *testing.T) {
+	server := NewServer()
+	server.On("chat", func(data proto.Message, socket *Socket) {})
+	client, remote := socketPair(t, server)
+
+	// Mensagens chegam sem parar enquanto o desligamento começa
+	stop := make(chan struct{})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if client.Emit("chat", &ChatMessage{Content: "oi"}) != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-sent
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	select {
+	case <-remote.done:
+	case <-time.After(time.Second):
+		t.Error("conexão não foi encerrada")
+	}
+}
+
+func TestPeerShutdown(t *testing.T) {
+	// silent aceita a conexão e nunca responde ao frame de fechamento
+	hold := make(chan struct{})
+	defer close(hold)
+	silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-hold
+	}))
+	defer silent.Close()
+	responsive := httptest.NewServer(NewServer())
+	defer responsive.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		err  error
+	}{
+		{"cliente encerra no prazo", responsive.URL, nil},
+		{"cliente sem resposta", silent.URL, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := NewPeer(0, "teste", "teste")
+			client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(tt.url, "http"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			peer.track(client)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err = peer.Shutdown(ctx)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Shutdown = %v, esperado %v", err, tt.err)
+			}
+			// O erro identifica o cliente que não encerrou
+			if err != nil && !strings.Contains(err.Error(), client.ID) {
+				t.Errorf("erro %q não identifica o cliente %s", err, client.ID)
+			}
+		})
+	}
+}
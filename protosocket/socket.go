@@ -2,31 +2,101 @@
 package protosocket
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 )
 
+// AckHandler trata um evento e devolve uma resposta para quem chamou EmitWithAck.
+type AckHandler func(data proto.Message, socket *Socket) (proto.Message, error)
+
 // Socket representa uma conexão com um cliente.
 type Socket struct {
-	Conn         *websocket.Conn
-	ID           string
-	events       map[string]func(data proto.Message, socket *Socket)
-	lock         sync.Mutex
-	readTimeout  time.Duration
-	writeTimeout time.Duration
+	Conn           *websocket.Conn
+	ID             string
+	events         map[string]func(data proto.Message, socket *Socket)
+	ackEvents      map[string]AckHandler
+	acks           *ackTracker
+	lock           sync.Mutex
+	writer         *writePump
+	heartbeat      *heartbeat
+	onMissed       func(socket *Socket)
+	inflight       sync.WaitGroup
+	draining       atomic.Bool
+	drainLock      sync.Mutex // Une a checagem de draining ao inflight.Add
+	done           chan struct{}
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	rooms          map[string]struct{}
+	roomLock       sync.Mutex // Serializa Join, Leave e leaveAll
+	closed         bool       // Depois de leaveAll, Join não faz nada
+	namespace      *Namespace
+	ctx            context.Context
+	cancel         context.CancelFunc
+	middlewares    []Middleware
+	claims         Claims
+	sequence       uint64
+	remote         *Hello
+	codec          Codec
+	compression    CompressionConfig
+	dispatchConfig DispatchConfig
+	dispatcher     *dispatcher
+	fragmentation  FragmentConfig
+	retransmit     *retransmitBuffer
+	dedup          *dedupWindow
+	validation     *ValidationRules
+	metrics        *MetricsCollector
+	onExpired      func(*Socket, *Message)
+	reassembly     *reassembler
+	streams        *streamManager
+	streamHandlers map[string]func(stream *Stream, socket *Socket)
+	closeReason    error
+	onError        func(socket *Socket, err error)
 }
 
 // NewSocket cria um novo Socket com o ID fornecido.
 func NewSocket(conn *websocket.Conn, id string) *Socket {
-	return &Socket{
-		Conn:   conn,
-		ID:     id,
-		events: make(map[string]func(data proto.Message, socket *Socket)),
+	return newSocket(conn, id, DefaultWriterConfig())
+}
+
+func newSocket(conn *websocket.Conn, id string, writerConfig WriterConfig) *Socket {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Socket{
+		Conn:           conn,
+		ID:             id,
+		events:         make(map[string]func(data proto.Message, socket *Socket)),
+		ackEvents:      make(map[string]AckHandler),
+		acks:           newAckTracker(),
+		streamHandlers: make(map[string]func(stream *Stream, socket *Socket)),
+		rooms:          make(map[string]struct{}),
+		codec:          codecFor(conn.Subprotocol()),
+		compression:    DefaultCompressionConfig(),
+		fragmentation:  DefaultFragmentConfig(),
+		retransmit:     newRetransmitBuffer(DefaultSequencerConfig().RetransmitBuffer),
+		dedup:          newDedupWindow(DefaultDeliveryConfig()),
+		metrics:        NewMetricsCollector(),
+		dispatchConfig: DefaultDispatchConfig(),
+		writeTimeout:   writerConfig.WriteTimeout,
+		done:           make(chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
+	s.writer = newWritePump(conn, writerConfig, func(err error) {
+		s.setCloseReason(err)
+		s.reportError(fmt.Errorf("erro de escrita: %w", err))
+	})
+	s.writer.onExpired = s.reportExpired
+	s.reassembly = newReassembler(s.codec, s.reportError)
+	s.streams = newStreamManager(ctx, id, s.send, s.acceptStream)
+	s.sendHello()
+	return s
 }
 
 // On registra um handler para um evento específico.
@@ -36,86 +106,361 @@ func (s *Socket) On(event string, callback func(data proto.Message, socket *Sock
 	s.events[event] = callback
 }
 
+// Use adiciona middlewares executados antes dos handlers deste socket.
+func (s *Socket) Use(middleware ...Middleware) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.middlewares = append(s.middlewares, middleware...)
+}
+
+// Context retorna o contexto da conexão, cancelado quando ela é encerrada.
+func (s *Socket) Context() context.Context {
+	return s.ctx
+}
+
+// Claims retorna as claims obtidas na autenticação do handshake.
+func (s *Socket) Claims() Claims {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.claims
+}
+
+// setAuth guarda o token e as claims no socket e no contexto da conexão,
+// deixando-os disponíveis para os middlewares. Deve ser chamado antes de Listen.
+func (s *Socket) setAuth(token string, claims Claims) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.claims = claims
+	s.ctx = context.WithValue(s.ctx, TokenContextKey, token)
+	s.ctx = context.WithValue(s.ctx, ClaimsContextKey, claims)
+}
+
+// OnWithAck registra um handler cuja resposta é enviada de volta a quem chamou EmitWithAck.
+func (s *Socket) OnWithAck(event string, handler AckHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ackEvents[event] = handler
+}
+
 // Emit envia uma mensagem para o cliente usando protobuf.
-// O parâmetro `data` deve ser uma mensagem protobuf que será empacotada no campo Any.
-func (s *Socket) Emit(event string, data proto.Message) error {
-	// Serializa o dado diretamente para bytes
-	msgData, err := proto.Marshal(data)
+// O parâmetro `data` é serializado no campo data do envelope.
+func (s *Socket) Emit(event string, data proto.Message, opts ...EmitOption) error {
+	msg, err := newEnvelope(event, data, "", 0, opts...)
 	if err != nil {
 		return err
 	}
+	return s.send(sequenced(&s.sequence, msg))
+}
+
+// EmitWithAck envia uma mensagem e aguarda a resposta do handler remoto.
+// O prazo e o cancelamento são controlados pelo contexto.
+func (s *Socket) EmitWithAck(ctx context.Context, event string, data proto.Message, opts ...EmitOption) (proto.Message, error) {
+	msg, err := newEnvelope(event, data, "", 0, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	id, replyCh, err := s.acks.register()
+	if err != nil {
+		return nil, err
+	}
+	defer s.acks.cancel(id)
 
-	// Cria a mensagem protobuf
-	msg := &Message{
-		Event: event,
-		Data:  msgData, // Agora usa []byte diretamente
+	msg.AckId = id
+	if err := s.send(sequenced(&s.sequence, msg)); err != nil {
+		return nil, err
 	}
 
-	// Serializa a mensagem para []byte
-	b, err := proto.Marshal(msg)
+	select {
+	case reply := <-replyCh:
+		return reply.decode()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send serializa o envelope e o coloca na fila de escrita da conexão.
+func (s *Socket) send(msg *Message) error {
+	if s.dropExpired(msg) {
+		return ErrMessageExpired
+	}
+	s.retransmit.store(msg)
+	s.lock.Lock()
+	compression, fragmentation, remote := s.compression, s.fragmentation, s.remote
+	s.lock.Unlock()
+	if err := compressEnvelope(msg, compression, remote); err != nil {
+		return err
+	}
+
+	b, fragments, err := encodeFrames(msg, s.codec, fragmentation, remote)
 	if err != nil {
 		return err
 	}
+	return s.writer.enqueueEnvelope(s.codec.FrameType(), b, fragments, msg)
+}
+
+// reply envia a resposta de um EmitWithAck recebido.
+func (s *Socket) reply(ackID string, data proto.Message, handlerErr error) {
+	if err := s.send(newReply(ackID, "", data, handlerErr)); err != nil {
+		s.reportError(fmt.Errorf("erro ao enviar ack %s: %w", ackID, err))
+	}
+}
+
+// EnableHeartbeat passa a enviar pings periódicos e a encerrar a conexão quando
+// os pongs param de chegar. Deve ser chamado antes de Listen.
+func (s *Socket) EnableHeartbeat(config HeartbeatConfig) {
+	if config.Interval <= 0 {
+		return
+	}
 
-	return s.Conn.WriteMessage(websocket.BinaryMessage, b)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.heartbeat != nil {
+		s.heartbeat.stop()
+	}
+	s.heartbeat = newHeartbeat(s.Conn, config, func() {
+		log.Printf("Heartbeat perdido no socket %s\n", s.ID)
+		s.setCloseReason(ErrHeartbeatTimeout)
+		s.lock.Lock()
+		callback := s.onMissed
+		s.lock.Unlock()
+		if callback != nil {
+			callback(s)
+		}
+	})
+	go s.heartbeat.run()
+}
+
+// OnHeartbeatMissed registra um callback chamado quando o cliente para de responder aos pings.
+func (s *Socket) OnHeartbeatMissed(callback func(socket *Socket)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onMissed = callback
+}
+
+// RTT retorna o último tempo de ida e volta medido pelo heartbeat.
+func (s *Socket) RTT() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.heartbeat == nil {
+		return 0
+	}
+	return s.heartbeat.RTT()
 }
 
 // SetTimeouts define os tempos limite para leitura e escrita.
+// Com o heartbeat ativo, o prazo de leitura passa a ser controlado pelos pongs.
 func (s *Socket) SetTimeouts(read, write time.Duration) {
 	s.readTimeout = read
 	s.writeTimeout = write
+	s.writer.setTimeout(write)
 }
 
 // Listen fica em loop lendo mensagens do cliente e invoca os handlers registrados.
 func (s *Socket) Listen() {
+	defer close(s.done)
 	defer s.Conn.Close()
+	defer s.cancel()
+	defer func() {
+		// Envia o que ainda estiver na fila antes de fechar a conexão
+		s.writer.stop()
+		s.writer.wait()
+	}()
+	defer s.leaveAll()
+	defer s.acks.failAll(ErrConnectionClosed)
+	defer s.streams.failAll(ErrConnectionClosed)
+	defer s.reassembly.stop()
+	defer func() {
+		s.lock.Lock()
+		if s.heartbeat != nil {
+			s.heartbeat.stop()
+		}
+		s.lock.Unlock()
+	}()
+
+	s.lock.Lock()
+	hasHeartbeat := s.heartbeat != nil
+	// Sockets do servidor em DispatchPool já chegam com o pool compartilhado
+	if s.dispatcher == nil {
+		s.dispatcher = newDispatcher(s.dispatchConfig)
+		defer s.dispatcher.stop()
+	}
+	s.lock.Unlock()
+
 	for {
-		if s.readTimeout > 0 {
+		if s.readTimeout > 0 && !hasHeartbeat {
 			s.Conn.SetReadDeadline(time.Now().Add(s.readTimeout))
 		}
 
-		msgType, b, err := s.Conn.ReadMessage()
+		s.lock.Lock()
+		limit := s.compression.MaxDecompressedSize
+		s.lock.Unlock()
+
+		msgType, b, err := readMessage(s.Conn, limit)
+		if errors.Is(err, ErrDecompressionLimit) {
+			s.setCloseReason(err)
+			s.Close(websocket.CloseMessageTooBig, err.Error())
+			break
+		}
 		if err != nil {
-			log.Println("Erro ao ler mensagem:", err)
+			if hasHeartbeat {
+				s.heartbeat.handleReadError(err)
+			}
+			s.setCloseReason(err)
 			break
 		}
 
-		if msgType != websocket.BinaryMessage {
-			log.Println("Mensagem recebida não é binária; ignorando")
+		if msgType != s.codec.FrameType() {
+			log.Printf("Frame do tipo %d fora do codec negociado; ignorando\n", msgType)
 			continue
 		}
 
-		var wrapper Message
-		if err := proto.Unmarshal(b, &wrapper); err != nil {
-			log.Println("Erro ao desserializar wrapper:", err)
+		wrapper, err := s.codec.Decode(b)
+		if err == nil {
+			wrapper, err = s.reassembly.receive(wrapper, limit)
+		}
+		if err != nil {
+			s.reportError(fmt.Errorf("erro ao desserializar envelope: %w", err))
+			continue
+		}
+		// Fragmento de uma mensagem ainda incompleta
+		if wrapper == nil {
+			continue
+		}
+		if err := decompressEnvelope(wrapper, limit); err != nil {
+			if errors.Is(err, ErrDecompressionLimit) {
+				s.setCloseReason(err)
+				s.Close(websocket.CloseMessageTooBig, err.Error())
+				break
+			}
+			s.reportError(err)
+			continue
+		}
+
+		if wrapper.Event == HelloEvent {
+			s.handleHello(wrapper)
+			continue
+		}
+
+		if wrapper.Event == NackEvent {
+			s.handleNack(wrapper)
+			continue
+		}
+
+		if wrapper.StreamId != "" {
+			if err := s.streams.handle(wrapper); err != nil {
+				s.reportError(err)
+			}
+			continue
+		}
+
+		// Respostas de EmitWithAck não passam pelos handlers
+		if wrapper.IsReply {
+			s.acks.resolve(wrapper.AckId, ackReply{
+				data:     wrapper.Data,
+				dataType: wrapper.DataType,
+				err:      wrapper.Error,
+			})
+			continue
+		}
+
+		meta := wrapper.metadata()
+
+		// Mensagens vencidas no caminho não chegam aos handlers
+		if s.dropExpired(wrapper) {
+			s.confirmDelivery(wrapper)
+			if wrapper.AckId != "" {
+				s.dispatch(meta, func() { s.reply(wrapper.AckId, nil, ErrMessageExpired) })
+			}
+			continue
+		}
+
+		// Durante o desligamento nenhuma mensagem nova é despachada
+		if s.draining.Load() {
+			if wrapper.AckId != "" {
+				s.reply(wrapper.AckId, nil, ErrShuttingDown)
+			}
+			continue
+		}
+
+		// Envelopes com entrega garantida chegam aos handlers uma vez só
+		if !s.acceptDelivery(wrapper) {
+			continue
+		}
+
+		// Mensagens fora das regras voltam ao remetente sem chegar aos handlers
+		s.lock.Lock()
+		validation := s.validation
+		s.lock.Unlock()
+
+		if invalid := validation.checkEnvelope(wrapper); invalid != nil {
+			s.reject(meta, wrapper, invalid)
 			continue
 		}
 
 		// Desserializa para o tipo correto baseado no evento
-		var msg proto.Message
-		switch wrapper.Event {
-		case "chat":
-			msg = &ChatMessage{}
-		case "binary":
-			msg = &BinaryMessage{}
-		default:
-			log.Printf("Evento desconhecido: %s\n", wrapper.Event)
+		msg, err := DefaultRegistry.Decode(wrapper.Event, wrapper.DataType, wrapper.Data)
+		if err != nil {
+			s.reportError(fmt.Errorf("erro ao desserializar mensagem concreta: %w", err))
+			s.confirmDelivery(wrapper)
+			if wrapper.AckId != "" {
+				s.dispatch(meta, func() { s.reply(wrapper.AckId, nil, err) })
+			}
 			continue
 		}
 
-		if err := proto.Unmarshal(wrapper.Data, msg); err != nil {
-			log.Println("Erro ao desserializar mensagem concreta:", err)
+		if invalid := validation.checkMessage(wrapper, msg); invalid != nil {
+			s.reject(meta, wrapper, invalid)
 			continue
 		}
 
 		s.lock.Lock()
 		handler, exists := s.events[wrapper.Event]
+		ackHandler, ackExists := s.ackEvents[wrapper.Event]
+		middlewares := s.middlewares
 		s.lock.Unlock()
 
-		if exists && handler != nil {
-			go handler(msg, s)
-		} else {
+		ackID := wrapper.AckId
+		if !exists && !ackExists {
 			log.Printf("Nenhum handler registrado para '%s'\n", wrapper.Event)
+			s.confirmDelivery(wrapper)
+			if ackID != "" {
+				s.dispatch(meta, func() { s.reply(ackID, nil, ErrNoHandler) })
+			}
+			continue
+		}
+
+		// O handler final guarda a resposta para que ela volte pelo ack
+		var resp proto.Message
+		final := func(ctx context.Context, msg proto.Message) error {
+			if ackExists && (ackID != "" || !exists) {
+				var err error
+				resp, err = ackHandler(msg, s)
+				return err
+			}
+			handler(msg, s)
+			return nil
+		}
+
+		ctx := context.WithValue(s.ctx, socketContextKey, s)
+		ctx = context.WithValue(ctx, metadataContextKey, meta)
+		handle := chain(middlewares, final)
+
+		dispatched := s.dispatch(meta, func() {
+			err := handle(ctx, msg)
+			if err != nil {
+				s.reportError(fmt.Errorf("erro no handler de '%s': %w", wrapper.Event, err))
+			}
+			// Handlers sem resposta confirmam apenas o processamento
+			if ackID != "" {
+				s.reply(ackID, resp, err)
+			}
+			s.confirmDelivery(wrapper)
+		})
+		if !dispatched {
+			// O handler não vai rodar: o reenvio do remetente deve passar
+			s.forgetDelivery(wrapper)
 		}
 	}
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -17,6 +18,9 @@ type Message struct {
 	Timestamp time.Time
 }
 
+// ErrNotFound é devolvido por GetByID quando não há mensagem com o ID.
+var ErrNotFound = errors.New("mensagem não encontrada")
+
 type MessageStore interface {
 	Save(ctx context.Context, msg *types.Message) error
 	GetByID(ctx context.Context, id string) (*types.Message, error)
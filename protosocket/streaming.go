@@ -2,31 +2,547 @@ package protosocket
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
 
+	"github.com/google/uuid"
 	"google.golang.org/protobuf/proto"
 )
 
+// DefaultStreamWindow é quantas mensagens um stream aceita sem consumir.
+const DefaultStreamWindow = 64
+
+var (
+	ErrStreamClosed      = errors.New("stream fechado para envio")
+	ErrStreamCanceled    = errors.New("stream cancelado")
+	ErrStreamFlowControl = errors.New("stream excedeu os créditos concedidos")
+	ErrStreamExists      = errors.New("stream já aberto com esse ID")
+	ErrStreamLimit       = errors.New("limite de streams simultâneos atingido")
+)
+
+// StreamConfig limita os streams abertos pelo outro lado de uma conexão.
+type StreamConfig struct {
+	// MaxRemoteStreams é quantos streams do outro lado podem estar abertos ao
+	// mesmo tempo; zero não limita.
+	MaxRemoteStreams int
+}
+
+// DefaultStreamConfig aceita até 100 streams simultâneos do outro lado.
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		MaxRemoteStreams: 100,
+	}
+}
+
+// StreamOption ajusta um stream antes de ele ser aberto.
+type StreamOption func(*Stream)
+
+// WithStreamWindow define quantas mensagens o outro lado pode enviar antes de
+// receber novos créditos.
+func WithStreamWindow(window uint32) StreamOption {
+	return func(s *Stream) {
+		if window > 0 {
+			s.window = window
+		}
+	}
+}
+
+// Stream é um canal bidirecional multiplexado na conexão, com controle de
+// fluxo por créditos: cada lado só envia o que o outro concedeu.
 type Stream struct {
-	ctx     context.Context
-	socket  *Socket
+	id      string
 	event   string
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	stop    func() bool
+	manager *streamManager
 	msgType proto.Message
+	// remote indica que o stream foi aberto pelo outro lado
+	remote bool
+
+	lock         sync.Mutex
+	changed      chan struct{}
+	buffer       []*Message
+	window       uint32
+	consumed     uint32
+	credit       uint32
+	sendClosed   bool
+	remoteClosed bool
+	err          error
+}
+
+// ID retorna o identificador do stream, igual nos dois lados.
+func (s *Stream) ID() string {
+	return s.id
 }
 
+// Event retorna o evento usado na abertura do stream.
+func (s *Stream) Event() string {
+	return s.event
+}
+
+// Context retorna o contexto do stream, cancelado quando ele termina.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send envia uma mensagem, esperando créditos do outro lado se necessário.
 func (s *Stream) Send(msg proto.Message) error {
-	return s.socket.Emit(s.event, msg)
+	for {
+		s.lock.Lock()
+		if s.err != nil {
+			err := s.err
+			s.lock.Unlock()
+			return err
+		}
+		if s.sendClosed {
+			s.lock.Unlock()
+			return ErrStreamClosed
+		}
+		if s.credit > 0 {
+			s.credit--
+			s.lock.Unlock()
+			break
+		}
+		changed := s.changed
+		s.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-s.ctx.Done():
+			return context.Cause(s.ctx)
+		}
+	}
+
+	frame, err := newEnvelope(s.event, msg, s.manager.senderID, 0)
+	if err != nil {
+		return err
+	}
+	frame.StreamId = s.id
+	frame.StreamFrame = StreamFrame_STREAM_DATA
+	return s.manager.send(frame)
 }
 
-func (s *Stream) Recv() (proto.Message, error) {
-	// Implementar lógica de recebimento de stream
-	return nil, nil
+// Recv aguarda a próxima mensagem. Retorna io.EOF quando o outro lado
+// chamou CloseSend e tudo já foi lido.
+func (s *Stream) Recv(ctx context.Context) (proto.Message, error) {
+	for {
+		s.lock.Lock()
+		if len(s.buffer) > 0 {
+			frame := s.buffer[0]
+			s.buffer = s.buffer[1:]
+			grant := s.consume()
+			s.lock.Unlock()
+
+			if grant > 0 {
+				s.sendFrame(StreamFrame_STREAM_CREDIT, grant, "")
+			}
+			return s.decode(frame)
+		}
+		if s.err != nil {
+			err := s.err
+			s.lock.Unlock()
+			return nil, err
+		}
+		if s.remoteClosed {
+			s.lock.Unlock()
+			return nil, io.EOF
+		}
+		changed := s.changed
+		s.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 }
 
-func (s *Socket) CreateStream(ctx context.Context, event string, msgType proto.Message) *Stream {
-	return &Stream{
-		ctx:     ctx,
-		socket:  s,
+// CloseSend avisa o outro lado que não haverá mais envios; Recv continua funcionando.
+func (s *Stream) CloseSend() error {
+	s.lock.Lock()
+	if s.err != nil || s.sendClosed {
+		err := s.err
+		s.lock.Unlock()
+		return err
+	}
+	s.sendClosed = true
+	s.signal()
+	done := s.remoteClosed
+	s.lock.Unlock()
+
+	err := s.sendFrame(StreamFrame_STREAM_CLOSE_SEND, 0, "")
+	if done {
+		s.finish()
+	}
+	return err
+}
+
+// Cancel encerra o stream nos dois sentidos e avisa o outro lado.
+func (s *Stream) Cancel() {
+	s.fail(ErrStreamCanceled, true)
+}
+
+// consume contabiliza uma mensagem lida e devolve os créditos a conceder.
+// Deve ser chamado com o lock.
+func (s *Stream) consume() uint32 {
+	if s.remoteClosed {
+		return 0
+	}
+	s.consumed++
+	if s.consumed < max(s.window/2, 1) {
+		return 0
+	}
+	grant := s.consumed
+	s.consumed = 0
+	return grant
+}
+
+func (s *Stream) decode(frame *Message) (proto.Message, error) {
+	if frame.DataType == "" && s.msgType != nil {
+		msg := s.msgType.ProtoReflect().New().Interface()
+		return msg, proto.Unmarshal(frame.Data, msg)
+	}
+	return DefaultRegistry.Decode("", frame.DataType, frame.Data)
+}
+
+func (s *Stream) sendFrame(kind StreamFrame, credit uint32, reason string) error {
+	frame, err := newEnvelope(s.event, nil, s.manager.senderID, 0)
+	if err != nil {
+		return err
+	}
+	frame.StreamId = s.id
+	frame.StreamFrame = kind
+	frame.Credit = credit
+	frame.Error = reason
+	return s.manager.send(frame)
+}
+
+// signal acorda quem espera por dados ou créditos. Deve ser chamado com o lock.
+func (s *Stream) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// push guarda uma mensagem recebida, recusando quem ignora os créditos.
+func (s *Stream) push(frame *Message) {
+	s.lock.Lock()
+	if s.err != nil || s.remoteClosed {
+		s.lock.Unlock()
+		return
+	}
+	if uint32(len(s.buffer)) >= s.window {
+		s.lock.Unlock()
+		s.fail(ErrStreamFlowControl, true)
+		return
+	}
+	s.buffer = append(s.buffer, frame)
+	s.signal()
+	s.lock.Unlock()
+}
+
+func (s *Stream) addCredit(credit uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credit += credit
+	s.signal()
+}
+
+func (s *Stream) closeRemote() {
+	s.lock.Lock()
+	s.remoteClosed = true
+	s.signal()
+	done := s.sendClosed
+	s.lock.Unlock()
+
+	if done {
+		s.finish()
+	}
+}
+
+// fail encerra o stream com erro; notify avisa o outro lado.
+func (s *Stream) fail(err error, notify bool) {
+	s.lock.Lock()
+	if s.err != nil {
+		s.lock.Unlock()
+		return
+	}
+	s.err = err
+	s.signal()
+	s.lock.Unlock()
+
+	s.stop()
+	s.cancel(err)
+	s.manager.remove(s)
+	if notify {
+		s.sendFrame(StreamFrame_STREAM_CANCEL, 0, err.Error())
+	}
+}
+
+// finish libera o stream depois que os dois lados fecharam o envio.
+func (s *Stream) finish() {
+	s.stop()
+	s.cancel(io.EOF)
+	s.manager.remove(s)
+}
+
+// streamManager roteia os envelopes de stream de uma conexão.
+type streamManager struct {
+	lock     sync.Mutex
+	streams  map[string]*Stream
+	remote   int // Streams abertos pelo outro lado
+	config   StreamConfig
+	closed   error
+	parent   context.Context
+	senderID string
+	send     func(*Message) error
+	// accept inicia o handler de um stream aberto pelo outro lado
+	accept func(*Stream) bool
+}
+
+func newStreamManager(parent context.Context, senderID string, send func(*Message) error, accept func(*Stream) bool) *streamManager {
+	return &streamManager{
+		streams:  make(map[string]*Stream),
+		config:   DefaultStreamConfig(),
+		parent:   parent,
+		senderID: senderID,
+		send:     send,
+		accept:   accept,
+	}
+}
+
+func (m *streamManager) newStream(ctx context.Context, id, event string) *Stream {
+	s := &Stream{
+		id:      id,
 		event:   event,
-		msgType: msgType,
+		manager: m,
+		changed: make(chan struct{}),
+		window:  DefaultStreamWindow,
+	}
+	s.ctx, s.cancel = context.WithCancelCause(ctx)
+	return s
+}
+
+func (m *streamManager) setConfig(config StreamConfig) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.config = config
+}
+
+// register passa a rotear os envelopes do stream e propaga o cancelamento do
+// contexto para o outro lado. Streams do outro lado não substituem um já
+// aberto e respeitam MaxRemoteStreams.
+func (m *streamManager) register(s *Stream) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed != nil {
+		return m.closed
+	}
+	if _, ok := m.streams[s.id]; ok {
+		return fmt.Errorf("%w: %s", ErrStreamExists, s.id)
+	}
+	if s.remote {
+		if m.config.MaxRemoteStreams > 0 && m.remote >= m.config.MaxRemoteStreams {
+			return fmt.Errorf("%w: %d", ErrStreamLimit, m.config.MaxRemoteStreams)
+		}
+		m.remote++
+	}
+	m.streams[s.id] = s
+	s.stop = context.AfterFunc(s.ctx, func() {
+		s.fail(fmt.Errorf("%w: %w", ErrStreamCanceled, context.Cause(s.ctx)), true)
+	})
+	return nil
+}
+
+func (m *streamManager) open(ctx context.Context, event string, msgType proto.Message, opts ...StreamOption) (*Stream, error) {
+	s := m.newStream(ctx, uuid.New().String(), event)
+	s.msgType = msgType
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := m.register(s); err != nil {
+		return nil, err
+	}
+
+	// A abertura já concede ao outro lado a janela de recepção
+	if err := s.sendFrame(StreamFrame_STREAM_OPEN, s.window, ""); err != nil {
+		s.fail(err, false)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *streamManager) get(id string) *Stream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.streams[id]
+}
+
+// remove para de rotear o stream, se ele ainda for o registrado com o seu ID.
+func (m *streamManager) remove(s *Stream) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.streams[s.id] != s {
+		return
+	}
+	if s.remote {
+		m.remote--
+	}
+	delete(m.streams, s.id)
+}
+
+// handle aplica um envelope de stream recebido. O erro indica uma abertura
+// recusada pelo outro lado.
+func (m *streamManager) handle(frame *Message) error {
+	if frame.StreamFrame == StreamFrame_STREAM_OPEN {
+		return m.accepted(frame)
+	}
+
+	s := m.get(frame.StreamId)
+	if s == nil {
+		// Envelopes tardios de um stream já encerrado
+		return nil
+	}
+
+	switch frame.StreamFrame {
+	case StreamFrame_STREAM_DATA:
+		s.push(frame)
+	case StreamFrame_STREAM_CLOSE_SEND:
+		s.closeRemote()
+	case StreamFrame_STREAM_CREDIT:
+		s.addCredit(frame.Credit)
+	case StreamFrame_STREAM_CANCEL:
+		s.fail(fmt.Errorf("%w: %w", ErrStreamCanceled, &RemoteError{Message: frame.Error}), false)
+	}
+	return nil
+}
+
+// accepted cria o lado receptor de um stream aberto pelo outro lado.
+func (m *streamManager) accepted(frame *Message) error {
+	s := m.newStream(m.parent, frame.StreamId, frame.Event)
+	s.credit = frame.Credit
+	s.remote = true
+	if err := m.register(s); err != nil {
+		s.cancel(err)
+		// Um ID repetido não é cancelado, para não derrubar o stream que já o usa
+		if errors.Is(err, ErrStreamLimit) {
+			s.sendFrame(StreamFrame_STREAM_CANCEL, 0, err.Error())
+		}
+		if errors.Is(err, ErrStreamExists) || errors.Is(err, ErrStreamLimit) {
+			return err
+		}
+		return nil
 	}
+
+	if !m.accept(s) {
+		s.fail(ErrNoHandler, true)
+		return nil
+	}
+	// Concede ao outro lado a janela de recepção deste lado
+	s.sendFrame(StreamFrame_STREAM_CREDIT, s.window, "")
+	return nil
+}
+
+// reopen volta a aceitar streams depois de uma reconexão.
+func (m *streamManager) reopen() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = nil
+}
+
+// failAll encerra todos os streams quando a conexão cai.
+func (m *streamManager) failAll(err error) {
+	m.lock.Lock()
+	m.closed = err
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, s := range m.streams {
+		streams = append(streams, s)
+	}
+	m.lock.Unlock()
+
+	for _, s := range streams {
+		s.fail(err, false)
+	}
+}
+
+// OnStream registra o handler dos streams abertos pelo cliente com o evento.
+// O handler roda em uma goroutine própria e pode durar o quanto o stream durar.
+func (s *Socket) OnStream(event string, handler func(stream *Stream, socket *Socket)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streamHandlers[event] = handler
+}
+
+// OpenStream abre um stream com o cliente. Cancelar ctx cancela o stream dos
+// dois lados.
+func (s *Socket) OpenStream(ctx context.Context, event string, opts ...StreamOption) (*Stream, error) {
+	return s.streams.open(ctx, event, nil, opts...)
+}
+
+// CreateStream abre um stream decodificando as mensagens sem tipo como msgType.
+//
+// Deprecated: use OpenStream, que informa o erro de abertura.
+func (s *Socket) CreateStream(ctx context.Context, event string, msgType proto.Message) *Stream {
+	stream, err := s.streams.open(ctx, event, msgType)
+	if err != nil {
+		stream = s.streams.newStream(ctx, "", event)
+		stream.stop = func() bool { return false }
+		stream.err = err
+	}
+	return stream
+}
+
+func (s *Socket) acceptStream(stream *Stream) bool {
+	s.lock.Lock()
+	handler, ok := s.streamHandlers[stream.event]
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	go handler(stream, s)
+	return true
+}
+
+// OnStream registra o handler dos streams abertos pelo servidor com o evento.
+func (c *Client) OnStream(event string, handler func(stream *Stream, client *Client)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.streamHandlers[event] = handler
+}
+
+// OpenStream abre um stream com o servidor. Cancelar ctx cancela o stream dos
+// dois lados.
+func (c *Client) OpenStream(ctx context.Context, event string, opts ...StreamOption) (*Stream, error) {
+	return c.streams.open(ctx, event, nil, opts...)
+}
+
+func (c *Client) acceptStream(stream *Stream) bool {
+	c.lock.Lock()
+	handler, ok := c.streamHandlers[stream.event]
+	c.lock.Unlock()
+	if !ok {
+		return false
+	}
+	go handler(stream, c)
+	return true
+}
+
+// SetStreaming define os limites dos streams abertos pelo cliente.
+func (s *Socket) SetStreaming(config StreamConfig) {
+	s.streams.setConfig(config)
+}
+
+// SetStreaming define os limites dos streams dos próximos sockets.
+func (s *Server) SetStreaming(config StreamConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streaming = config
+}
+
+// SetStreaming define os limites dos streams abertos pelo servidor.
+func (c *Client) SetStreaming(config StreamConfig) {
+	c.streams.setConfig(config)
 }
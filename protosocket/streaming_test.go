@@ -0,0 +1,294 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamPair liga dois streamManager como se fossem as duas pontas de uma
+// conexão: o que um envia o outro recebe, na ordem, em outra goroutine.
+type streamPair struct {
+	local, remote *streamManager
+	accepted      chan *Stream
+}
+
+func newStreamPair(t *testing.T) *streamPair {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	p := &streamPair{accepted: make(chan *Stream, 16)}
+	link := func(to **streamManager) func(*Message) error {
+		frames := make(chan *Message, 1024)
+		go func() {
+			for {
+				select {
+				case frame := <-frames:
+					(*to).handle(frame)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return func(frame *Message) error {
+			select {
+			case frames <- frame:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	p.local = newStreamManager(ctx, "local", link(&p.remote), func(*Stream) bool { return false })
+	p.remote = newStreamManager(ctx, "remote", link(&p.local), func(s *Stream) bool {
+		p.accepted <- s
+		return true
+	})
+	return p
+}
+
+func (p *streamPair) open(t *testing.T, window uint32) (*Stream, *Stream) {
+	t.Helper()
+	local, err := p.local.open(context.Background(), "stream", nil, WithStreamWindow(window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case remote := <-p.accepted:
+		return local, remote
+	case <-time.After(time.Second):
+		t.Fatal("stream não foi aceito")
+		return nil, nil
+	}
+}
+
+func TestStreamCredit(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   uint32
+		messages int
+	}{
+		{"janela de um", 1, 10},
+		{"janela pequena", 4, 50},
+		{"janela maior que o envio", 64, 10},
+		{"janela ímpar", 5, 33},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newStreamPair(t)
+			local, remote := p.open(t, tt.window)
+
+			// O remoto envia para o local, que abriu com a janela do caso
+			sent := make(chan error, 1)
+			go func() {
+				for i := 0; i < tt.messages; i++ {
+					if err := remote.Send(&ChatMessage{Content: fmt.Sprint(i)}); err != nil {
+						sent <- err
+						return
+					}
+				}
+				sent <- remote.CloseSend()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for i := 0; ; i++ {
+				msg, err := local.Recv(ctx)
+				if err == io.EOF {
+					if i != tt.messages {
+						t.Fatalf("recebidas %d mensagens, esperado %d", i, tt.messages)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("mensagem %d: %v", i, err)
+				}
+				if got := msg.(*ChatMessage).Content; got != fmt.Sprint(i) {
+					t.Fatalf("mensagem %d fora de ordem: %s", i, got)
+				}
+			}
+			if err := <-sent; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStreamSendBlocksWithoutCredit(t *testing.T) {
+	p := newStreamPair(t)
+	local, remote := p.open(t, 4)
+
+	for i := 0; i < 4; i++ {
+		if err := remote.Send(&ChatMessage{Content: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := make(chan error, 1)
+	go func() { sent <- remote.Send(&ChatMessage{Content: "4"}) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("envio além da janela não esperou créditos: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Consumir metade da janela devolve os créditos
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := local.Recv(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("envio não continuou depois dos créditos")
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		window uint32
+		pushes int
+		err    error
+	}{
+		{"dentro da janela", 4, 4, nil},
+		{"um além da janela", 4, 5, ErrStreamFlowControl},
+		{"janela de um", 1, 2, ErrStreamFlowControl},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var sent []*Message
+			m := newStreamManager(context.Background(), "local", func(frame *Message) error {
+				lock.Lock()
+				sent = append(sent, frame)
+				lock.Unlock()
+				return nil
+			}, nil)
+
+			s := m.newStream(context.Background(), "s1", "stream")
+			s.window = tt.window
+			if err := m.register(s); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.pushes; i++ {
+				m.handle(&Message{StreamId: "s1", StreamFrame: StreamFrame_STREAM_DATA})
+			}
+
+			s.lock.Lock()
+			err := s.err
+			s.lock.Unlock()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if tt.err == nil {
+				return
+			}
+			if m.get("s1") != nil {
+				t.Error("stream que violou os créditos continua registrado")
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if len(sent) == 0 || sent[len(sent)-1].StreamFrame != StreamFrame_STREAM_CANCEL {
+				t.Error("outro lado não foi avisado do cancelamento")
+			}
+		})
+	}
+}
+
+func TestStreamManagerRemoteOpen(t *testing.T) {
+	openFrame := func(id string) *Message {
+		return &Message{Event: "stream", StreamId: id, StreamFrame: StreamFrame_STREAM_OPEN, Credit: 4}
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		opens   []string
+		err     error
+		cancels int
+		streams int
+	}{
+		{"ids distintos", 10, []string{"a", "b", "c"}, nil, 0, 3},
+		{"id repetido", 10, []string{"a", "a"}, ErrStreamExists, 0, 1},
+		{"limite atingido", 2, []string{"a", "b", "c"}, ErrStreamLimit, 1, 2},
+		{"sem limite", 0, []string{"a", "b", "c", "d"}, nil, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			canceled := 0
+			m := newStreamManager(context.Background(), "local", func(frame *Message) error {
+				lock.Lock()
+				defer lock.Unlock()
+				if frame.StreamFrame == StreamFrame_STREAM_CANCEL {
+					canceled++
+				}
+				return nil
+			}, func(*Stream) bool { return true })
+			m.setConfig(StreamConfig{MaxRemoteStreams: tt.limit})
+
+			first := make(map[string]*Stream)
+			var err error
+			for _, id := range tt.opens {
+				if e := m.handle(openFrame(id)); e != nil {
+					err = e
+				}
+				if _, ok := first[id]; !ok {
+					first[id] = m.get(id)
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+
+			m.lock.Lock()
+			streams, remote := len(m.streams), m.remote
+			m.lock.Unlock()
+			if streams != tt.streams || remote != tt.streams {
+				t.Errorf("streams = %d (remotos %d), esperado %d", streams, remote, tt.streams)
+			}
+			for id, s := range first {
+				if s != nil && m.get(id) != s {
+					t.Errorf("stream %s substituído pela abertura repetida", id)
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if canceled != tt.cancels {
+				t.Errorf("cancelamentos enviados = %d, esperado %d", canceled, tt.cancels)
+			}
+		})
+	}
+}
+
+func TestStreamManagerRemoteLimitReleased(t *testing.T) {
+	m := newStreamManager(context.Background(), "local", func(*Message) error { return nil },
+		func(*Stream) bool { return true })
+	m.setConfig(StreamConfig{MaxRemoteStreams: 1})
+
+	open := &Message{Event: "stream", StreamId: "a", StreamFrame: StreamFrame_STREAM_OPEN}
+	if err := m.handle(open); err != nil {
+		t.Fatal(err)
+	}
+	m.get("a").Cancel()
+
+	open = &Message{Event: "stream", StreamId: "b", StreamFrame: StreamFrame_STREAM_OPEN}
+	if err := m.handle(open); err != nil {
+		t.Fatalf("stream encerrado continua contando no limite: %v", err)
+	}
+}
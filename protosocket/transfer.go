@@ -0,0 +1,566 @@
+package protosocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+// Eventos usados pela transferência de arquivos.
+const (
+	FileOfferEvent = "file.offer"
+	FileChunkEvent = "file.chunk"
+)
+
+var (
+	ErrTransferRejected = errors.New("transferência recusada")
+	ErrTransferPaused   = errors.New("transferência pausada")
+	ErrUnknownTransfer  = errors.New("transferência desconhecida")
+	ErrChunkOutOfOrder  = errors.New("pedaço fora de ordem")
+	ErrChecksumMismatch = errors.New("checksum não confere")
+	ErrTransferIdle     = errors.New("transferência abandonada pelo remetente")
+	ErrChunkTooLarge    = errors.New("pedaço maior que o anunciado na oferta")
+)
+
+// FileTarget é o outro lado de uma transferência; Client e Socket o implementam.
+type FileTarget interface {
+	EmitWithAck(ctx context.Context, event string, msg proto.Message, opts ...EmitOption) (proto.Message, error)
+}
+
+// FileTransferConfig controla o tamanho dos pedaços e as retransmissões.
+type FileTransferConfig struct {
+	ChunkSize int
+	// MaxRetries é quantas vezes um pedaço com checksum inválido é reenviado.
+	MaxRetries int
+	// ChunkTimeout limita a espera pela confirmação de cada pedaço.
+	ChunkTimeout time.Duration
+	// IdleTimeout descarta os recebimentos que ficam esse tempo sem pedaços
+	// novos; é também o prazo para retomá-los depois de uma queda.
+	IdleTimeout time.Duration
+}
+
+// DefaultFileTransferConfig envia pedaços de 256 KiB.
+func DefaultFileTransferConfig() FileTransferConfig {
+	return FileTransferConfig{
+		ChunkSize:    256 << 10,
+		MaxRetries:   3,
+		ChunkTimeout: 30 * time.Second,
+		IdleTimeout:  10 * time.Minute,
+	}
+}
+
+// TransferProgress descreve o andamento de uma transferência.
+type TransferProgress struct {
+	TransferID string
+	Filename   string
+	// Sending é verdadeiro para quem envia o arquivo.
+	Sending    bool
+	Bytes      int64
+	Total      int64
+	Chunks     uint32
+	ChunkCount uint32
+}
+
+// FileTransfers coordena os arquivos enviados e recebidos por uma ponta.
+// Guarda o estado das transferências entre conexões, permitindo retomá-las
+// depois de uma reconexão.
+type FileTransfers struct {
+	config     FileTransferConfig
+	sender     string
+	lock       sync.Mutex
+	incoming   map[string]*incomingFile
+	onOffer    func(offer *FileOffer) (io.Writer, error)
+	onProgress func(progress TransferProgress)
+	onComplete func(offer *FileOffer, err error)
+}
+
+type incomingFile struct {
+	lock     sync.Mutex
+	offer    *FileOffer
+	writer   io.Writer
+	hasher   hash.Hash
+	next     uint32
+	received int64
+	idle     *time.Timer
+}
+
+func NewFileTransfers(config FileTransferConfig) *FileTransfers {
+	defaults := DefaultFileTransferConfig()
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaults.ChunkSize
+	}
+	if config.ChunkTimeout <= 0 {
+		config.ChunkTimeout = defaults.ChunkTimeout
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaults.IdleTimeout
+	}
+	return &FileTransfers{
+		config:   config,
+		incoming: make(map[string]*incomingFile),
+	}
+}
+
+// OnOffer registra quem decide sobre os arquivos oferecidos. O io.Writer
+// devolvido recebe o conteúdo em ordem, sem manter o arquivo em memória;
+// um erro recusa a oferta.
+func (f *FileTransfers) OnOffer(handler func(offer *FileOffer) (io.Writer, error)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.onOffer = handler
+}
+
+// OnProgress registra um callback chamado a cada pedaço confirmado, nos dois sentidos.
+func (f *FileTransfers) OnProgress(handler func(progress TransferProgress)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.onProgress = handler
+}
+
+// OnComplete registra um callback chamado quando um arquivo recebido termina,
+// com o erro da verificação final, se houver.
+func (f *FileTransfers) OnComplete(handler func(offer *FileOffer, err error)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.onComplete = handler
+}
+
+func (f *FileTransfers) progress(p TransferProgress) {
+	f.lock.Lock()
+	callback := f.onProgress
+	f.lock.Unlock()
+	if callback != nil {
+		callback(p)
+	}
+}
+
+// Send oferece o arquivo ao outro lado e o envia em segundo plano. O conteúdo
+// é lido de r sob demanda; Seek permite retomar a partir de qualquer pedaço.
+func (f *FileTransfers) Send(ctx context.Context, target FileTarget, filename string, r io.ReadSeeker) (*Transfer, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, fmt.Errorf("erro ao calcular checksum: %w", err)
+	}
+
+	chunkSize := int64(f.config.ChunkSize)
+	t := &Transfer{
+		files:  f,
+		reader: r,
+		target: target,
+		offer: &FileOffer{
+			TransferId: uuid.New().String(),
+			Filename:   filepath.Base(filename),
+			Size:       size,
+			MimeType:   mime.TypeByExtension(filepath.Ext(filename)),
+			Checksum:   hasher.Sum(nil),
+			ChunkSize:  uint32(chunkSize),
+			ChunkCount: uint32((size + chunkSize - 1) / chunkSize),
+			Sender:     f.sender,
+		},
+		done: make(chan struct{}),
+	}
+	t.start(ctx)
+	return t, nil
+}
+
+// handleOffer responde a um FileOffer, retomando transferências já conhecidas.
+func (f *FileTransfers) handleOffer(data proto.Message) (proto.Message, error) {
+	offer, ok := data.(*FileOffer)
+	if !ok {
+		return nil, ErrTypeMismatch
+	}
+
+	f.lock.Lock()
+	in, exists := f.incoming[offer.TransferId]
+	handler := f.onOffer
+	f.lock.Unlock()
+
+	if exists {
+		in.idle.Reset(f.config.IdleTimeout)
+		in.lock.Lock()
+		defer in.lock.Unlock()
+		return &FileAccept{TransferId: offer.TransferId, Accepted: true, NextChunk: in.next}, nil
+	}
+	if handler == nil {
+		return &FileAccept{TransferId: offer.TransferId, Reason: ErrNoHandler.Error()}, nil
+	}
+
+	writer, err := handler(offer)
+	if err != nil || writer == nil {
+		reason := "recusado pelo destinatário"
+		if err != nil {
+			reason = err.Error()
+		}
+		return &FileAccept{TransferId: offer.TransferId, Reason: reason}, nil
+	}
+
+	in = &incomingFile{offer: offer, writer: writer, hasher: sha256.New()}
+	in.idle = time.AfterFunc(f.config.IdleTimeout, func() { f.abandon(in) })
+	f.lock.Lock()
+	f.incoming[offer.TransferId] = in
+	f.lock.Unlock()
+	if offer.ChunkCount == 0 {
+		f.finishIncoming(offer.TransferId)
+	}
+	return &FileAccept{TransferId: offer.TransferId, Accepted: true}, nil
+}
+
+// handleChunk verifica e grava um pedaço recebido.
+func (f *FileTransfers) handleChunk(data proto.Message) (proto.Message, error) {
+	chunk, ok := data.(*BinaryMessage)
+	if !ok {
+		return nil, ErrTypeMismatch
+	}
+
+	f.lock.Lock()
+	in, exists := f.incoming[chunk.TransferId]
+	f.lock.Unlock()
+	if !exists {
+		return nil, ErrUnknownTransfer
+	}
+	in.idle.Reset(f.config.IdleTimeout)
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	ack := &FileChunkAck{TransferId: chunk.TransferId, ChunkIndex: chunk.ChunkIndex}
+	switch {
+	case chunk.ChunkIndex < in.next:
+		// Reenvio de um pedaço já gravado, por exemplo após reconexão
+		ack.Received = in.received
+		return ack, nil
+	case chunk.ChunkIndex > in.next:
+		return nil, fmt.Errorf("%w: esperado %d, recebido %d", ErrChunkOutOfOrder, in.next, chunk.ChunkIndex)
+	}
+
+	// Nenhum pedaço passa do ChunkSize oferecido nem do que falta do arquivo
+	size := int64(len(chunk.Content))
+	if size > int64(in.offer.ChunkSize) || size > in.offer.Size-in.received {
+		return nil, fmt.Errorf("%w: %d bytes no pedaço %d", ErrChunkTooLarge, size, chunk.ChunkIndex)
+	}
+
+	sum := sha256.Sum256(chunk.Content)
+	if !bytes.Equal(sum[:], chunk.Checksum) {
+		return nil, ErrChecksumMismatch
+	}
+	if _, err := in.writer.Write(chunk.Content); err != nil {
+		return nil, fmt.Errorf("erro ao gravar arquivo: %w", err)
+	}
+	in.hasher.Write(chunk.Content)
+	in.next++
+	in.received += size
+	ack.Received = in.received
+
+	f.progress(TransferProgress{
+		TransferID: in.offer.TransferId,
+		Filename:   in.offer.Filename,
+		Bytes:      in.received,
+		Total:      in.offer.Size,
+		Chunks:     in.next,
+		ChunkCount: in.offer.ChunkCount,
+	})
+
+	if in.next == in.offer.ChunkCount {
+		if err := f.finishIncoming(chunk.TransferId); err != nil {
+			return nil, err
+		}
+	}
+	return ack, nil
+}
+
+// finishIncoming confere o checksum do arquivo inteiro e encerra o recebimento.
+func (f *FileTransfers) finishIncoming(transferID string) error {
+	f.lock.Lock()
+	in, exists := f.incoming[transferID]
+	delete(f.incoming, transferID)
+	callback := f.onComplete
+	f.lock.Unlock()
+	if !exists {
+		// Abandonado pelo IdleTimeout enquanto o último pedaço era gravado
+		return ErrUnknownTransfer
+	}
+	in.idle.Stop()
+
+	var err error
+	if !bytes.Equal(in.hasher.Sum(nil), in.offer.Checksum) {
+		err = fmt.Errorf("%w: arquivo %s", ErrChecksumMismatch, in.offer.Filename)
+	}
+	if callback != nil {
+		callback(in.offer, err)
+	}
+	return err
+}
+
+// abandon descarta um recebimento parado há IdleTimeout e avisa OnComplete.
+func (f *FileTransfers) abandon(in *incomingFile) {
+	f.lock.Lock()
+	if f.incoming[in.offer.TransferId] != in {
+		f.lock.Unlock()
+		return
+	}
+	delete(f.incoming, in.offer.TransferId)
+	callback := f.onComplete
+	f.lock.Unlock()
+
+	if callback != nil {
+		callback(in.offer, fmt.Errorf("%w: arquivo %s", ErrTransferIdle, in.offer.Filename))
+	}
+}
+
+// Transfer acompanha o envio de um arquivo.
+type Transfer struct {
+	files  *FileTransfers
+	offer  *FileOffer
+	reader io.ReadSeeker
+
+	lock    sync.Mutex
+	target  FileTarget
+	next    uint32
+	sent    int64
+	running bool
+	paused  bool
+	err     error
+	done    chan struct{}
+}
+
+// ID retorna o identificador da transferência, igual nos dois lados.
+func (t *Transfer) ID() string {
+	return t.offer.TransferId
+}
+
+// Offer retorna a oferta enviada ao destinatário.
+func (t *Transfer) Offer() *FileOffer {
+	return t.offer
+}
+
+// Progress retorna o andamento do envio.
+func (t *Transfer) Progress() TransferProgress {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.progressLocked()
+}
+
+func (t *Transfer) progressLocked() TransferProgress {
+	return TransferProgress{
+		TransferID: t.offer.TransferId,
+		Filename:   t.offer.Filename,
+		Sending:    true,
+		Bytes:      t.sent,
+		Total:      t.offer.Size,
+		Chunks:     t.next,
+		ChunkCount: t.offer.ChunkCount,
+	}
+}
+
+// Pause interrompe o envio depois do pedaço atual.
+func (t *Transfer) Pause() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.paused = true
+}
+
+// Resume continua o envio a partir do último pedaço confirmado. Um target
+// diferente de nil troca a conexão, como depois de uma reconexão.
+func (t *Transfer) Resume(ctx context.Context, target FileTarget) {
+	t.lock.Lock()
+	if target != nil {
+		t.target = target
+	}
+	t.paused = false
+	t.lock.Unlock()
+	t.start(ctx)
+}
+
+// Err retorna o motivo da última interrupção; ErrTransferPaused enquanto pausada.
+func (t *Transfer) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+// Wait bloqueia até o envio terminar ou ctx expirar. Interrupções que podem
+// ser retomadas não terminam a transferência.
+func (t *Transfer) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Transfer) start(ctx context.Context) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	if t.running {
+		return
+	}
+	t.running = true
+	go t.run(ctx)
+}
+
+// run oferece o arquivo e envia os pedaços a partir de onde o destinatário parou.
+// Falhas de conexão deixam a transferência pausada para ser retomada.
+func (t *Transfer) run(ctx context.Context) {
+	err := t.send(ctx)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.running = false
+	t.err = err
+
+	var remote *RemoteError
+	if err == nil || errors.Is(err, ErrTransferRejected) ||
+		(errors.As(err, &remote) && remote.Message != ErrChecksumMismatch.Error()) {
+		close(t.done)
+		return
+	}
+	t.paused = true
+}
+
+func (t *Transfer) send(ctx context.Context) error {
+	t.lock.Lock()
+	target := t.target
+	t.lock.Unlock()
+
+	reply, err := t.emit(ctx, target, FileOfferEvent, t.offer)
+	if err != nil {
+		return err
+	}
+	accept, ok := reply.(*FileAccept)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	if !accept.Accepted {
+		return fmt.Errorf("%w: %s", ErrTransferRejected, accept.Reason)
+	}
+
+	t.lock.Lock()
+	t.next = accept.NextChunk
+	t.sent = min(int64(t.next)*int64(t.offer.ChunkSize), t.offer.Size)
+	t.lock.Unlock()
+
+	buf := make([]byte, t.offer.ChunkSize)
+	for index := accept.NextChunk; index < t.offer.ChunkCount; index++ {
+		t.lock.Lock()
+		paused := t.paused
+		t.lock.Unlock()
+		if paused {
+			return ErrTransferPaused
+		}
+
+		offset := int64(index) * int64(t.offer.ChunkSize)
+		if _, err := t.reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(t.reader, buf[:min(int64(len(buf)), t.offer.Size-offset)])
+		if err != nil {
+			return fmt.Errorf("erro ao ler arquivo: %w", err)
+		}
+		sum := sha256.Sum256(buf[:n])
+		chunk := &BinaryMessage{
+			Filename:   t.offer.Filename,
+			Content:    buf[:n],
+			Size:       t.offer.Size,
+			MimeType:   t.offer.MimeType,
+			Sender:     t.offer.Sender,
+			Timestamp:  time.Now().Unix(),
+			Type:       MessageType_BINARY,
+			TransferId: t.offer.TransferId,
+			ChunkIndex: index,
+			Checksum:   sum[:],
+		}
+
+		if err := t.sendChunk(ctx, target, chunk); err != nil {
+			return err
+		}
+
+		t.lock.Lock()
+		t.next = index + 1
+		t.sent += int64(n)
+		progress := t.progressLocked()
+		t.lock.Unlock()
+		t.files.progress(progress)
+	}
+	return nil
+}
+
+// sendChunk envia um pedaço, repetindo quando o destinatário acusa checksum inválido.
+func (t *Transfer) sendChunk(ctx context.Context, target FileTarget, chunk *BinaryMessage) error {
+	var err error
+	for attempt := 0; attempt <= t.files.config.MaxRetries; attempt++ {
+		_, err = t.emit(ctx, target, FileChunkEvent, chunk, WithPriority(PriorityBulk))
+		var remote *RemoteError
+		if err == nil || !errors.As(err, &remote) || remote.Message != ErrChecksumMismatch.Error() {
+			return err
+		}
+	}
+	return err
+}
+
+func (t *Transfer) emit(ctx context.Context, target FileTarget, event string, msg proto.Message, opts ...EmitOption) (proto.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.files.config.ChunkTimeout)
+	defer cancel()
+	return target.EmitWithAck(ctx, event, msg, opts...)
+}
+
+// HandleFileTransfers passa a receber arquivos oferecidos pelo cliente.
+func (s *Socket) HandleFileTransfers(files *FileTransfers) {
+	s.OnWithAck(FileOfferEvent, func(data proto.Message, _ *Socket) (proto.Message, error) {
+		return files.handleOffer(data)
+	})
+	s.OnWithAck(FileChunkEvent, func(data proto.Message, _ *Socket) (proto.Message, error) {
+		return files.handleChunk(data)
+	})
+}
+
+// HandleFileTransfers passa a receber arquivos oferecidos pelo outro lado.
+func (c *Client) HandleFileTransfers(files *FileTransfers) {
+	c.OnWithAck(FileOfferEvent, func(data proto.Message, _ *Client) (proto.Message, error) {
+		return files.handleOffer(data)
+	})
+	c.OnWithAck(FileChunkEvent, func(data proto.Message, _ *Client) (proto.Message, error) {
+		return files.handleChunk(data)
+	})
+}
+
+// Files retorna as transferências de arquivo do peer, usadas em todos os links.
+func (p *Peer) Files() *FileTransfers {
+	return p.files
+}
+
+// SendFile envia um arquivo ao peer em pedaços verificados. Se o link cair, a
+// transferência fica pausada e pode ser retomada com Resume em um novo link.
+func (p *Peer) SendFile(ctx context.Context, targetID, filename string, r io.ReadSeeker) (*Transfer, error) {
+	p.lock.RLock()
+	client, exists := p.clients[targetID]
+	p.lock.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("peer %s não encontrado", targetID)
+	}
+	return p.files.Send(ctx, client, filename, r)
+}
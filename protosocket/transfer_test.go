@@ -0,0 +1,298 @@
+package protosocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// loopbackTarget entrega as mensagens direto a outro FileTransfers, devolvendo
+// os erros como o outro lado de um EmitWithAck faria.
+type loopbackTarget struct {
+	files *FileTransfers
+
+	lock sync.Mutex
+	// corrupt é quantos pedaços chegam com o conteúdo alterado
+	corrupt int
+	// dropAfter derruba a "conexão" depois desse número de pedaços; zero nunca
+	dropAfter int
+	chunks    int
+}
+
+func (l *loopbackTarget) EmitWithAck(_ context.Context, event string, msg proto.Message, _ ...EmitOption) (proto.Message, error) {
+	var reply proto.Message
+	var err error
+	switch event {
+	case FileOfferEvent:
+		reply, err = l.files.handleOffer(msg)
+	case FileChunkEvent:
+		l.lock.Lock()
+		if l.dropAfter > 0 && l.chunks == l.dropAfter {
+			l.lock.Unlock()
+			return nil, ErrConnectionClosed
+		}
+		l.chunks++
+		chunk := proto.Clone(msg).(*BinaryMessage)
+		if l.corrupt > 0 {
+			l.corrupt--
+			chunk.Content[0] ^= 0xff
+		}
+		l.lock.Unlock()
+		reply, err = l.files.handleChunk(chunk)
+	}
+	if err != nil {
+		return nil, &RemoteError{Message: err.Error()}
+	}
+	return reply, nil
+}
+
+// receiver monta um FileTransfers que grava os arquivos aceitos em buf.
+func receiver(buf *bytes.Buffer, accept bool) (*FileTransfers, <-chan error) {
+	files := NewFileTransfers(DefaultFileTransferConfig())
+	completed := make(chan error, 1)
+	files.OnOffer(func(*FileOffer) (io.Writer, error) {
+		if !accept {
+			return nil, errors.New("sem espaço")
+		}
+		return buf, nil
+	})
+	files.OnComplete(func(_ *FileOffer, err error) { completed <- err })
+	return files, completed
+}
+
+func TestFileTransfer(t *testing.T) {
+	content := compressible(10_000)
+	tests := []struct {
+		name    string
+		accept  bool
+		corrupt int
+		err     error
+	}{
+		{"sem falhas", true, 0, nil},
+		{"pedaço corrompido é reenviado", true, 2, nil},
+		{"recusado pelo destinatário", false, 0, ErrTransferRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			remote, completed := receiver(&buf, tt.accept)
+			sender := NewFileTransfers(FileTransferConfig{ChunkSize: 1024, MaxRetries: 3})
+			var lock sync.Mutex
+			var last TransferProgress
+			sender.OnProgress(func(p TransferProgress) {
+				lock.Lock()
+				last = p
+				lock.Unlock()
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			transfer, err := sender.Send(ctx, &loopbackTarget{files: remote, corrupt: tt.corrupt}, "dir/dados.txt", bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offer := transfer.Offer(); offer.Filename != "dados.txt" || offer.ChunkCount != 10 || offer.Size != int64(len(content)) {
+				t.Errorf("oferta = %v", offer)
+			}
+			if err := transfer.Wait(ctx); !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if err := <-completed; err != nil {
+				t.Errorf("recebimento terminou com %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("%d bytes recebidos, esperado %d", buf.Len(), len(content))
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if !last.Sending || last.Bytes != int64(len(content)) || last.Chunks != last.ChunkCount {
+				t.Errorf("progresso final = %+v", last)
+			}
+		})
+	}
+}
+
+func TestFileTransferChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	remote, completed := receiver(&buf, true)
+
+	// Um pedaço que nunca chega íntegro esgota as tentativas e pausa o envio
+	sender := NewFileTransfers(FileTransferConfig{ChunkSize: 1024, MaxRetries: 1})
+	transfer, err := sender.Send(context.Background(), &loopbackTarget{files: remote, corrupt: 2}, "a.txt", bytes.NewReader(compressible(2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := transfer.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, esperado que a transferência continuasse pendente", err)
+	}
+	var remoteErr *RemoteError
+	if err := transfer.Err(); !errors.As(err, &remoteErr) || remoteErr.Message != ErrChecksumMismatch.Error() {
+		t.Errorf("erro = %v, esperado %v", err, ErrChecksumMismatch)
+	}
+
+	// Um arquivo cujo checksum final não confere é recusado no último pedaço
+	offer := &FileOffer{TransferId: "x", Filename: "b.txt", Size: 3, Checksum: []byte("errado"), ChunkSize: 3, ChunkCount: 1}
+	if _, err := remote.handleOffer(offer); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("abc"))
+	if _, err := remote.handleChunk(&BinaryMessage{TransferId: "x", Content: []byte("abc"), Checksum: sum[:]}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("erro = %v, esperado %v", err, ErrChecksumMismatch)
+	}
+	if err := <-completed; !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("OnComplete = %v, esperado %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestFileTransferResume(t *testing.T) {
+	content := compressible(5000)
+	var buf bytes.Buffer
+	remote, completed := receiver(&buf, true)
+	sender := NewFileTransfers(FileTransferConfig{ChunkSize: 1000})
+
+	// A conexão cai depois de dois pedaços e a transferência fica pausada
+	transfer, err := sender.Send(context.Background(), &loopbackTarget{files: remote, dropAfter: 2}, "a.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for transfer.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := transfer.Err(); !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("erro = %v, esperado %v", err, ErrConnectionClosed)
+	}
+	if p := transfer.Progress(); p.Chunks != 2 || p.Bytes != 2000 {
+		t.Errorf("progresso = %+v, esperado 2 pedaços", p)
+	}
+
+	// Retomada por um novo link continua do pedaço seguinte ao último gravado
+	target := &loopbackTarget{files: remote}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	transfer.Resume(ctx, target)
+	if err := transfer.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-completed; err != nil {
+		t.Errorf("recebimento terminou com %v", err)
+	}
+	if target.chunks != 3 || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("%d pedaços reenviados, %d bytes recebidos", target.chunks, buf.Len())
+	}
+}
+
+func TestFileTransferChunkSize(t *testing.T) {
+	content := compressible(1500)
+	chunk := func(index uint32, data []byte) *BinaryMessage {
+		sum := sha256.Sum256(data)
+		return &BinaryMessage{TransferId: "t", ChunkIndex: index, Content: data, Checksum: sum[:]}
+	}
+
+	tests := []struct {
+		name   string
+		chunks []*BinaryMessage
+		err    error
+	}{
+		{"tamanhos anunciados", []*BinaryMessage{chunk(0, content[:1000]), chunk(1, content[1000:])}, nil},
+		{"maior que o ChunkSize", []*BinaryMessage{chunk(0, compressible(1200))}, ErrChunkTooLarge},
+		{"além do fim do arquivo", []*BinaryMessage{chunk(0, content[:1000]), chunk(1, compressible(600))}, ErrChunkTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			remote, _ := receiver(&buf, true)
+			sum := sha256.Sum256(content)
+			offer := &FileOffer{TransferId: "t", Filename: "a.txt", Size: int64(len(content)), Checksum: sum[:], ChunkSize: 1000, ChunkCount: 2}
+			if _, err := remote.handleOffer(offer); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for _, c := range tt.chunks {
+				if _, err = remote.handleChunk(c); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if tt.err == nil && !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("%d bytes gravados, esperado %d", buf.Len(), len(content))
+			}
+			if buf.Len() > len(content) {
+				t.Errorf("%d bytes gravados além do tamanho oferecido", buf.Len())
+			}
+		})
+	}
+}
+
+func TestFileTransferIdle(t *testing.T) {
+	remote := NewFileTransfers(FileTransferConfig{IdleTimeout: 20 * time.Millisecond})
+	remote.OnOffer(func(*FileOffer) (io.Writer, error) { return io.Discard, nil })
+	completed := make(chan error, 1)
+	remote.OnComplete(func(_ *FileOffer, err error) { completed <- err })
+
+	// O remetente cai no meio do arquivo e não volta dentro do prazo
+	sender := NewFileTransfers(FileTransferConfig{ChunkSize: 1000})
+	if _, err := sender.Send(context.Background(), &loopbackTarget{files: remote, dropAfter: 2}, "a.txt", bytes.NewReader(compressible(5000))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-completed:
+		if !errors.Is(err, ErrTransferIdle) {
+			t.Errorf("erro = %v, esperado %v", err, ErrTransferIdle)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("recebimento parado não foi descartado")
+	}
+
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+	if len(remote.incoming) != 0 {
+		t.Errorf("%d recebimentos pendentes, esperado nenhum", len(remote.incoming))
+	}
+}
+
+func TestClientServerFileTransfer(t *testing.T) {
+	var buf bytes.Buffer
+	remote, completed := receiver(&buf, true)
+	server := NewServer()
+	server.OnConnection(func(socket *Socket) { socket.HandleFileTransfers(remote) })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	defer client.Close()
+	content := compressible(3000)
+	sender := NewFileTransfers(FileTransferConfig{ChunkSize: 1024})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	transfer, err := sender.Send(ctx, client, "a.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-completed; err != nil || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("recebimento = %v, %d bytes", err, buf.Len())
+	}
+}
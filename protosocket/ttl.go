@@ -0,0 +1,143 @@
+package protosocket
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// WithTTL faz o envelope expirar ttl depois do envio. Envelopes vencidos são
+// descartados na fila de escrita, nos buffers de reenvio e na entrega, e
+// contados nas métricas. A validade usa o relógio do remetente.
+func WithTTL(ttl time.Duration) EmitOption {
+	return func(m *Message) {
+		m.Ttl = ttl.Milliseconds()
+	}
+}
+
+// ExpiresAt retorna quando o envelope expira; zero quando ele não tem TTL.
+func (m *Message) ExpiresAt() time.Time {
+	if m.GetTtl() <= 0 {
+		return time.Time{}
+	}
+	return m.SentAt().Add(time.Duration(m.GetTtl()) * time.Millisecond)
+}
+
+func (m *Message) expired(now time.Time) bool {
+	expiresAt := m.ExpiresAt()
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// RecordExpired conta um envelope descartado por ter expirado.
+func (m *MetricsCollector) RecordExpired() {
+	atomic.AddUint64(&m.expired, 1)
+}
+
+// ExpiredMessages retorna quantos envelopes foram descartados por expirar.
+func (m *MetricsCollector) ExpiredMessages() uint64 {
+	return atomic.LoadUint64(&m.expired)
+}
+
+// EmitWithTTL envia uma mensagem que é descartada se não for entregue dentro de ttl.
+func (c *Client) EmitWithTTL(event string, msg proto.Message, ttl time.Duration, opts ...EmitOption) error {
+	return c.Emit(event, msg, append(opts, WithTTL(ttl))...)
+}
+
+// EmitWithTTL envia uma mensagem que é descartada se não for entregue dentro de ttl.
+func (s *Socket) EmitWithTTL(event string, data proto.Message, ttl time.Duration, opts ...EmitOption) error {
+	return s.Emit(event, data, append(opts, WithTTL(ttl))...)
+}
+
+// OnExpired registra um callback chamado com cada envelope descartado por
+// expirar, tanto no envio quanto no recebimento.
+func (c *Client) OnExpired(callback func(client *Client, envelope *Message)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onExpired = callback
+}
+
+// ExpiredMessages retorna quantos envelopes o Client descartou por expirar.
+func (c *Client) ExpiredMessages() uint64 {
+	return c.metrics.ExpiredMessages()
+}
+
+// dropExpired indica se o envelope venceu e, nesse caso, o conta e o entrega ao callback.
+func (c *Client) dropExpired(envelope *Message) bool {
+	if !envelope.expired(time.Now()) {
+		return false
+	}
+	c.reportExpired(envelope)
+	return true
+}
+
+func (c *Client) reportExpired(envelope *Message) {
+	c.metrics.RecordExpired()
+	c.lock.Lock()
+	callback := c.onExpired
+	c.lock.Unlock()
+	if callback != nil {
+		callback(c, envelope)
+	}
+}
+
+// OnExpired registra um callback chamado com cada envelope descartado por
+// expirar, tanto no envio quanto no recebimento.
+func (s *Socket) OnExpired(callback func(socket *Socket, envelope *Message)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onExpired = callback
+}
+
+// ExpiredMessages retorna quantos envelopes o socket descartou por expirar.
+// Nos sockets de um Server, a contagem é a do Server.
+func (s *Socket) ExpiredMessages() uint64 {
+	return s.metrics.ExpiredMessages()
+}
+
+func (s *Socket) shareMetrics(metrics *MetricsCollector) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics = metrics
+}
+
+// dropExpired indica se o envelope venceu e, nesse caso, o conta e o entrega ao callback.
+func (s *Socket) dropExpired(envelope *Message) bool {
+	if !envelope.expired(time.Now()) {
+		return false
+	}
+	s.reportExpired(envelope)
+	return true
+}
+
+func (s *Socket) reportExpired(envelope *Message) {
+	s.lock.Lock()
+	metrics, callback := s.metrics, s.onExpired
+	s.lock.Unlock()
+	metrics.RecordExpired()
+	if callback != nil {
+		callback(s, envelope)
+	}
+}
+
+// OnExpired registra, para todos os sockets, o callback dos envelopes
+// descartados por expirar.
+func (s *Server) OnExpired(callback func(socket *Socket, envelope *Message)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onExpired = callback
+}
+
+// ExpiredMessages retorna quantos envelopes os sockets do Server descartaram por expirar.
+func (s *Server) ExpiredMessages() uint64 {
+	return s.metrics.ExpiredMessages()
+}
+
+func (s *Server) reportExpired(socket *Socket, envelope *Message) {
+	s.lock.Lock()
+	callback := s.onExpired
+	s.lock.Unlock()
+	if callback != nil {
+		callback(socket, envelope)
+	}
+}
@@ -0,0 +1,292 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mendes113/protosocket/protosocket/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// sentAgo ajusta o envelope como se tivesse sido enviado age atrás.
+func sentAgo(age time.Duration) EmitOption {
+	return func(m *Message) {
+		m.Timestamp = time.Now().Add(-age).UnixMilli()
+	}
+}
+
+func TestMessageExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		ttl     int64
+		age     time.Duration
+		expired bool
+	}{
+		{"sem ttl", 0, time.Hour, false},
+		{"ttl negativo", -1, time.Hour, false},
+		{"dentro do prazo", 1000, 500 * time.Millisecond, false},
+		{"vencido", 1000, 2 * time.Second, true},
+		{"no instante do vencimento", 1000, time.Second, true},
+		{"enviado no futuro", 1000, -time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Ttl: tt.ttl, Timestamp: now.Add(-tt.age).UnixMilli()}
+			if got := msg.expired(now.Truncate(time.Millisecond)); got != tt.expired {
+				t.Errorf("expired = %v, esperado %v", got, tt.expired)
+			}
+			if tt.ttl <= 0 && !msg.ExpiresAt().IsZero() {
+				t.Errorf("ExpiresAt = %v, esperado zero sem TTL", msg.ExpiresAt())
+			}
+		})
+	}
+}
+
+func TestMessageValidatorTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		age  time.Duration
+		err  error
+	}{
+		{"ttl dentro do prazo", time.Second, 0, nil},
+		{"ttl vencido", time.Second, 2 * time.Second, ErrMessageExpired},
+		{"ttl maior que o prazo padrão", time.Hour, 10 * time.Minute, nil},
+		{"sem ttl dentro do prazo padrão", 0, time.Minute, nil},
+		{"sem ttl além do prazo padrão", 0, 10 * time.Minute, ErrMessageExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []EmitOption{sentAgo(tt.age)}
+			if tt.ttl > 0 {
+				opts = append(opts, WithTTL(tt.ttl))
+			}
+			msg, err := newEnvelope("chat", &ChatMessage{Content: "oi"}, "a", 0, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := NewMessageValidator().Validate(msg); !errors.Is(err, tt.err) {
+				t.Errorf("erro = %v, esperado %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWritePumpDropsExpired(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     int64
+		age     time.Duration
+		expired bool
+	}{
+		{"vencido na fila", 10, time.Second, true},
+		{"dentro do prazo", int64(time.Minute / time.Millisecond), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expired []*Message
+			// Sem conexão: um frame escrito faria o teste entrar em pânico
+			w := &writePump{onExpired: func(m *Message) { expired = append(expired, m) }}
+			envelope := &Message{Ttl: tt.ttl, Timestamp: time.Now().Add(-tt.age).UnixMilli()}
+			frame := outboundFrame{fragments: [][]byte{{1}, {2}}, envelope: envelope}
+
+			var active []*outboundFrame
+			if err := w.handle(frame, &active); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(expired) == 1; got != tt.expired {
+				t.Errorf("descartado = %v, esperado %v", got, tt.expired)
+			}
+			if got := len(active) == 0; got != tt.expired {
+				t.Errorf("fragmentos em andamento = %d", len(active))
+			}
+		})
+	}
+}
+
+func TestDeliveryOutboxPurge(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttls    []time.Duration
+		ages    []time.Duration
+		expired int
+	}{
+		{"sem ttl", []time.Duration{0, 0}, []time.Duration{time.Hour, time.Hour}, 0},
+		{"todos vencidos", []time.Duration{time.Second, time.Second}, []time.Duration{time.Minute, time.Minute}, 2},
+		{"um vencido", []time.Duration{time.Second, time.Hour}, []time.Duration{time.Minute, time.Minute}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newDeliveryOutbox(DefaultDeliveryConfig(), "a")
+			for i, ttl := range tt.ttls {
+				opts := []EmitOption{sentAgo(tt.ages[i])}
+				if ttl > 0 {
+					opts = append(opts, WithTTL(ttl))
+				}
+				envelope, err := newEnvelope("chat", &ChatMessage{Content: "oi"}, "a", 0, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				o.add(envelope)
+			}
+
+			if got := len(o.purge()); got != tt.expired {
+				t.Errorf("expirados = %d, esperado %d", got, tt.expired)
+			}
+			if o.len() != len(tt.ttls)-tt.expired {
+				t.Errorf("pendentes = %d, esperado %d", o.len(), len(tt.ttls)-tt.expired)
+			}
+			if len(o.due(true)) != o.len() {
+				t.Error("envelope expirado continua sendo reenviado")
+			}
+		})
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	server := NewServer()
+	handled := make(chan string, 8)
+	server.On("chat", func(msg proto.Message, socket *Socket) {
+		handled <- msg.(*ChatMessage).Content
+	})
+	serverExpired := make(chan *Message, 8)
+	server.OnExpired(func(socket *Socket, envelope *Message) { serverExpired <- envelope })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var clientExpired atomic.Int32
+	client.OnExpired(func(_ *Client, _ *Message) { clientExpired.Add(1) })
+
+	tests := []struct {
+		name string
+		// send envia a mensagem e devolve o erro do envio
+		send          func() error
+		err           error
+		clientExpired int32
+		serverExpired bool
+	}{
+		{
+			name: "vencida antes do envio",
+			send: func() error {
+				return client.EmitWithTTL("chat", &ChatMessage{Content: "envio"}, 10*time.Millisecond, sentAgo(time.Second))
+			},
+			err:           ErrMessageExpired,
+			clientExpired: 1,
+		},
+		{
+			name: "vencida no caminho",
+			send: func() error {
+				// Escreve direto na conexão, sem a checagem do envio
+				envelope, err := newEnvelope("chat", &ChatMessage{Content: "caminho"}, client.ID, 0,
+					WithTTL(10*time.Millisecond), sentAgo(time.Second))
+				if err != nil {
+					return err
+				}
+				_, writer, codec := client.connection()
+				data, err := codec.Encode(envelope)
+				if err != nil {
+					return err
+				}
+				return writer.enqueue(codec.FrameType(), data)
+			},
+			serverExpired: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := clientExpired.Load()
+			if err := tt.send(); !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+			if got := clientExpired.Load() - before; got != tt.clientExpired {
+				t.Errorf("expiradas no cliente = %d, esperado %d", got, tt.clientExpired)
+			}
+			if tt.serverExpired {
+				select {
+				case <-serverExpired:
+				case <-time.After(time.Second):
+					t.Fatal("servidor não descartou a mensagem vencida")
+				}
+			}
+
+			// Uma mensagem válida depois mostra que a vencida não chegou ao handler
+			if err := client.EmitWithTTL("chat", &ChatMessage{Content: "ok"}, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case content := <-handled:
+				if content != "ok" {
+					t.Fatalf("handler recebeu a mensagem vencida %q", content)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("mensagem válida não chegou")
+			}
+		})
+	}
+
+	if server.ExpiredMessages() != 1 || client.ExpiredMessages() != 1 {
+		t.Errorf("métricas: servidor %d, cliente %d", server.ExpiredMessages(), client.ExpiredMessages())
+	}
+}
+
+func TestClientSendExpired(t *testing.T) {
+	tests := []struct {
+		name string
+		drop bool
+	}{
+		{"conectado", false},
+		{"reconectando", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFlakyServer(t)
+			client, err := Dial(context.Background(), server.url, WithReconnect(fastReconnect))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if tt.drop {
+				server.down.Store(true)
+				server.drop(t)
+				waitState(t, client, types.StateReconnecting)
+			}
+
+			envelope, err := newEnvelope("chat", &ChatMessage{Content: "velha"}, client.ID, 99,
+				WithTTL(10*time.Millisecond), sentAgo(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.send(envelope); !errors.Is(err, ErrMessageExpired) {
+				t.Fatalf("erro = %v, esperado %v", err, ErrMessageExpired)
+			}
+
+			// A vencida não fica guardada nem para reenvio nem para a reconexão
+			if kept := client.retransmit.get([]uint64{99}); len(kept) != 0 {
+				t.Errorf("%d envelopes vencidos guardados para reenvio", len(kept))
+			}
+			client.lock.Lock()
+			buffered := len(client.outbox)
+			client.lock.Unlock()
+			if buffered != 0 {
+				t.Errorf("%d envelopes vencidos no buffer de reconexão", buffered)
+			}
+		})
+	}
+}
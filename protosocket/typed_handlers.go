@@ -9,6 +9,10 @@ type EventHandler[T proto.Message] struct {
 }
 
 func OnTyped[T proto.Message](s *Socket, event string, handler EventHandler[T]) {
+	// Registra o tipo do evento caso a aplicação ainda não tenha feito
+	var zero T
+	DefaultRegistry.registerIfAbsent(event, zero)
+
 	s.On(event, func(data proto.Message, socket *Socket) {
 		if msg, ok := data.(T); ok {
 			handler.Handler(msg, socket)
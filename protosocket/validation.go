@@ -2,9 +2,18 @@ package protosocket
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 var (
@@ -18,6 +27,8 @@ type Validator interface {
 	Validate(msg proto.Message) error
 }
 
+// MessageValidator faz as checagens básicas do envelope. Para regras por
+// evento e por campo, use ValidationRules.
 type MessageValidator struct {
 	maxMessageSize    int
 	messageTimeout    time.Duration
@@ -36,7 +47,7 @@ func NewMessageValidator() *MessageValidator {
 	}
 }
 
-func (v *MessageValidator) Validate(msg *SequencedMessage) error {
+func (v *MessageValidator) Validate(msg *Message) error {
 	// Validação básica
 	if msg == nil {
 		return ErrInvalidMessage
@@ -52,11 +63,491 @@ func (v *MessageValidator) Validate(msg *SequencedMessage) error {
 		return ErrInvalidMessage
 	}
 
-	// Timestamp
-	msgTime := time.Unix(msg.Timestamp, 0)
-	if time.Since(msgTime) > v.messageTimeout {
+	// Timestamp; o TTL do remetente tem precedência sobre o prazo padrão
+	if msg.GetTtl() > 0 {
+		if msg.expired(time.Now()) {
+			return ErrMessageExpired
+		}
+	} else if time.Since(msg.SentAt()) > v.messageTimeout {
 		return ErrMessageExpired
 	}
 
 	return nil
 }
+
+// ValidationEvent devolve ao remetente o ValidationError de uma mensagem sem
+// ack recusada; com ack, o erro vai na própria resposta.
+const ValidationEvent = "$invalid"
+
+// FeatureValidation indica que a ponta entende o evento $invalid.
+const FeatureValidation = "validation"
+
+// Nomes das regras em FieldViolation.Rule.
+const (
+	RuleRequired = "required"
+	RuleLength   = "length"
+	RulePattern  = "pattern"
+	RuleRange    = "range"
+	RuleSize     = "size"
+	RuleEvent    = "event"
+)
+
+// Error resume as violações em uma linha.
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s em '%s'", ErrInvalidMessage, e.GetEvent())
+	for i, v := range e.GetViolations() {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		if v.GetField() != "" {
+			b.WriteString(v.GetField() + ": ")
+		}
+		b.WriteString(v.GetDescription())
+	}
+	return b.String()
+}
+
+// Is faz errors.Is(err, ErrInvalidMessage) reconhecer um ValidationError.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidMessage
+}
+
+// FieldRule é uma regra de campo criada por Required, Length, Pattern ou Range.
+type FieldRule struct {
+	name string
+	// check só recebe campos presentes, exceto em Required
+	check func(field protoreflect.FieldDescriptor, value protoreflect.Value, present bool) string
+}
+
+// Required recusa campos ausentes: valor zero, lista ou mapa vazio.
+func Required() FieldRule {
+	return FieldRule{name: RuleRequired, check: func(_ protoreflect.FieldDescriptor, _ protoreflect.Value, present bool) string {
+		if !present {
+			return "campo obrigatório"
+		}
+		return ""
+	}}
+}
+
+// Length limita caracteres de strings, bytes de bytes e itens de listas e
+// mapas; max zero não limita.
+func Length(min, max int) FieldRule {
+	return FieldRule{name: RuleLength, check: func(field protoreflect.FieldDescriptor, value protoreflect.Value, _ bool) string {
+		var n int
+		switch {
+		case field.IsList():
+			n = value.List().Len()
+		case field.IsMap():
+			n = value.Map().Len()
+		case field.Kind() == protoreflect.StringKind:
+			n = utf8.RuneCountInString(value.String())
+		case field.Kind() == protoreflect.BytesKind:
+			n = len(value.Bytes())
+		default:
+			return fmt.Sprintf("regra length não se aplica a campos %s", field.Kind())
+		}
+		if n < min || (max > 0 && n > max) {
+			if max > 0 {
+				return fmt.Sprintf("tamanho %d fora do intervalo [%d, %d]", n, min, max)
+			}
+			return fmt.Sprintf("tamanho %d menor que %d", n, min)
+		}
+		return ""
+	}}
+}
+
+// Pattern exige que strings satisfaçam a expressão regular; expressões
+// inválidas causam panic, como em regexp.MustCompile.
+func Pattern(expr string) FieldRule {
+	return patternRule(expr, regexp.MustCompile(expr))
+}
+
+func patternRule(expr string, re *regexp.Regexp) FieldRule {
+	return FieldRule{name: RulePattern, check: func(field protoreflect.FieldDescriptor, value protoreflect.Value, _ bool) string {
+		if field.Kind() != protoreflect.StringKind || field.IsList() || field.IsMap() {
+			return fmt.Sprintf("regra pattern não se aplica a campos %s", field.Kind())
+		}
+		if !re.MatchString(value.String()) {
+			return fmt.Sprintf("valor não corresponde a %q", expr)
+		}
+		return ""
+	}}
+}
+
+// Range limita campos numéricos ao intervalo fechado [min, max].
+func Range(min, max float64) FieldRule {
+	return FieldRule{name: RuleRange, check: func(field protoreflect.FieldDescriptor, value protoreflect.Value, _ bool) string {
+		n, ok := numericValue(field, value)
+		if !ok {
+			return fmt.Sprintf("regra range não se aplica a campos %s", field.Kind())
+		}
+		if n < min || n > max {
+			return fmt.Sprintf("valor %v fora do intervalo [%v, %v]", n, min, max)
+		}
+		return ""
+	}}
+}
+
+func numericValue(field protoreflect.FieldDescriptor, value protoreflect.Value) (float64, bool) {
+	if field.IsList() || field.IsMap() {
+		return 0, false
+	}
+	switch field.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(value.Int()), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(value.Uint()), true
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return value.Float(), true
+	case protoreflect.EnumKind:
+		return float64(value.Enum()), true
+	}
+	return 0, false
+}
+
+// ValidationRules reúne as regras aplicadas às mensagens recebidas. As regras
+// declaradas no .proto com a opção (protosocket.rules) valem sempre; as do
+// builder se somam a elas.
+//
+//	rules := NewValidationRules().MaxSize(64 << 10).Allow("chat")
+//	rules.Event("chat").Field("content", Required(), Length(1, 500))
+type ValidationRules struct {
+	maxSize int
+	allowed map[string]bool
+	events  map[string]*EventRules
+	lock    sync.RWMutex
+}
+
+func NewValidationRules() *ValidationRules {
+	return &ValidationRules{
+		events: make(map[string]*EventRules),
+	}
+}
+
+// MaxSize limita o tamanho de data nos eventos sem limite próprio; zero não limita.
+func (r *ValidationRules) MaxSize(size int) *ValidationRules {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maxSize = size
+	return r
+}
+
+// Allow passa a recusar os eventos que não foram informados aqui nem declarados com Event.
+func (r *ValidationRules) Allow(events ...string) *ValidationRules {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.allowed == nil {
+		r.allowed = make(map[string]bool)
+	}
+	for _, event := range events {
+		r.allowed[event] = true
+	}
+	return r
+}
+
+// Event retorna as regras do evento, criando-as na primeira chamada.
+func (r *ValidationRules) Event(event string) *EventRules {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rules, ok := r.events[event]
+	if !ok {
+		rules = &EventRules{parent: r}
+		r.events[event] = rules
+	}
+	return rules
+}
+
+// EventRules são as regras de um evento, criadas por ValidationRules.Event.
+type EventRules struct {
+	parent  *ValidationRules
+	maxSize int
+	fields  []fieldRules
+}
+
+type fieldRules struct {
+	path  string
+	rules []FieldRule
+}
+
+// MaxSize limita o tamanho de data neste evento; zero volta ao limite geral.
+func (e *EventRules) MaxSize(size int) *EventRules {
+	e.parent.lock.Lock()
+	defer e.parent.lock.Unlock()
+	e.maxSize = size
+	return e
+}
+
+// Field aplica as regras ao campo, indicado pelo nome no .proto; campos de
+// mensagens aninhadas usam pontos, como "offer.name". Um caminho inexistente
+// conta como campo ausente.
+func (e *EventRules) Field(path string, rules ...FieldRule) *EventRules {
+	e.parent.lock.Lock()
+	defer e.parent.lock.Unlock()
+	e.fields = append(e.fields, fieldRules{path: path, rules: rules})
+	return e
+}
+
+// checkEnvelope confere o evento e o tamanho antes da desserialização. Aceita
+// r nil, caso em que nada é recusado.
+func (r *ValidationRules) checkEnvelope(envelope *Message) *ValidationError {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	event, declared := r.events[envelope.Event]
+	if r.allowed != nil && !declared && !r.allowed[envelope.Event] {
+		return newValidationError(envelope, &FieldViolation{Rule: RuleEvent, Description: "evento não permitido"})
+	}
+	limit := r.maxSize
+	if declared && event.maxSize > 0 {
+		limit = event.maxSize
+	}
+	if limit > 0 && len(envelope.Data) > limit {
+		return newValidationError(envelope, &FieldViolation{
+			Rule:        RuleSize,
+			Description: fmt.Sprintf("%d bytes excedem o limite de %d", len(envelope.Data), limit),
+		})
+	}
+	return nil
+}
+
+// checkMessage confere os campos da mensagem desserializada: primeiro as
+// regras declaradas no .proto, depois as do evento.
+func (r *ValidationRules) checkMessage(envelope *Message, msg proto.Message) *ValidationError {
+	if msg == nil {
+		return nil
+	}
+	m := msg.ProtoReflect()
+	var violations []*FieldViolation
+	checkDeclared(m, "", &violations)
+
+	if r != nil {
+		r.lock.RLock()
+		var fields []fieldRules
+		if event, ok := r.events[envelope.Event]; ok {
+			fields = event.fields
+		}
+		r.lock.RUnlock()
+		for _, f := range fields {
+			field, value, present := resolveField(m, f.path)
+			violations = applyRules(violations, f.path, f.rules, field, value, present)
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return newValidationError(envelope, violations...)
+}
+
+func newValidationError(envelope *Message, violations ...*FieldViolation) *ValidationError {
+	return &ValidationError{
+		Event:      envelope.Event,
+		MessageId:  envelope.MessageId,
+		Violations: violations,
+	}
+}
+
+func applyRules(violations []*FieldViolation, path string, rules []FieldRule, field protoreflect.FieldDescriptor, value protoreflect.Value, present bool) []*FieldViolation {
+	for _, rule := range rules {
+		if field == nil {
+			// Campo inexistente: só Required tem o que dizer
+			if rule.name == RuleRequired {
+				violations = append(violations, &FieldViolation{Field: path, Rule: rule.name, Description: "campo obrigatório"})
+			}
+			continue
+		}
+		if !present && rule.name != RuleRequired {
+			continue
+		}
+		if desc := rule.check(field, value, present); desc != "" {
+			violations = append(violations, &FieldViolation{Field: path, Rule: rule.name, Description: desc})
+		}
+	}
+	return violations
+}
+
+// resolveField segue o caminho pelas mensagens aninhadas. field é nil quando
+// o caminho não existe no tipo.
+func resolveField(m protoreflect.Message, path string) (protoreflect.FieldDescriptor, protoreflect.Value, bool) {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		field := m.Descriptor().Fields().ByName(protoreflect.Name(part))
+		if field == nil {
+			return nil, protoreflect.Value{}, false
+		}
+		present := m.Has(field)
+		if i == len(parts)-1 {
+			return field, m.Get(field), present
+		}
+		if field.Message() == nil || field.IsList() || field.IsMap() {
+			return nil, protoreflect.Value{}, false
+		}
+		if !present {
+			// Mensagem intermediária ausente: o campo final também está
+			return field, protoreflect.Value{}, false
+		}
+		m = m.Get(field).Message()
+	}
+	return nil, protoreflect.Value{}, false
+}
+
+// declaredField são as regras de um campo lidas da opção (protosocket.rules).
+type declaredField struct {
+	field protoreflect.FieldDescriptor
+	rules []FieldRule
+}
+
+// declaredRules guarda, por descritor, as regras lidas das opções dos campos.
+// A chave é o próprio descritor, não o nome: tipos dinâmicos com o mesmo nome
+// têm campos diferentes.
+var declaredRules sync.Map
+
+func rulesOf(desc protoreflect.MessageDescriptor) []declaredField {
+	if cached, ok := declaredRules.Load(desc); ok {
+		return cached.([]declaredField)
+	}
+
+	var fields []declaredField
+	for i := range desc.Fields().Len() {
+		field := desc.Fields().Get(i)
+		options, ok := field.Options().(*descriptorpb.FieldOptions)
+		if !ok || !proto.HasExtension(options, E_Rules) {
+			continue
+		}
+		declared := proto.GetExtension(options, E_Rules).(*FieldRules)
+		if rules := declared.rules(); len(rules) > 0 {
+			fields = append(fields, declaredField{field: field, rules: rules})
+		}
+	}
+	declaredRules.Store(desc, fields)
+	return fields
+}
+
+// rules converte a opção do .proto nas regras equivalentes do builder.
+func (d *FieldRules) rules() []FieldRule {
+	var rules []FieldRule
+	if d.GetRequired() {
+		rules = append(rules, Required())
+	}
+	if d.MinLen != nil || d.MaxLen != nil {
+		rules = append(rules, Length(int(d.GetMinLen()), int(d.GetMaxLen())))
+	}
+	if d.GetPattern() != "" {
+		// Um padrão inválido no .proto recusa o campo em vez de derrubar a leitura
+		re, err := regexp.Compile(d.GetPattern())
+		if err != nil {
+			desc := fmt.Sprintf("padrão inválido: %v", err)
+			rules = append(rules, FieldRule{name: RulePattern, check: func(protoreflect.FieldDescriptor, protoreflect.Value, bool) string {
+				return desc
+			}})
+		} else {
+			rules = append(rules, patternRule(d.GetPattern(), re))
+		}
+	}
+	if d.Min != nil || d.Max != nil {
+		min, max := math.Inf(-1), math.Inf(1)
+		if d.Min != nil {
+			min = d.GetMin()
+		}
+		if d.Max != nil {
+			max = d.GetMax()
+		}
+		rules = append(rules, Range(min, max))
+	}
+	return rules
+}
+
+// checkDeclared aplica as regras do .proto à mensagem e às mensagens aninhadas presentes.
+func checkDeclared(m protoreflect.Message, prefix string, violations *[]*FieldViolation) {
+	for _, f := range rulesOf(m.Descriptor()) {
+		*violations = applyRules(*violations, prefix+string(f.field.Name()), f.rules, f.field, m.Get(f.field), m.Has(f.field))
+	}
+
+	fields := m.Descriptor().Fields()
+	for i := range fields.Len() {
+		field := fields.Get(i)
+		if field.Message() == nil || field.IsMap() || !m.Has(field) {
+			continue
+		}
+		path := prefix + string(field.Name())
+		if !field.IsList() {
+			checkDeclared(m.Get(field).Message(), path+".", violations)
+			continue
+		}
+		list := m.Get(field).List()
+		for j := range list.Len() {
+			checkDeclared(list.Get(j).Message(), fmt.Sprintf("%s[%d].", path, j), violations)
+		}
+	}
+}
+
+// SetValidation define as regras das mensagens recebidas; nil mantém só as
+// declaradas no .proto.
+func (s *Socket) SetValidation(rules *ValidationRules) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.validation = rules
+}
+
+// SetValidation define as regras dos próximos sockets, compartilhadas entre eles.
+func (s *Server) SetValidation(rules *ValidationRules) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.validation = rules
+}
+
+// reject devolve a recusa ao remetente: na resposta do ack ou, sem ack, no
+// evento $invalid para quem o entende.
+func (s *Socket) reject(meta MessageMetadata, msg *Message, invalid *ValidationError) {
+	s.reportError(fmt.Errorf("mensagem recusada: %w", invalid))
+	s.confirmDelivery(msg)
+	if msg.AckId != "" {
+		s.dispatch(meta, func() { s.reply(msg.AckId, nil, invalid) })
+		return
+	}
+	if !s.HasRemoteFeature(FeatureValidation) {
+		return
+	}
+	envelope, err := newEnvelope(ValidationEvent, invalid, "", 0)
+	if err == nil {
+		err = s.send(envelope)
+	}
+	if err != nil {
+		s.reportError(fmt.Errorf("erro ao devolver recusa de %s: %w", msg.MessageId, err))
+	}
+}
+
+// OnValidationError registra um callback chamado quando o servidor recusa uma
+// mensagem enviada sem ack. Sem callback, a recusa é apenas registrada no log.
+func (c *Client) OnValidationError(callback func(client *Client, err *ValidationError)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onInvalid = callback
+}
+
+func (c *Client) handleInvalid(msg *Message) {
+	var invalid ValidationError
+	if err := proto.Unmarshal(msg.Data, &invalid); err != nil {
+		c.reportError(fmt.Errorf("recusa de validação inválida: %w", err))
+		return
+	}
+
+	c.lock.Lock()
+	callback := c.onInvalid
+	c.lock.Unlock()
+	if callback != nil {
+		callback(c, &invalid)
+		return
+	}
+	c.logger.Warn("mensagem recusada pelo servidor",
+		zap.String("evento", invalid.Event),
+		zap.String("id", invalid.MessageId),
+		zap.Error(&invalid))
+}
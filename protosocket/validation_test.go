@@ -0,0 +1,396 @@
+package protosocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// violated lista "campo:regra" de cada violação, na ordem.
+func violated(err *ValidationError) []string {
+	var got []string
+	for _, v := range err.GetViolations() {
+		got = append(got, v.GetField()+":"+v.GetRule())
+	}
+	return got
+}
+
+func TestFieldRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		rules []FieldRule
+		msg   proto.Message
+		want  []string
+	}{
+		{"obrigatório ausente", "content", []FieldRule{Required()}, &ChatMessage{}, []string{"content:required"}},
+		{"obrigatório presente", "content", []FieldRule{Required()}, &ChatMessage{Content: "oi"}, nil},
+		{"ausente ignora as outras regras", "content", []FieldRule{Length(1, 5), Pattern("^a")}, &ChatMessage{}, nil},
+		{"texto longo", "content", []FieldRule{Length(1, 5)}, &ChatMessage{Content: "abcdef"}, []string{"content:length"}},
+		{"tamanho em caracteres", "content", []FieldRule{Length(1, 4)}, &ChatMessage{Content: "ação"}, nil},
+		{"tamanho mínimo sem máximo", "content", []FieldRule{Length(3, 0)}, &ChatMessage{Content: "oi"}, []string{"content:length"}},
+		{"bytes", "checksum", []FieldRule{Length(32, 32)}, &FileOffer{Checksum: make([]byte, 16)}, []string{"checksum:length"}},
+		{"padrão satisfeito", "sender", []FieldRule{Pattern("^[a-z]+$")}, &ChatMessage{Sender: "ana"}, nil},
+		{"padrão violado", "sender", []FieldRule{Pattern("^[a-z]+$")}, &ChatMessage{Sender: "Ana"}, []string{"sender:pattern"}},
+		{"dentro do intervalo", "size", []FieldRule{Range(1, 1024)}, &FileOffer{Size: 1024}, nil},
+		{"fora do intervalo", "size", []FieldRule{Range(1, 1024)}, &FileOffer{Size: 1025}, []string{"size:range"}},
+		{"intervalo em texto", "content", []FieldRule{Range(0, 1)}, &ChatMessage{Content: "x"}, []string{"content:range"}},
+		{
+			name:  "campo aninhado",
+			path:  "service.name",
+			rules: []FieldRule{Required(), Length(1, 3)},
+			msg:   &ChatMessage{Service: &ServiceInfo{Name: "longo"}},
+			want:  []string{"service.name:length"},
+		},
+		{
+			name:  "mapa aninhado",
+			path:  "service.metadata",
+			rules: []FieldRule{Length(0, 1)},
+			msg:   &ChatMessage{Service: &ServiceInfo{Metadata: map[string]string{"a": "1", "b": "2"}}},
+			want:  []string{"service.metadata:length"},
+		},
+		{"intermediária ausente", "service.name", []FieldRule{Required()}, &ChatMessage{}, []string{"service.name:required"}},
+		{"intermediária ausente sem required", "service.name", []FieldRule{Length(1, 3)}, &ChatMessage{}, nil},
+		{"caminho inexistente", "nada", []FieldRule{Required(), Length(1, 3)}, &ChatMessage{}, []string{"nada:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := NewValidationRules()
+			rules.Event("evento").Field(tt.path, tt.rules...)
+			invalid := rules.checkMessage(&Message{Event: "evento"}, tt.msg)
+			if got := violated(invalid); !slices.Equal(got, tt.want) {
+				t.Errorf("violações = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternPanicsOnInvalidExpression(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Pattern aceitou uma expressão inválida")
+		}
+	}()
+	Pattern("(")
+}
+
+func TestCheckEnvelope(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules func() *ValidationRules
+		event string
+		size  int
+		rule  string
+	}{
+		{"sem regras", func() *ValidationRules { return nil }, "chat", 1 << 20, ""},
+		{"sem lista de eventos", NewValidationRules, "qualquer", 10, ""},
+		{"evento permitido", func() *ValidationRules { return NewValidationRules().Allow("chat") }, "chat", 10, ""},
+		{"evento não permitido", func() *ValidationRules { return NewValidationRules().Allow("chat") }, "outro", 10, RuleEvent},
+		{"evento com regras é permitido", func() *ValidationRules {
+			r := NewValidationRules().Allow("chat")
+			r.Event("upload")
+			return r
+		}, "upload", 10, ""},
+		{"dentro do limite", func() *ValidationRules { return NewValidationRules().MaxSize(100) }, "chat", 100, ""},
+		{"além do limite", func() *ValidationRules { return NewValidationRules().MaxSize(100) }, "chat", 101, RuleSize},
+		{"limite do evento", func() *ValidationRules {
+			r := NewValidationRules().MaxSize(100)
+			r.Event("upload").MaxSize(1000)
+			return r
+		}, "upload", 500, ""},
+		{"limite do evento excedido", func() *ValidationRules {
+			r := NewValidationRules().MaxSize(1000)
+			r.Event("chat").MaxSize(100)
+			return r
+		}, "chat", 500, RuleSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope := &Message{Event: tt.event, MessageId: "m1", Data: make([]byte, tt.size)}
+			invalid := tt.rules().checkEnvelope(envelope)
+			if tt.rule == "" {
+				if invalid != nil {
+					t.Fatalf("recusado: %v", invalid)
+				}
+				return
+			}
+			if invalid == nil {
+				t.Fatal("envelope aceito")
+			}
+			if got := violated(invalid); !slices.Equal(got, []string{":" + tt.rule}) {
+				t.Errorf("violações = %v, esperado a regra %s", got, tt.rule)
+			}
+			if invalid.GetEvent() != tt.event || invalid.GetMessageId() != "m1" {
+				t.Errorf("erro sem a identificação do envelope: %v", invalid)
+			}
+		})
+	}
+}
+
+// declaredMessages cria tipos dinâmicos com regras na opção (protosocket.rules),
+// como um .proto do usuário faria.
+func declaredMessages(t *testing.T) (outer, inner protoreflect.MessageDescriptor) {
+	t.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, rules *FieldRules) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     kind.Enum(),
+		}
+		if rules != nil {
+			f.Options = &descriptorpb.FieldOptions{}
+			proto.SetExtension(f.Options, E_Rules, rules)
+		}
+		return f
+	}
+	message := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := field(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, nil)
+		f.TypeName = proto.String(typeName)
+		return f
+	}
+	items := message("items", 4, ".validationtest.Inner")
+	items.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("validationtest/declared.proto"),
+		Package: proto.String("validationtest"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("code", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, &FieldRules{Pattern: "^[A-Z]+$"}),
+				},
+			},
+			{
+				Name: proto.String("Outer"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, &FieldRules{Required: true, MaxLen: proto.Uint32(5)}),
+					field("age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, &FieldRules{Min: proto.Float64(0), Max: proto.Float64(130)}),
+					message("inner", 3, ".validationtest.Inner"),
+					items,
+					field("broken", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, &FieldRules{Pattern: "("}),
+				},
+			},
+		},
+	}, new(protoregistry.Files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("Outer"), file.Messages().ByName("Inner")
+}
+
+func TestDeclaredRules(t *testing.T) {
+	outerDesc, innerDesc := declaredMessages(t)
+	newInner := func(code string) protoreflect.Message {
+		inner := dynamicpb.NewMessage(innerDesc)
+		inner.Set(innerDesc.Fields().ByName("code"), protoreflect.ValueOfString(code))
+		return inner
+	}
+
+	tests := []struct {
+		name  string
+		build func(m *dynamicpb.Message)
+		want  []string
+	}{
+		{"válida", func(m *dynamicpb.Message) {}, nil},
+		{"obrigatório ausente", func(m *dynamicpb.Message) {
+			m.Clear(outerDesc.Fields().ByName("name"))
+		}, []string{"name:required"}},
+		{"texto longo", func(m *dynamicpb.Message) {
+			m.Set(outerDesc.Fields().ByName("name"), protoreflect.ValueOfString("longo demais"))
+		}, []string{"name:length"}},
+		{"fora do intervalo", func(m *dynamicpb.Message) {
+			m.Set(outerDesc.Fields().ByName("age"), protoreflect.ValueOfInt32(-1))
+		}, []string{"age:range"}},
+		{"mensagem aninhada", func(m *dynamicpb.Message) {
+			m.Set(outerDesc.Fields().ByName("inner"), protoreflect.ValueOfMessage(newInner("abc")))
+		}, []string{"inner.code:pattern"}},
+		{"lista aninhada", func(m *dynamicpb.Message) {
+			list := m.Mutable(outerDesc.Fields().ByName("items")).List()
+			list.Append(protoreflect.ValueOfMessage(newInner("OK")))
+			list.Append(protoreflect.ValueOfMessage(newInner("ruim")))
+		}, []string{"items[1].code:pattern"}},
+		{"padrão inválido no proto", func(m *dynamicpb.Message) {
+			m.Set(outerDesc.Fields().ByName("broken"), protoreflect.ValueOfString("x"))
+		}, []string{"broken:pattern"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := dynamicpb.NewMessage(outerDesc)
+			m.Set(outerDesc.Fields().ByName("name"), protoreflect.ValueOfString("ana"))
+			m.Set(outerDesc.Fields().ByName("age"), protoreflect.ValueOfInt32(30))
+			tt.build(m)
+
+			// As regras declaradas valem mesmo sem ValidationRules
+			var rules *ValidationRules
+			invalid := rules.checkMessage(&Message{Event: "outer"}, m)
+			if got := violated(invalid); !slices.Equal(got, tt.want) {
+				t.Errorf("violações = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclaredRulesSameName(t *testing.T) {
+	// Dois tipos dinâmicos com o mesmo nome e regras diferentes, como as
+	// versões de um mesmo .proto carregadas em tempo de execução
+	declare := func(rules *FieldRules) protoreflect.MessageDescriptor {
+		name := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String("name"),
+			JsonName: proto.String("name"),
+			Number:   proto.Int32(1),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+		if rules != nil {
+			name.Options = &descriptorpb.FieldOptions{}
+			proto.SetExtension(name.Options, E_Rules, rules)
+		}
+		file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+			Name:    proto.String("validationtest/versioned.proto"),
+			Package: proto.String("validationtest"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Versioned"), Field: []*descriptorpb.FieldDescriptorProto{name}},
+			},
+		}, new(protoregistry.Files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file.Messages().ByName("Versioned")
+	}
+
+	tests := []struct {
+		name string
+		desc protoreflect.MessageDescriptor
+		want []string
+	}{
+		{"com regra", declare(&FieldRules{Required: true}), []string{"name:required"}},
+		{"sem regra", declare(nil), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules *ValidationRules
+			invalid := rules.checkMessage(&Message{Event: "versioned"}, dynamicpb.NewMessage(tt.desc))
+			if got := violated(invalid); !slices.Equal(got, tt.want) {
+				t.Errorf("violações = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	invalid := &ValidationError{
+		Event: "chat",
+		Violations: []*FieldViolation{
+			{Field: "content", Rule: RuleRequired, Description: "campo obrigatório"},
+			{Rule: RuleSize, Description: "grande demais"},
+		},
+	}
+	if !errors.Is(invalid, ErrInvalidMessage) {
+		t.Error("ValidationError não é ErrInvalidMessage")
+	}
+	want := ErrInvalidMessage.Error() + " em 'chat': content: campo obrigatório; grande demais"
+	if got := invalid.Error(); got != want {
+		t.Errorf("Error() = %q, esperado %q", got, want)
+	}
+}
+
+func TestValidationRejects(t *testing.T) {
+	server := NewServer()
+	rules := NewValidationRules().Allow("chat")
+	rules.Event("chat").Field("content", Required(), Length(1, 10))
+	server.SetValidation(rules)
+
+	handled := make(chan string, 8)
+	server.On("chat", func(msg proto.Message, socket *Socket) {
+		handled <- msg.(*ChatMessage).Content
+	})
+	server.OnWithAck("ask", func(msg proto.Message, socket *Socket) (proto.Message, error) {
+		return msg, nil
+	})
+	rules.Event("ask").Field("content", Required())
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	rejected := make(chan *ValidationError, 8)
+	client.OnValidationError(func(_ *Client, err *ValidationError) { rejected <- err })
+
+	tests := []struct {
+		name  string
+		event string
+		msg   *ChatMessage
+		ack   bool
+		want  []string
+	}{
+		{"válida", "chat", &ChatMessage{Content: "oi"}, false, nil},
+		{"campo obrigatório", "chat", &ChatMessage{}, false, []string{"content:required"}},
+		{"campo longo", "chat", &ChatMessage{Content: "muito longo aqui"}, false, []string{"content:length"}},
+		{"evento não permitido", "outro", &ChatMessage{Content: "oi"}, false, []string{":event"}},
+		{"com ack válida", "ask", &ChatMessage{Content: "oi"}, true, nil},
+		{"com ack recusada", "ask", &ChatMessage{}, true, []string{"content:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ack {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_, err := client.EmitWithAck(ctx, tt.event, tt.msg)
+				var invalid *ValidationError
+				if tt.want == nil {
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				if !errors.As(err, &invalid) {
+					t.Fatalf("erro = %v, esperado ValidationError", err)
+				}
+				if got := violated(invalid); !slices.Equal(got, tt.want) {
+					t.Errorf("violações = %v, esperado %v", got, tt.want)
+				}
+				return
+			}
+
+			if err := client.Emit(tt.event, tt.msg); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case content := <-handled:
+				if tt.want != nil {
+					t.Fatalf("mensagem inválida %q chegou ao handler", content)
+				}
+			case invalid := <-rejected:
+				if got := violated(invalid); !slices.Equal(got, tt.want) {
+					t.Errorf("violações = %v, esperado %v", got, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("nem entregue nem recusada")
+			}
+		})
+	}
+}
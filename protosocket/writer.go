@@ -0,0 +1,343 @@
+package protosocket
+
+import (
+	"compress/flate"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrQueueFull    = errors.New("fila de envio cheia")
+	ErrSlowConsumer = errors.New("consumidor lento desconectado")
+)
+
+// OverflowPolicy define o que fazer quando a fila de envio de uma conexão enche.
+type OverflowPolicy int
+
+const (
+	// DropOldest descarta a mensagem mais antiga da fila para abrir espaço.
+	DropOldest OverflowPolicy = iota
+	// DropNewest rejeita a mensagem nova com ErrQueueFull.
+	DropNewest
+	// Disconnect encerra a conexão do consumidor lento.
+	Disconnect
+)
+
+// WriterConfig configura a goroutine de escrita de cada conexão.
+type WriterConfig struct {
+	QueueSize    int
+	WriteTimeout time.Duration
+	Policy       OverflowPolicy
+}
+
+// DefaultWriterConfig retorna a configuração usada quando nenhuma é informada.
+func DefaultWriterConfig() WriterConfig {
+	return WriterConfig{
+		QueueSize:    256,
+		WriteTimeout: 10 * time.Second,
+		Policy:       DropOldest,
+	}
+}
+
+type outboundFrame struct {
+	msgType int
+	data    []byte
+	// fragments guarda os pedaços ainda não enviados de uma mensagem fragmentada
+	fragments [][]byte
+	// envelope é guardado apenas quando tem TTL, para o descarte na fila
+	envelope *Message
+	lane     Priority
+}
+
+// writePump é o único escritor de uma conexão, já que o gorilla/websocket
+// não permite escritas concorrentes.
+type writePump struct {
+	conn      *websocket.Conn
+	lanes     [laneCount][]outboundFrame
+	served    [laneCount]int
+	queueSize int
+	wake      chan struct{}
+	policy    OverflowPolicy
+	timeout   atomic.Int64
+	compress  atomic.Bool
+	level     atomic.Int64
+	lock      sync.Mutex
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	dropOnce  sync.Once
+	onError   func(error)
+	onExpired func(*Message)
+}
+
+func newWritePump(conn *websocket.Conn, config WriterConfig, onError func(error)) *writePump {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultWriterConfig().QueueSize
+	}
+
+	w := &writePump{
+		conn:      conn,
+		queueSize: config.QueueSize,
+		wake:      make(chan struct{}, 1),
+		policy:    config.Policy,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		onError:   onError,
+	}
+	w.timeout.Store(int64(config.WriteTimeout))
+	w.level.Store(int64(flate.DefaultCompression))
+
+	go w.run()
+	return w
+}
+
+// setTimeout altera o prazo de escrita das próximas mensagens.
+func (w *writePump) setTimeout(timeout time.Duration) {
+	w.timeout.Store(int64(timeout))
+}
+
+// setCompression liga ou desliga o permessage-deflate nas próximas escritas.
+// O ajuste é aplicado pela goroutine de escrita, que é a dona da conexão.
+func (w *writePump) setCompression(enabled bool, level int) {
+	w.level.Store(int64(level))
+	w.compress.Store(enabled)
+}
+
+// enqueue coloca o frame na fila aplicando a política de overflow.
+func (w *writePump) enqueue(msgType int, data []byte) error {
+	return w.push(outboundFrame{msgType: msgType, data: data})
+}
+
+// enqueueEnvelope coloca um envelope serializado na fila. Se ele tiver TTL e
+// expirar antes da sua vez, é descartado sem ser escrito.
+func (w *writePump) enqueueEnvelope(msgType int, data []byte, fragments [][]byte, envelope *Message) error {
+	frame := outboundFrame{msgType: msgType, data: data, fragments: fragments, lane: envelope.lane()}
+	if envelope.GetTtl() > 0 {
+		frame.envelope = envelope
+	}
+	return w.push(frame)
+}
+
+// push coloca o frame na fila da sua prioridade. QueueSize e a política de
+// overflow valem para cada fila separadamente; a fila de controle nunca
+// descarta frames já aceitos e, cheia, recusa o novo com ErrQueueFull.
+func (w *writePump) push(frame outboundFrame) error {
+	w.lock.Lock()
+
+	select {
+	case <-w.done:
+		w.lock.Unlock()
+		return ErrConnectionClosed
+	default:
+	}
+
+	lane := &w.lanes[frame.lane]
+	if len(*lane) >= w.queueSize {
+		switch {
+		case w.policy == Disconnect:
+			// O callback de erro pode voltar a enviar pelo socket, então o
+			// desligamento roda fora do lock e da chamada
+			w.lock.Unlock()
+			go w.disconnect(ErrSlowConsumer)
+			return ErrSlowConsumer
+		case w.policy == DropNewest || frame.lane == PriorityControl:
+			w.lock.Unlock()
+			return ErrQueueFull
+		default:
+			// Descarta a mais antiga da mesma fila para abrir espaço
+			(*lane)[0] = outboundFrame{}
+			*lane = (*lane)[1:]
+		}
+	}
+	*lane = append(*lane, frame)
+	w.lock.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next tira o próximo frame a enviar: a fila de controle primeiro e as demais
+// em rodízio ponderado por laneWeights. Com holdNormal, a fila normal espera:
+// os envelopes dela são sequenciados e não podem passar à frente de um
+// fragmentado da mesma fila.
+func (w *writePump) next(holdNormal bool) (outboundFrame, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.lanes[PriorityControl]) > 0 {
+		return w.pop(PriorityControl), true
+	}
+	for range 2 {
+		for _, lane := range laneSchedule {
+			if holdNormal && lane == PriorityNormal {
+				continue
+			}
+			if len(w.lanes[lane]) > 0 && w.served[lane] < laneWeights[lane] {
+				w.served[lane]++
+				return w.pop(lane), true
+			}
+		}
+		// As filas com tráfego gastaram a cota da rodada
+		w.served = [laneCount]int{}
+	}
+	return outboundFrame{}, false
+}
+
+// pop remove o primeiro frame da fila. Deve ser chamado com o lock.
+func (w *writePump) pop(lane Priority) outboundFrame {
+	frame := w.lanes[lane][0]
+	w.lanes[lane][0] = outboundFrame{}
+	w.lanes[lane] = w.lanes[lane][1:]
+	return frame
+}
+
+// pending retorna quantas mensagens aguardam envio.
+func (w *writePump) pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	total := 0
+	for _, lane := range w.lanes {
+		total += len(lane)
+	}
+	return total
+}
+
+func (w *writePump) run() {
+	defer close(w.stopped)
+
+	// Mensagens fragmentadas em andamento, atendidas em rodízio
+	var active []*outboundFrame
+	for {
+		select {
+		case <-w.done:
+			w.flush(active)
+			return
+		default:
+		}
+
+		wrote, err := w.step(&active)
+		if err != nil {
+			w.disconnect(err)
+			return
+		}
+		if wrote {
+			continue
+		}
+
+		select {
+		case <-w.wake:
+		case <-w.done:
+			w.flush(active)
+			return
+		}
+	}
+}
+
+// step envia um frame ou um fragmento e indica se havia algo a enviar. Com
+// fragmentos pendentes, as mensagens comuns já na fila passam na frente, exceto
+// as da fila normal atrás de um fragmentado dela.
+func (w *writePump) step(active *[]*outboundFrame) (bool, error) {
+	holdNormal := false
+	for _, frame := range *active {
+		if frame.lane == PriorityNormal {
+			holdNormal = true
+			break
+		}
+	}
+	if frame, ok := w.next(holdNormal); ok {
+		return true, w.handle(frame, active)
+	}
+	if len(*active) > 0 {
+		return true, w.writeFragment(active)
+	}
+	return false, nil
+}
+
+// handle envia um frame comum ou adiciona uma mensagem fragmentada ao rodízio.
+func (w *writePump) handle(frame outboundFrame, active *[]*outboundFrame) error {
+	// Mensagens já começadas não são interrompidas
+	if frame.envelope != nil && frame.envelope.expired(time.Now()) {
+		if w.onExpired != nil {
+			w.onExpired(frame.envelope)
+		}
+		return nil
+	}
+	if len(frame.fragments) > 0 {
+		*active = append(*active, &frame)
+		return nil
+	}
+	return w.write(frame)
+}
+
+// writeFragment envia o próximo fragmento da primeira mensagem do rodízio.
+func (w *writePump) writeFragment(active *[]*outboundFrame) error {
+	frame := (*active)[0]
+	err := w.write(outboundFrame{msgType: frame.msgType, data: frame.fragments[0]})
+	frame.fragments = frame.fragments[1:]
+
+	*active = (*active)[1:]
+	if len(frame.fragments) > 0 {
+		*active = append(*active, frame)
+	}
+	return err
+}
+
+// flush envia o que restou na fila antes de encerrar.
+func (w *writePump) flush(active []*outboundFrame) {
+	for {
+		wrote, err := w.step(&active)
+		if err != nil || !wrote {
+			return
+		}
+	}
+}
+
+func (w *writePump) write(frame outboundFrame) error {
+	if timeout := time.Duration(w.timeout.Load()); timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	compress := w.compress.Load()
+	w.conn.EnableWriteCompression(compress)
+	if compress {
+		w.conn.SetCompressionLevel(int(w.level.Load()))
+	}
+	return w.conn.WriteMessage(frame.msgType, frame.data)
+}
+
+// disconnect encerra a conexão, o que também faz o loop de leitura retornar.
+func (w *writePump) disconnect(reason error) {
+	w.dropOnce.Do(func() {
+		if w.onError != nil {
+			w.onError(reason)
+		}
+
+		code := websocket.CloseInternalServerErr
+		if errors.Is(reason, ErrSlowConsumer) {
+			code = websocket.CloseTryAgainLater
+		}
+		w.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason.Error()),
+			time.Now().Add(time.Second))
+		w.conn.Close()
+		w.stop()
+	})
+}
+
+// stop encerra a goroutine de escrita após esvaziar a fila.
+func (w *writePump) stop() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// wait bloqueia até a goroutine de escrita terminar.
+func (w *writePump) wait() {
+	<-w.stopped
+}
@@ -0,0 +1,151 @@
+package protosocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair devolve as duas pontas de uma conexão WebSocket de teste.
+func wsPair(t *testing.T) (local, remote *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	remote, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local = <-accepted
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return local, remote
+}
+
+// idleWritePump cria uma fila sem a goroutine de escrita, para que ela encha.
+func idleWritePump(conn *websocket.Conn, queueSize int, policy OverflowPolicy, onError func(error)) *writePump {
+	return &writePump{
+		conn:      conn,
+		queueSize: queueSize,
+		wake:      make(chan struct{}, 1),
+		policy:    policy,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		onError:   onError,
+	}
+}
+
+func TestWritePumpOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		err    error
+		kept   []byte
+		code   int
+	}{
+		{"descarta a mais antiga", DropOldest, nil, []byte{1, 2}, 0},
+		{"recusa a nova", DropNewest, ErrQueueFull, []byte{0, 1}, 0},
+		{"desconecta o consumidor lento", Disconnect, ErrSlowConsumer, []byte{0, 1}, websocket.CloseTryAgainLater},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := wsPair(t)
+			reasons := make(chan error, 4)
+			var w *writePump
+			w = idleWritePump(local, 2, tt.policy, func(err error) {
+				// O callback pode voltar a enviar pelo socket sem travar a fila
+				w.enqueue(websocket.BinaryMessage, []byte{9})
+				reasons <- err
+			})
+
+			var err error
+			for i := range 3 {
+				if e := w.enqueue(websocket.BinaryMessage, []byte{byte(i)}); e != nil {
+					err = e
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.err)
+			}
+
+			var kept []byte
+			for w.pending() > 0 {
+				frame, _ := w.next(false)
+				kept = append(kept, frame.data[0])
+			}
+			if !slices.Equal(kept, tt.kept) {
+				t.Errorf("fila = %v, esperado %v", kept, tt.kept)
+			}
+
+			if tt.code == 0 {
+				select {
+				case reason := <-reasons:
+					t.Errorf("conexão encerrada: %v", reason)
+				default:
+				}
+				return
+			}
+
+			select {
+			case reason := <-reasons:
+				if !errors.Is(reason, tt.err) {
+					t.Errorf("motivo = %v, esperado %v", reason, tt.err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("consumidor lento não foi desconectado")
+			}
+			remote.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := remote.ReadMessage(); !websocket.IsCloseError(err, tt.code) {
+				t.Errorf("leitura = %v, esperado fechamento %d", err, tt.code)
+			}
+			<-w.done
+			if err := w.enqueue(websocket.BinaryMessage, []byte{3}); !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("envio depois de desconectar = %v, esperado %v", err, ErrConnectionClosed)
+			}
+		})
+	}
+}
+
+func TestWritePumpFlushOnStop(t *testing.T) {
+	local, remote := wsPair(t)
+	w := newWritePump(local, DefaultWriterConfig(), nil)
+	for i := range 3 {
+		if err := w.enqueue(websocket.BinaryMessage, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.stop()
+	w.wait()
+
+	// O que estava na fila é enviado em ordem antes de encerrar
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	for i := range 3 {
+		_, data, err := remote.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != byte(i) {
+			t.Errorf("mensagem %d = %v", i, data)
+		}
+	}
+	if err := w.enqueue(websocket.BinaryMessage, []byte{3}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("envio depois de parar = %v, esperado %v", err, ErrConnectionClosed)
+	}
+}